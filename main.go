@@ -5,12 +5,25 @@ import (
 	"github.com/openspacee/ospagent/pkg/config"
 	"github.com/openspacee/ospagent/pkg/core"
 	"k8s.io/klog"
+	"time"
 )
 
 var (
 	kubeConfigFile = flag.String("kubeconfig", "", "Path to kubeconfig file with authorization and master location information.")
 	agentToken     = flag.String("token", "", "Agent token to connect to server.")
 	serverUrl      = flag.String("server-url", "", "Server url agent to connect.")
+
+	sessionIdleTimeout = flag.Duration("session-idle-timeout", 30*time.Minute, "Close exec and log sessions idle longer than this, 0 never closes.")
+	maxSessions        = flag.Int("max-sessions", 100, "Max exec and log sessions of the agent, 0 is unlimited.")
+	maxPodSessions     = flag.Int("max-pod-sessions", 10, "Max exec and log sessions of one pod, 0 is unlimited.")
+
+	nodeShellNamespace = flag.String("node-shell-namespace", "kube-system", "Namespace of the node shell helper pods.")
+	nodeShellImage     = flag.String("node-shell-image", "busybox:1.31", "Image of the node shell helper pods, it needs nsenter.")
+
+	metricsInterval        = flag.Duration("metrics-interval", 30*time.Second, "Interval sampling the node and pod usage into the metrics history, 0 disables the history.")
+	metricsRetention       = flag.Duration("metrics-retention", time.Hour, "Retention of the raw samples of the metrics history.")
+	metricsRollupInterval  = flag.Duration("metrics-rollup-interval", time.Minute, "Interval of the averaged rollups of the metrics history.")
+	metricsRollupRetention = flag.Duration("metrics-rollup-retention", 24*time.Hour, "Retention of the rollups of the metrics history.")
 )
 
 func createAgentOptions() *config.AgentOptions {
@@ -18,6 +31,18 @@ func createAgentOptions() *config.AgentOptions {
 		KubeConfigFile: *kubeConfigFile,
 		AgentToken:     *agentToken,
 		ServerUrl:      *serverUrl,
+
+		SessionIdleTimeout: *sessionIdleTimeout,
+		MaxSessions:        *maxSessions,
+		MaxPodSessions:     *maxPodSessions,
+
+		NodeShellNamespace: *nodeShellNamespace,
+		NodeShellImage:     *nodeShellImage,
+
+		MetricsInterval:        *metricsInterval,
+		MetricsRetention:       *metricsRetention,
+		MetricsRollupInterval:  *metricsRollupInterval,
+		MetricsRollupRetention: *metricsRollupRetention,
 	}
 }
 
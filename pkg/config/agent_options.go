@@ -1,7 +1,19 @@
 package config
 
+import "time"
+
 type AgentOptions struct {
-	KubeConfigFile string
-	AgentToken     string
-	ServerUrl      string
+	KubeConfigFile     string
+	AgentToken         string
+	ServerUrl          string
+	SessionIdleTimeout time.Duration
+	MaxSessions        int
+	MaxPodSessions     int
+	NodeShellNamespace string
+	NodeShellImage     string
+
+	MetricsInterval        time.Duration
+	MetricsRetention       time.Duration
+	MetricsRollupInterval  time.Duration
+	MetricsRollupRetention time.Duration
 }
@@ -3,6 +3,7 @@ package container
 import (
 	"encoding/json"
 	"fmt"
+	"github.com/openspacee/ospagent/pkg/container/resource"
 	"github.com/openspacee/ospagent/pkg/kubernetes"
 	"github.com/openspacee/ospagent/pkg/utils"
 	"github.com/openspacee/ospagent/pkg/websocket"
@@ -12,39 +13,64 @@ import (
 
 type Container struct {
 	KubeClient   *kubernetes.KubeClient
+	Sessions     *resource.SessionManager
 	RequestChan  chan *utils.Request
 	ResponseChan chan *utils.TResponse
+	StreamChan   chan *utils.Frame
 	*ResourceActions
 	websocket.SendResponse
 }
 
 func NewContainer(
 	kubeClient *kubernetes.KubeClient,
+	sessions *resource.SessionManager,
+	metricsHistory *resource.MetricsHistory,
+	nodeShell *resource.NodeShellOptions,
 	requestChan chan *utils.Request,
 	responseChan chan *utils.TResponse,
-	sendResponse websocket.SendResponse) *Container {
+	streamChan chan *utils.Frame,
+	sendResponse websocket.SendResponse,
+	sendStream websocket.SendStream) *Container {
 
-	resourceActions := NewResourceActions(kubeClient, sendResponse)
+	resourceActions := NewResourceActions(kubeClient, sessions, metricsHistory, nodeShell, sendResponse, sendStream)
 	return &Container{
 		KubeClient:      kubeClient,
+		Sessions:        sessions,
 		RequestChan:     requestChan,
 		ResponseChan:    responseChan,
+		StreamChan:      streamChan,
 		ResourceActions: resourceActions,
 		SendResponse:    sendResponse,
 	}
 }
 
 func (c *Container) Run() {
+	go c.dispatchStreams()
 	for {
 		select {
 		case req, ok := <-c.RequestChan:
-			if ok {
+			if !ok {
+				continue
+			}
+			if c.GetOrderedHandler(req.Resource, req.Action) != nil {
+				// the session input is queued here in the order of the requests, only the response is sent aside
+				resp := c.doRequest(req)
+				go c.SendResponse(resp, req.RequestId, utils.RequestType)
+			} else {
 				go c.handleRequest(req)
 			}
 		}
 	}
 }
 
+// dispatchStreams queues the stream frames to their sessions, each session handles its frames in order
+// so stdin is not reordered, and a stalled session does not block the others.
+func (c *Container) dispatchStreams() {
+	for frame := range c.StreamChan {
+		c.Sessions.Dispatch(frame)
+	}
+}
+
 func (c *Container) handleRequest(request *utils.Request) {
 	resp := c.doRequest(request)
 	//tResp := &utils.TResponse{RequestId: request.RequestId, Data: resp}
@@ -68,13 +94,16 @@ func (c *Container) doRequest(request *utils.Request) (resp *utils.Response) {
 	params := request.Params
 	handler := c.GetRequestHandler(resource, action)
 	resp = &utils.Response{}
-	if handler == nil {
+	if handler != nil {
+		jsonParams, _ := json.Marshal(params)
+		resp = handler(jsonParams)
+	} else if streamHandler := c.GetStreamHandler(resource, action); streamHandler != nil {
+		jsonParams, _ := json.Marshal(params)
+		resp = streamHandler(request.RequestId, jsonParams)
+	} else {
 		msg := fmt.Sprintf("resource %s action %s not found", resource, action)
 		klog.Error(msg)
 		resp = &utils.Response{Code: "ActionError", Msg: msg}
-	} else {
-		jsonParams, _ := json.Marshal(params)
-		resp = handler(jsonParams)
 	}
 	return
 }
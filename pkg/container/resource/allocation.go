@@ -0,0 +1,114 @@
+package resource
+
+import (
+	"k8s.io/api/core/v1"
+	"math"
+)
+
+// BuildAllocation is the sum of requests and limits of the non-terminated pods, the percentages are of the allocatable.
+type BuildAllocation struct {
+	CpuRequests           string  `json:"cpu_requests"`
+	CpuLimits             string  `json:"cpu_limits"`
+	MemoryRequests        string  `json:"memory_requests"`
+	MemoryLimits          string  `json:"memory_limits"`
+	CpuRequestsPercent    float64 `json:"cpu_requests_percent"`
+	CpuLimitsPercent      float64 `json:"cpu_limits_percent"`
+	MemoryRequestsPercent float64 `json:"memory_requests_percent"`
+	MemoryLimitsPercent   float64 `json:"memory_limits_percent"`
+	Pods                  int     `json:"pods"`
+	MaxPods               int64   `json:"max_pods"`
+}
+
+func podTerminated(pod *v1.Pod) bool {
+	return pod.Status.Phase == v1.PodSucceeded || pod.Status.Phase == v1.PodFailed
+}
+
+func addResourceList(list, add v1.ResourceList) {
+	for name, quantity := range add {
+		if value, ok := list[name]; ok {
+			value.Add(quantity)
+			list[name] = value
+		} else {
+			list[name] = quantity.DeepCopy()
+		}
+	}
+}
+
+// maxResourceList sets the list to the max of the list and the other.
+func maxResourceList(list, other v1.ResourceList) {
+	for name, quantity := range other {
+		if value, ok := list[name]; !ok || quantity.Cmp(value) > 0 {
+			list[name] = quantity.DeepCopy()
+		}
+	}
+}
+
+// podRequestsAndLimits is the effective resources of the pod like the scheduler, the sum of the containers
+// or the max of the init containers, which run one by one.
+func podRequestsAndLimits(pod *v1.Pod) (requests, limits v1.ResourceList) {
+	requests, limits = v1.ResourceList{}, v1.ResourceList{}
+	for _, container := range pod.Spec.Containers {
+		addResourceList(requests, container.Resources.Requests)
+		addResourceList(limits, container.Resources.Limits)
+	}
+	for _, container := range pod.Spec.InitContainers {
+		maxResourceList(requests, container.Resources.Requests)
+		maxResourceList(limits, container.Resources.Limits)
+	}
+	return
+}
+
+func percent(value, total float64) float64 {
+	if total == 0 {
+		return 0
+	}
+	return math.Round(value/total*100*100) / 100
+}
+
+// computeAllocation sums the resources of the non-terminated pods against the allocatable.
+func computeAllocation(pods []*v1.Pod, allocatable v1.ResourceList) *BuildAllocation {
+	requests, limits := v1.ResourceList{}, v1.ResourceList{}
+	count := 0
+	for _, pod := range pods {
+		if podTerminated(pod) {
+			continue
+		}
+		count += 1
+		podRequests, podLimits := podRequestsAndLimits(pod)
+		addResourceList(requests, podRequests)
+		addResourceList(limits, podLimits)
+	}
+	return &BuildAllocation{
+		CpuRequests:           requests.Cpu().String(),
+		CpuLimits:             limits.Cpu().String(),
+		MemoryRequests:        requests.Memory().String(),
+		MemoryLimits:          limits.Memory().String(),
+		CpuRequestsPercent:    percent(float64(requests.Cpu().MilliValue()), float64(allocatable.Cpu().MilliValue())),
+		CpuLimitsPercent:      percent(float64(limits.Cpu().MilliValue()), float64(allocatable.Cpu().MilliValue())),
+		MemoryRequestsPercent: percent(float64(requests.Memory().Value()), float64(allocatable.Memory().Value())),
+		MemoryLimitsPercent:   percent(float64(limits.Memory().Value()), float64(allocatable.Memory().Value())),
+		Pods:                  count,
+		MaxPods:               allocatable.Pods().Value(),
+	}
+}
+
+// scheduledPods filters the pods bound to a node, the same pods counted by the allocation of the nodes.
+func scheduledPods(pods []*v1.Pod) []*v1.Pod {
+	var scheduled []*v1.Pod
+	for _, pod := range pods {
+		if pod.Spec.NodeName != "" {
+			scheduled = append(scheduled, pod)
+		}
+	}
+	return scheduled
+}
+
+func podsByNode(pods []*v1.Pod) map[string][]*v1.Pod {
+	nodePods := make(map[string][]*v1.Pod)
+	for _, pod := range pods {
+		if pod.Spec.NodeName != "" {
+			nodePods[pod.Spec.NodeName] = append(nodePods[pod.Spec.NodeName], pod)
+		}
+	}
+	return nodePods
+}
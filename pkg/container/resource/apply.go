@@ -0,0 +1,429 @@
+package resource
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/openspacee/ospagent/pkg/utils"
+	"github.com/openspacee/ospagent/pkg/utils/code"
+	"io"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/wait"
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/klog"
+	"sort"
+	"time"
+)
+
+const (
+	ApplySetLabel       = "ospagent.openspacee.io/apply-set"
+	defaultFieldManager = "ospagent"
+	defaultApplyTimeout = 300
+
+	ApplyActionApply = "apply"
+	ApplyActionPrune = "prune"
+)
+
+// installOrder is the order helm installs kinds in, kinds not listed are applied last.
+var installOrder = []string{
+	"Namespace",
+	"NetworkPolicy",
+	"ResourceQuota",
+	"LimitRange",
+	"PodSecurityPolicy",
+	"PodDisruptionBudget",
+	"ServiceAccount",
+	"Secret",
+	"SecretList",
+	"ConfigMap",
+	"StorageClass",
+	"PersistentVolume",
+	"PersistentVolumeClaim",
+	"CustomResourceDefinition",
+	"ClusterRole",
+	"ClusterRoleList",
+	"ClusterRoleBinding",
+	"ClusterRoleBindingList",
+	"Role",
+	"RoleList",
+	"RoleBinding",
+	"RoleBindingList",
+	"Service",
+	"DaemonSet",
+	"Pod",
+	"ReplicationController",
+	"ReplicaSet",
+	"Deployment",
+	"HorizontalPodAutoscaler",
+	"StatefulSet",
+	"Job",
+	"CronJob",
+	"Ingress",
+	"APIService",
+}
+
+// defaultPruneKinds are always checked for pruning besides the kinds in the applied yaml.
+var defaultPruneKinds = []schema.GroupVersionKind{
+	{Group: "", Version: "v1", Kind: "ConfigMap"},
+	{Group: "", Version: "v1", Kind: "PersistentVolumeClaim"},
+	{Group: "", Version: "v1", Kind: "Pod"},
+	{Group: "", Version: "v1", Kind: "Secret"},
+	{Group: "", Version: "v1", Kind: "Service"},
+	{Group: "apps", Version: "v1", Kind: "DaemonSet"},
+	{Group: "apps", Version: "v1", Kind: "Deployment"},
+	{Group: "apps", Version: "v1", Kind: "StatefulSet"},
+	{Group: "batch", Version: "v1", Kind: "Job"},
+	{Group: "batch", Version: "v1beta1", Kind: "CronJob"},
+	{Group: "extensions", Version: "v1beta1", Kind: "Ingress"},
+}
+
+func installOrderIndex(kind string) int {
+	for i, k := range installOrder {
+		if k == kind {
+			return i
+		}
+	}
+	return len(installOrder)
+}
+
+type ApplyParams struct {
+	YamlStr      string `json:"yaml"`
+	Prune        bool   `json:"prune"`
+	ApplySet     string `json:"apply_set"`
+	Force        bool   `json:"force"`
+	Wait         bool   `json:"wait"`
+	Timeout      int    `json:"timeout"`
+	FieldManager string `json:"field_manager"`
+}
+
+type ApplyResult struct {
+	Kind      string `json:"kind"`
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+	Action    string `json:"action"`
+	Success   bool   `json:"success"`
+	Ready     bool   `json:"ready"`
+	Msg       string `json:"msg"`
+}
+
+type appliedObject struct {
+	obj    *unstructured.Unstructured
+	dr     dynamic.ResourceInterface
+	result *ApplyResult
+}
+
+func (d *DynamicResource) ApplyYaml(applyParams interface{}) *utils.Response {
+	params := &ApplyParams{}
+	json.Unmarshal(applyParams.([]byte), params)
+	if params.Prune && params.ApplySet == "" {
+		return &utils.Response{Code: code.ParamsError, Msg: "Apply set is blank when prune"}
+	}
+	if params.FieldManager == "" {
+		params.FieldManager = defaultFieldManager
+	}
+	if params.Timeout <= 0 {
+		params.Timeout = defaultApplyTimeout
+	}
+	deadline := time.Now().Add(time.Duration(params.Timeout) * time.Second)
+
+	var results []*ApplyResult
+	var objs []*unstructured.Unstructured
+	multidocReader := utilyaml.NewYAMLReader(bufio.NewReader(bytes.NewReader([]byte(params.YamlStr))))
+	for {
+		buf, err := multidocReader.Read()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return &utils.Response{Code: code.ParamsError, Msg: "read yaml error: " + err.Error()}
+		}
+		if len(bytes.TrimSpace(buf)) == 0 {
+			continue
+		}
+		obj, err := d.decodeYaml(buf)
+		if err != nil {
+			results = append(results, &ApplyResult{Action: ApplyActionApply, Msg: err.Error()})
+			continue
+		}
+		objs = append(objs, obj)
+	}
+	sort.SliceStable(objs, func(i, j int) bool {
+		return installOrderIndex(objs[i].GetKind()) < installOrderIndex(objs[j].GetKind())
+	})
+
+	var applied []*appliedObject
+	var crds []*appliedObject
+	appliedKeys := sets.NewString()
+	pruneKinds := make(map[schema.GroupKind]schema.GroupVersionKind)
+	namespaces := sets.NewString()
+	for _, obj := range objs {
+		if len(crds) > 0 && obj.GetKind() != "CustomResourceDefinition" {
+			// custom resources need the new kinds to be served and discovered before mapping
+			d.waitEstablished(crds, deadline)
+			d.restMapper.Reset()
+			crds = nil
+		}
+		ao := d.applyObject(obj, params)
+		results = append(results, ao.result)
+		if !ao.result.Success {
+			continue
+		}
+		applied = append(applied, ao)
+		if obj.GetKind() == "CustomResourceDefinition" {
+			crds = append(crds, ao)
+		}
+		appliedKeys.Insert(applyKey(obj.GroupVersionKind(), obj.GetNamespace(), obj.GetName()))
+		pruneKinds[obj.GroupVersionKind().GroupKind()] = obj.GroupVersionKind()
+		if obj.GetNamespace() != "" {
+			namespaces.Insert(obj.GetNamespace())
+		}
+	}
+
+	if params.Wait {
+		for _, ao := range applied {
+			d.waitReady(ao, deadline)
+		}
+	}
+
+	// like kubectl apply --prune, nothing is pruned when an object failed, as its live copy still carries the
+	// apply set label and would be deleted
+	pruneSkipped := false
+	for _, r := range results {
+		if !r.Success {
+			pruneSkipped = params.Prune
+			break
+		}
+	}
+
+	if params.Prune && !pruneSkipped {
+		for _, gvk := range defaultPruneKinds {
+			if _, ok := pruneKinds[gvk.GroupKind()]; !ok {
+				pruneKinds[gvk.GroupKind()] = gvk
+			}
+		}
+		results = append(results, d.prune(params.ApplySet, pruneKinds, namespaces.List(), appliedKeys)...)
+	}
+
+	var failed, pruned int
+	for _, r := range results {
+		if !r.Success || (params.Wait && r.Action == ApplyActionApply && !r.Ready) {
+			failed += 1
+		} else if r.Action == ApplyActionPrune {
+			pruned += 1
+		}
+	}
+	msg := fmt.Sprintf("%d applied, %d pruned, %d failed", len(applied), pruned, failed)
+	if pruneSkipped {
+		msg += ", prune skipped"
+	}
+	if failed > 0 {
+		return &utils.Response{Code: code.ApplyError, Msg: msg, Data: results}
+	}
+	return &utils.Response{Code: code.Success, Msg: msg, Data: results}
+}
+
+func applyKey(gvk schema.GroupVersionKind, namespace, name string) string {
+	return fmt.Sprintf("%s/%s/%s/%s", gvk.Group, gvk.Kind, namespace, name)
+}
+
+func (d *DynamicResource) applyObject(obj *unstructured.Unstructured, params *ApplyParams) *appliedObject {
+	res := &ApplyResult{
+		Kind:      obj.GetKind(),
+		Namespace: obj.GetNamespace(),
+		Name:      obj.GetName(),
+		Action:    ApplyActionApply,
+	}
+	ao := &appliedObject{obj: obj, result: res}
+	if params.Prune {
+		objLabels := obj.GetLabels()
+		if objLabels == nil {
+			objLabels = make(map[string]string)
+		}
+		objLabels[ApplySetLabel] = params.ApplySet
+		obj.SetLabels(objLabels)
+	}
+	dr, _, err := d.buildDynamicResourceClient(obj)
+	if err != nil {
+		res.Msg = err.Error()
+		return ao
+	}
+	ao.dr = dr
+	data, err := obj.MarshalJSON()
+	if err != nil {
+		res.Msg = err.Error()
+		return ao
+	}
+	force := params.Force
+	// Create or Update
+	_, err = dr.Patch(obj.GetName(), types.ApplyPatchType, data, metav1.PatchOptions{
+		FieldManager: params.FieldManager,
+		Force:        &force,
+	})
+	if err != nil {
+		klog.Errorf("apply %s/%s error: %v", obj.GetKind(), obj.GetName(), err)
+		res.Msg = err.Error()
+		return ao
+	}
+	res.Success = true
+	res.Msg = "applied successful"
+	return ao
+}
+
+func (d *DynamicResource) waitEstablished(crds []*appliedObject, deadline time.Time) {
+	for _, crd := range crds {
+		err := wait.PollImmediate(time.Second, time.Until(deadline), func() (bool, error) {
+			obj, err := crd.dr.Get(crd.obj.GetName(), metav1.GetOptions{})
+			if err != nil {
+				return false, nil
+			}
+			return conditionStatus(obj, "Established") == "True", nil
+		})
+		if err != nil {
+			klog.Errorf("wait crd %s established error: %v", crd.obj.GetName(), err)
+		}
+	}
+}
+
+func (d *DynamicResource) waitReady(ao *appliedObject, deadline time.Time) {
+	var readyErr error
+	err := wait.PollImmediate(2*time.Second, time.Until(deadline), func() (bool, error) {
+		obj, err := ao.dr.Get(ao.obj.GetName(), metav1.GetOptions{})
+		if err != nil {
+			readyErr = err
+			return false, nil
+		}
+		ready, err := isObjectReady(obj)
+		if err != nil {
+			return false, err
+		}
+		return ready, nil
+	})
+	if err == nil {
+		ao.result.Ready = true
+		return
+	}
+	if err == wait.ErrWaitTimeout && readyErr != nil {
+		err = readyErr
+	}
+	ao.result.Msg = "wait ready error: " + err.Error()
+}
+
+func conditionStatus(obj *unstructured.Unstructured, condType string) string {
+	conditions, _, _ := unstructured.NestedSlice(obj.Object, "status", "conditions")
+	for _, c := range conditions {
+		cond, ok := c.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if cond["type"] == condType {
+			status, _ := cond["status"].(string)
+			return status
+		}
+	}
+	return ""
+}
+
+// isObjectReady reports whether an applied object has finished rolling out,
+// kinds without a known notion of readiness are ready once they exist.
+func isObjectReady(obj *unstructured.Unstructured) (bool, error) {
+	generation := obj.GetGeneration()
+	observed, _, _ := unstructured.NestedInt64(obj.Object, "status", "observedGeneration")
+	status := func(field string) int64 {
+		v, _, _ := unstructured.NestedInt64(obj.Object, "status", field)
+		return v
+	}
+	replicas, found, _ := unstructured.NestedInt64(obj.Object, "spec", "replicas")
+	if !found {
+		replicas = 1
+	}
+	switch obj.GetKind() {
+	case "Deployment":
+		return observed >= generation && status("updatedReplicas") == replicas && status("availableReplicas") >= replicas, nil
+	case "StatefulSet":
+		return observed >= generation && status("updatedReplicas") == replicas && status("readyReplicas") == replicas, nil
+	case "DaemonSet":
+		desired := status("desiredNumberScheduled")
+		return observed >= generation && status("updatedNumberScheduled") == desired && status("numberAvailable") == desired, nil
+	case "Job":
+		if conditionStatus(obj, "Failed") == "True" {
+			return false, fmt.Errorf("job %s failed", obj.GetName())
+		}
+		return conditionStatus(obj, "Complete") == "True", nil
+	case "CustomResourceDefinition":
+		return conditionStatus(obj, "Established") == "True", nil
+	case "PersistentVolumeClaim":
+		phase, _, _ := unstructured.NestedString(obj.Object, "status", "phase")
+		return phase == "Bound", nil
+	case "Namespace":
+		phase, _, _ := unstructured.NestedString(obj.Object, "status", "phase")
+		return phase == "Active", nil
+	}
+	if ready := conditionStatus(obj, "Ready"); ready != "" {
+		return ready == "True", nil
+	}
+	return true, nil
+}
+
+func (d *DynamicResource) prune(applySet string, gvks map[schema.GroupKind]schema.GroupVersionKind, namespaces []string, appliedKeys sets.String) []*ApplyResult {
+	var kinds []schema.GroupVersionKind
+	for _, gvk := range gvks {
+		kinds = append(kinds, gvk)
+	}
+	// delete in the reverse order of install
+	sort.SliceStable(kinds, func(i, j int) bool {
+		return installOrderIndex(kinds[i].Kind) > installOrderIndex(kinds[j].Kind)
+	})
+	listOptions := metav1.ListOptions{LabelSelector: ApplySetLabel + "=" + applySet}
+	var results []*ApplyResult
+	for _, gvk := range kinds {
+		mapping, err := d.restMapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+		if err != nil {
+			klog.V(1).Infof("prune mapping %v error: %v", gvk, err)
+			continue
+		}
+		var clients []dynamic.ResourceInterface
+		if mapping.Scope.Name() == meta.RESTScopeNameNamespace {
+			for _, ns := range namespaces {
+				clients = append(clients, d.DynamicClient.Resource(mapping.Resource).Namespace(ns))
+			}
+		} else {
+			clients = append(clients, d.DynamicClient.Resource(mapping.Resource))
+		}
+		for _, dr := range clients {
+			list, err := dr.List(listOptions)
+			if err != nil {
+				klog.Errorf("prune list %v error: %v", gvk, err)
+				continue
+			}
+			for _, item := range list.Items {
+				if appliedKeys.Has(applyKey(gvk, item.GetNamespace(), item.GetName())) {
+					continue
+				}
+				res := &ApplyResult{
+					Kind:      gvk.Kind,
+					Namespace: item.GetNamespace(),
+					Name:      item.GetName(),
+					Action:    ApplyActionPrune,
+				}
+				deletePolicy := metav1.DeletePropagationBackground
+				err := dr.Delete(item.GetName(), &metav1.DeleteOptions{PropagationPolicy: &deletePolicy})
+				if err != nil && !apierrors.IsNotFound(err) {
+					res.Msg = err.Error()
+				} else {
+					res.Success = true
+					res.Msg = "pruned successful"
+				}
+				results = append(results, res)
+			}
+		}
+	}
+	return results
+}
@@ -0,0 +1,208 @@
+package resource
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"github.com/openspacee/ospagent/pkg/kubernetes"
+	"github.com/openspacee/ospagent/pkg/utils"
+	"github.com/openspacee/ospagent/pkg/utils/code"
+	"k8s.io/api/core/v1"
+	extv1beta1 "k8s.io/api/extensions/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"sort"
+	"time"
+)
+
+const (
+	CertificateValid    = "valid"
+	CertificateExpiring = "expiring"
+	CertificateExpired  = "expired"
+	CertificateInvalid  = "invalid"
+	CertificateMissing  = "missing"
+	CertificateMismatch = "mismatch"
+	// CertificateDefault is a tls host without secret, served with the default certificate of the ingress controller.
+	CertificateDefault = "default"
+
+	defaultExpiringDays = 30
+)
+
+type BuildCertificate struct {
+	Secret      string      `json:"secret"`
+	Namespace   string      `json:"namespace"`
+	Subject     string      `json:"subject"`
+	Issuer      string      `json:"issuer"`
+	DNSNames    []string    `json:"dns_names"`
+	IPAddresses []string    `json:"ip_addresses"`
+	NotBefore   metav1.Time `json:"not_before"`
+	NotAfter    metav1.Time `json:"not_after"`
+	DaysLeft    int         `json:"days_left"`
+	Status      string      `json:"status"`
+	Message     string      `json:"message"`
+	// certificate is nil when the secret has no valid certificate.
+	certificate *x509.Certificate
+}
+
+type BuildIngressCertificate struct {
+	Ingress   string `json:"ingress"`
+	Namespace string `json:"namespace"`
+	Host      string `json:"host"`
+	Secret    string `json:"secret"`
+	Status    string `json:"status"`
+	Message   string `json:"message"`
+}
+
+type BuildCertificates struct {
+	Certificates []*BuildCertificate        `json:"certificates"`
+	Hosts        []*BuildIngressCertificate `json:"hosts"`
+}
+
+// CertificatesInput is the tls secrets and the ingresses to inspect at Now.
+type CertificatesInput struct {
+	Secrets      []*v1.Secret
+	Ingresses    []*extv1beta1.Ingress
+	Now          time.Time
+	ExpiringDays int
+}
+
+// inspectCertificate parses the leaf certificate of the tls secret.
+func inspectCertificate(secret *v1.Secret, now time.Time, expiringDays int) *BuildCertificate {
+	data := &BuildCertificate{Secret: secret.Name, Namespace: secret.Namespace, Status: CertificateInvalid}
+	block, rest := pem.Decode(secret.Data[v1.TLSCertKey])
+	for block != nil && block.Type != "CERTIFICATE" {
+		block, rest = pem.Decode(rest)
+	}
+	if block == nil {
+		data.Message = fmt.Sprintf("No certificate found in %s", v1.TLSCertKey)
+		return data
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		data.Message = err.Error()
+		return data
+	}
+	data.certificate = cert
+	data.Subject = cert.Subject.String()
+	data.Issuer = cert.Issuer.String()
+	data.DNSNames = cert.DNSNames
+	for _, ip := range cert.IPAddresses {
+		data.IPAddresses = append(data.IPAddresses, ip.String())
+	}
+	data.NotBefore = metav1.NewTime(cert.NotBefore)
+	data.NotAfter = metav1.NewTime(cert.NotAfter)
+	data.DaysLeft = int(cert.NotAfter.Sub(now).Hours() / 24)
+	switch {
+	case now.After(cert.NotAfter):
+		data.Status = CertificateExpired
+		data.Message = fmt.Sprintf("Expired at %s", cert.NotAfter.Format(time.RFC3339))
+	case now.Before(cert.NotBefore):
+		data.Status = CertificateInvalid
+		data.Message = fmt.Sprintf("Not valid before %s", cert.NotBefore.Format(time.RFC3339))
+	case cert.NotAfter.Sub(now) < time.Duration(expiringDays)*24*time.Hour:
+		data.Status = CertificateExpiring
+		data.Message = fmt.Sprintf("Expires in %d days", data.DaysLeft)
+	default:
+		data.Status = CertificateValid
+	}
+	return data
+}
+
+// InspectCertificates parses the tls secrets and checks the certificates of the ingress tls hosts,
+// the certificates expiring first are listed first.
+func InspectCertificates(input *CertificatesInput) *BuildCertificates {
+	expiringDays := input.ExpiringDays
+	if expiringDays <= 0 {
+		expiringDays = defaultExpiringDays
+	}
+	res := &BuildCertificates{Certificates: []*BuildCertificate{}, Hosts: []*BuildIngressCertificate{}}
+	certificates := make(map[string]*BuildCertificate)
+	secrets := make(map[string]*v1.Secret)
+	for _, secret := range input.Secrets {
+		secrets[secret.Namespace+"/"+secret.Name] = secret
+		if secret.Type != v1.SecretTypeTLS {
+			continue
+		}
+		cert := inspectCertificate(secret, input.Now, expiringDays)
+		certificates[secret.Namespace+"/"+secret.Name] = cert
+		res.Certificates = append(res.Certificates, cert)
+	}
+	sort.SliceStable(res.Certificates, func(i, j int) bool {
+		ci, cj := res.Certificates[i], res.Certificates[j]
+		if (ci.certificate == nil) != (cj.certificate == nil) {
+			return ci.certificate == nil
+		}
+		return ci.NotAfter.Before(&cj.NotAfter)
+	})
+
+	for _, ingress := range input.Ingresses {
+		for _, t := range ingress.Spec.TLS {
+			for _, host := range t.Hosts {
+				h := &BuildIngressCertificate{Ingress: ingress.Name, Namespace: ingress.Namespace, Host: host, Secret: t.SecretName}
+				res.Hosts = append(res.Hosts, h)
+				if t.SecretName == "" {
+					h.Status = CertificateDefault
+					continue
+				}
+				key := ingress.Namespace + "/" + t.SecretName
+				cert, ok := certificates[key]
+				if !ok {
+					secret, found := secrets[key]
+					if !found {
+						h.Status = CertificateMissing
+						h.Message = fmt.Sprintf("TLS secret %s not found", t.SecretName)
+						continue
+					}
+					// ingress controllers accept the certificates of secrets not typed tls too
+					cert = inspectCertificate(secret, input.Now, expiringDays)
+					certificates[key] = cert
+				}
+				h.Status = cert.Status
+				h.Message = cert.Message
+				if cert.certificate == nil || cert.Status == CertificateExpired {
+					continue
+				}
+				if err := cert.certificate.VerifyHostname(host); err != nil {
+					h.Status = CertificateMismatch
+					h.Message = err.Error()
+				}
+			}
+		}
+	}
+	return res
+}
+
+type Certificates struct {
+	*kubernetes.KubeClient
+}
+
+func NewCertificates(kubeClient *kubernetes.KubeClient) *Certificates {
+	return &Certificates{KubeClient: kubeClient}
+}
+
+type CertificatesParams struct {
+	Namespace    string `json:"namespace"`
+	ExpiringDays int    `json:"expiring_days"`
+}
+
+// List inspects the tls secrets and ingresses of the namespace, all namespaces when blank.
+func (c *Certificates) List(requestParams interface{}) *utils.Response {
+	params := &CertificatesParams{}
+	json.Unmarshal(requestParams.([]byte), params)
+	secrets, err := c.SecretInformer().Lister().Secrets(params.Namespace).List(labels.Everything())
+	if err != nil {
+		return &utils.Response{Code: code.ListError, Msg: err.Error()}
+	}
+	ingresses, err := c.IngressInformer().Lister().Ingresses(params.Namespace).List(labels.Everything())
+	if err != nil {
+		return &utils.Response{Code: code.ListError, Msg: err.Error()}
+	}
+	res := InspectCertificates(&CertificatesInput{
+		Secrets:      secrets,
+		Ingresses:    ingresses,
+		Now:          time.Now(),
+		ExpiringDays: params.ExpiringDays,
+	})
+	return &utils.Response{Code: code.Success, Msg: "Success", Data: res}
+}
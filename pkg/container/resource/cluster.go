@@ -50,6 +50,10 @@ type BuildCluster struct {
 	PVBoundNum      int    `json:"pv_bound_num"`
 	PVFailedNum     int    `json:"pv_failed_num"`
 	PVCNum          int    `json:"pvc_num"`
+
+	Allocation *BuildAllocation `json:"allocation"`
+	// NamespaceAllocations are of the cluster allocatable.
+	NamespaceAllocations map[string]*BuildAllocation `json:"namespace_allocations"`
 }
 
 type ClusterQueryParams struct {
@@ -79,9 +83,11 @@ func (c *Cluster) Get(requestParams interface{}) *utils.Response {
 	bc.NodeNum = len(nodes)
 	var cpu resource.Quantity
 	var memory resource.Quantity
+	allocatable := corev1.ResourceList{}
 	for _, n := range nodes {
 		cpu.Add(*n.Status.Capacity.Cpu())
 		memory.Add(*n.Status.Capacity.Memory())
+		addResourceList(allocatable, n.Status.Allocatable)
 	}
 	bc.ClusterCpu = cpu.String()
 	bc.ClusterMemory = memory.String()
@@ -106,6 +112,17 @@ func (c *Cluster) Get(requestParams interface{}) *utils.Response {
 			bc.PodSucceededNum += 1
 		}
 	}
+	// pending pods not bound to a node take no allocatable of the nodes
+	scheduled := scheduledPods(pods)
+	bc.Allocation = computeAllocation(scheduled, allocatable)
+	namespacePods := make(map[string][]*corev1.Pod)
+	for _, p := range scheduled {
+		namespacePods[p.Namespace] = append(namespacePods[p.Namespace], p)
+	}
+	bc.NamespaceAllocations = make(map[string]*BuildAllocation)
+	for namespace, nsPods := range namespacePods {
+		bc.NamespaceAllocations[namespace] = computeAllocation(nsPods, allocatable)
+	}
 	deployments, err := c.KubeClient.DeploymentInformer().Lister().List(labels.Everything())
 	if err != nil {
 		return &utils.Response{Code: code.ListError, Msg: err.Error()}
@@ -8,17 +8,22 @@ import (
 	"github.com/openspacee/ospagent/pkg/utils/code"
 	"github.com/openspacee/ospagent/pkg/websocket"
 	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/runtime/serializer"
+	"k8s.io/client-go/tools/cache"
 	"k8s.io/client-go/util/retry"
 	"k8s.io/klog"
+	"sort"
+	"strings"
 )
 
 type ConfigMap struct {
 	*kubernetes.KubeClient
 	websocket.SendResponse
+	watch *WatchResource
 	*DynamicResource
 }
 
@@ -26,6 +31,7 @@ type BuildConfigMap struct {
 	Name       string            `json:"name"`
 	NameSpace  string            `json:"namespace"`
 	Keys       []string          `json:"keys"`
+	BinaryKeys []string          `json:"binary_keys"`
 	Labels     map[string]string `json:"labels"`
 	CreateTime string            `json:"create_time"`
 	Data       map[string]string `json:"data"`
@@ -38,15 +44,20 @@ type ConfigMapQueryParams struct {
 }
 
 type ConfigMapUpdateParams struct {
-	Name      string            `json:"name"`
-	Namespace string            `json:"namespace"`
-	Data      map[string]string `json:"data"`
+	Name       string            `json:"name"`
+	Namespace  string            `json:"namespace"`
+	Labels     map[string]string `json:"labels"`
+	Data       map[string]string `json:"data"`
+	BinaryData map[string][]byte `json:"binary_data"`
 }
 
-type ConfigMapCreateParams struct {
-	Name      string            `json:"name"`
-	Namespace string            `json:"namespace"`
-	Data      map[string]string `json:"data"`
+// ConfigMapKeysParams sets the keys of data and binary_data, or removes the keys.
+type ConfigMapKeysParams struct {
+	Name       string            `json:"name"`
+	Namespace  string            `json:"namespace"`
+	Data       map[string]string `json:"data"`
+	BinaryData map[string][]byte `json:"binary_data"`
+	Keys       []string          `json:"keys"`
 }
 
 func (c *ConfigMap) ToBuildConfigMap(cm *v1.ConfigMap) *BuildConfigMap {
@@ -63,27 +74,48 @@ func (c *ConfigMap) ToBuildConfigMap(cm *v1.ConfigMap) *BuildConfigMap {
 	}
 
 	keys := make([]string, 0, len(cm.Data))
-	for k, _ := range cm.Data {
+	for k := range cm.Data {
 		keys = append(keys, k)
 	}
+	sort.Strings(keys)
 	cmData.Keys = keys
+	binaryKeys := make([]string, 0, len(cm.BinaryData))
+	for k := range cm.BinaryData {
+		binaryKeys = append(binaryKeys, k)
+	}
+	sort.Strings(binaryKeys)
+	cmData.BinaryKeys = binaryKeys
 	return cmData
 }
 
-func NewConfigMap(kubeClient *kubernetes.KubeClient, sendResponse websocket.SendResponse) *ConfigMap {
-	return &ConfigMap{
+func NewConfigMap(kubeClient *kubernetes.KubeClient, sendResponse websocket.SendResponse, watch *WatchResource) *ConfigMap {
+	c := &ConfigMap{
 		KubeClient:   kubeClient,
 		SendResponse: sendResponse,
+		watch:        watch,
 		DynamicResource: NewDynamicResource(kubeClient, &schema.GroupVersionResource{
 			Group:    "",
 			Version:  "v1",
 			Resource: "configmaps",
 		}),
 	}
+	c.DoWatch()
+	return c
+}
+
+func (c *ConfigMap) DoWatch() {
+	informer := c.KubeClient.ConfigMapInformer().Informer()
+	informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    c.watch.WatchAdd(utils.WatchConfigMap),
+		UpdateFunc: c.watch.WatchUpdate(utils.WatchConfigMap),
+		DeleteFunc: c.watch.WatchDelete(utils.WatchConfigMap),
+	})
 }
 
 func (c *ConfigMap) List(requestParams interface{}) *utils.Response {
-	configMapList, err := c.KubeClient.InformerRegistry.ConfigMapInformer().Lister().List(labels.Everything())
+	queryParams := &ConfigMapQueryParams{}
+	json.Unmarshal(requestParams.([]byte), queryParams)
+	configMapList, err := c.KubeClient.ConfigMapInformer().Lister().ConfigMaps(queryParams.Namespace).List(labels.Everything())
 	if err != nil {
 		return &utils.Response{
 			Code: code.ListError,
@@ -92,6 +124,9 @@ func (c *ConfigMap) List(requestParams interface{}) *utils.Response {
 	}
 	var configMapResource []*BuildConfigMap
 	for _, cm := range configMapList {
+		if queryParams.Name != "" && !strings.Contains(cm.Name, queryParams.Name) {
+			continue
+		}
 		configMapResource = append(configMapResource, c.ToBuildConfigMap(cm))
 	}
 	return &utils.Response{Code: code.Success, Msg: "Success", Data: configMapResource}
@@ -126,13 +161,21 @@ func (c *ConfigMap) Get(requestParams interface{}) *utils.Response {
 			klog.Error(e)
 			return &utils.Response{Code: code.EncodeError, Msg: e.Error()}
 		}
-		klog.Info(d)
 		return &utils.Response{Code: code.Success, Msg: "Success", Data: string(d)}
 	}
-	fmt.Println(configMap)
 	return &utils.Response{Code: code.Success, Msg: "Success", Data: configMap}
 }
 
+func checkConfigMapKeys(data map[string]string, binaryData map[string][]byte) error {
+	for k := range binaryData {
+		if _, ok := data[k]; ok {
+			return fmt.Errorf("key %s is duplicated in data and binary_data", k)
+		}
+	}
+	return nil
+}
+
+// UpdateObj replaces the data and binary data of the config map, and the labels when given.
 func (c *ConfigMap) UpdateObj(updateParams interface{}) *utils.Response {
 	params := &ConfigMapUpdateParams{}
 	json.Unmarshal(updateParams.([]byte), params)
@@ -143,43 +186,172 @@ func (c *ConfigMap) UpdateObj(updateParams interface{}) *utils.Response {
 	if params.Namespace == "" {
 		return &utils.Response{Code: code.ParamsError, Msg: "Namespace is blank"}
 	}
-	if len(params.Data) == 0 {
-		return &utils.Response{Code: code.ParamsError, Msg: "Data is blank"}
+	replaceData := len(params.Data) > 0 || len(params.BinaryData) > 0
+	if !replaceData && params.Labels == nil {
+		return &utils.Response{Code: code.ParamsError, Msg: "Data and labels are blank"}
+	}
+	if err := checkConfigMapKeys(params.Data, params.BinaryData); err != nil {
+		return &utils.Response{Code: code.ParamsError, Msg: err.Error()}
 	}
 	retryErr := retry.RetryOnConflict(retry.DefaultRetry, func() error {
-		// Retrieve the latest version of Deployment before attempting update
-		// RetryOnConflict uses exponential backoff to avoid exhausting the apiserver
-		result, getErr := c.KubeClient.InformerRegistry.ConfigMapInformer().Lister().ConfigMaps(params.Namespace).Get(params.Name)
+		result, getErr := c.ClientSet.CoreV1().ConfigMaps(params.Namespace).Get(params.Name, metav1.GetOptions{})
 		if getErr != nil {
-			panic(fmt.Errorf("failed to get latest version of Deployment: %v", getErr))
+			return getErr
 		}
 
-		result.Data = params.Data
+		if replaceData {
+			result.Data = params.Data
+			result.BinaryData = params.BinaryData
+		}
+		if params.Labels != nil {
+			result.Labels = params.Labels
+		}
 		_, updateErr := c.ClientSet.CoreV1().ConfigMaps(params.Namespace).Update(result)
 		return updateErr
 	})
 	if retryErr != nil {
 		klog.Errorf("Update failed: %v", retryErr)
-		return &utils.Response{Code: code.ParamsError, Msg: retryErr.Error()}
+		return &utils.Response{Code: code.UpdateError, Msg: retryErr.Error()}
 	}
 	return &utils.Response{Code: code.Success, Msg: "Success"}
 }
 
 func (c *ConfigMap) Create(createParams interface{}) *utils.Response {
-
 	params := &ConfigMapUpdateParams{}
 	json.Unmarshal(createParams.([]byte), params)
 
+	if params.Name == "" {
+		return &utils.Response{Code: code.ParamsError, Msg: "ConfigMap name is blank"}
+	}
+	if params.Namespace == "" {
+		return &utils.Response{Code: code.ParamsError, Msg: "Namespace is blank"}
+	}
+	if err := checkConfigMapKeys(params.Data, params.BinaryData); err != nil {
+		return &utils.Response{Code: code.ParamsError, Msg: err.Error()}
+	}
+
 	configMap := v1.ConfigMap{}
 	configMap.APIVersion = "v1"
 	configMap.Kind = "ConfigMap"
 	configMap.Name = params.Name
-	configMap.Data = params.Data
 	configMap.Namespace = params.Namespace
+	configMap.Labels = params.Labels
+	configMap.Data = params.Data
+	configMap.BinaryData = params.BinaryData
 
 	cm, err := c.ClientSet.CoreV1().ConfigMaps(params.Namespace).Create(&configMap)
 	if err != nil {
 		klog.Errorf("Create ConfigMap failed: %v", err)
+		return &utils.Response{Code: code.CreateError, Msg: err.Error()}
+	}
+	return &utils.Response{Code: code.Success, Msg: "Success", Data: c.ToBuildConfigMap(cm)}
+}
+
+// updateKeys updates the keys of the latest version of the config map, so the keys are moved or removed based
+// on the data they are written over.
+func (c *ConfigMap) updateKeys(namespace, name string, update func(cm *v1.ConfigMap) error) error {
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		result, getErr := c.ClientSet.CoreV1().ConfigMaps(namespace).Get(name, metav1.GetOptions{})
+		if getErr != nil {
+			return getErr
+		}
+		if err := update(result); err != nil {
+			return err
+		}
+		_, updateErr := c.ClientSet.CoreV1().ConfigMaps(namespace).Update(result)
+		return updateErr
+	})
+}
+
+// SetKeys adds or overwrites the keys, a key moved between data and binary_data is removed from the other.
+func (c *ConfigMap) SetKeys(requestParams interface{}) *utils.Response {
+	params := &ConfigMapKeysParams{}
+	json.Unmarshal(requestParams.([]byte), params)
+	if params.Name == "" {
+		return &utils.Response{Code: code.ParamsError, Msg: "ConfigMap name is blank"}
+	}
+	if params.Namespace == "" {
+		return &utils.Response{Code: code.ParamsError, Msg: "Namespace is blank"}
+	}
+	if len(params.Data) == 0 && len(params.BinaryData) == 0 {
+		return &utils.Response{Code: code.ParamsError, Msg: "Data is blank"}
+	}
+	if err := checkConfigMapKeys(params.Data, params.BinaryData); err != nil {
+		return &utils.Response{Code: code.ParamsError, Msg: err.Error()}
+	}
+	err := c.updateKeys(params.Namespace, params.Name, func(cm *v1.ConfigMap) error {
+		if len(params.Data) > 0 && cm.Data == nil {
+			cm.Data = map[string]string{}
+		}
+		if len(params.BinaryData) > 0 && cm.BinaryData == nil {
+			cm.BinaryData = map[string][]byte{}
+		}
+		for k, v := range params.Data {
+			cm.Data[k] = v
+			delete(cm.BinaryData, k)
+		}
+		for k, v := range params.BinaryData {
+			cm.BinaryData[k] = v
+			delete(cm.Data, k)
+		}
+		return nil
+	})
+	if err != nil {
+		klog.Errorf("Set ConfigMap keys failed: %v", err)
+		return &utils.Response{Code: code.UpdateError, Msg: err.Error()}
+	}
+	return &utils.Response{Code: code.Success, Msg: "Success"}
+}
+
+func (c *ConfigMap) RemoveKeys(requestParams interface{}) *utils.Response {
+	params := &ConfigMapKeysParams{}
+	json.Unmarshal(requestParams.([]byte), params)
+	if params.Name == "" {
+		return &utils.Response{Code: code.ParamsError, Msg: "ConfigMap name is blank"}
+	}
+	if params.Namespace == "" {
+		return &utils.Response{Code: code.ParamsError, Msg: "Namespace is blank"}
+	}
+	if len(params.Keys) == 0 {
+		return &utils.Response{Code: code.ParamsError, Msg: "Keys are blank"}
+	}
+	missing := ""
+	err := c.updateKeys(params.Namespace, params.Name, func(cm *v1.ConfigMap) error {
+		for _, k := range params.Keys {
+			_, inData := cm.Data[k]
+			_, inBinaryData := cm.BinaryData[k]
+			if !inData && !inBinaryData {
+				missing = k
+				return fmt.Errorf("key %s not found", k)
+			}
+			delete(cm.Data, k)
+			delete(cm.BinaryData, k)
+		}
+		return nil
+	})
+	if missing != "" {
+		return &utils.Response{Code: code.ParamsError, Msg: err.Error()}
+	}
+	if err != nil {
+		klog.Errorf("Remove ConfigMap keys failed: %v", err)
+		return &utils.Response{Code: code.UpdateError, Msg: err.Error()}
+	}
+	return &utils.Response{Code: code.Success, Msg: "Success"}
+}
+
+// References lists the pods mounting or referencing the config map.
+func (c *ConfigMap) References(requestParams interface{}) *utils.Response {
+	queryParams := &ConfigMapQueryParams{}
+	json.Unmarshal(requestParams.([]byte), queryParams)
+	if queryParams.Name == "" {
+		return &utils.Response{Code: code.ParamsError, Msg: "Name is blank"}
+	}
+	if queryParams.Namespace == "" {
+		return &utils.Response{Code: code.ParamsError, Msg: "Namespace is blank"}
+	}
+	refs, err := namespaceReferences(c.KubeClient, queryParams.Namespace, "ConfigMap", queryParams.Name)
+	if err != nil {
+		return &utils.Response{Code: code.ListError, Msg: err.Error()}
 	}
-	return &utils.Response{Code: code.Success, Msg: "Success", Data: cm}
+	return &utils.Response{Code: code.Success, Msg: "Success", Data: refs}
 }
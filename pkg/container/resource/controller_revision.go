@@ -0,0 +1,120 @@
+package resource
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/openspacee/ospagent/pkg/kubernetes"
+	"github.com/openspacee/ospagent/pkg/utils"
+	"github.com/openspacee/ospagent/pkg/utils/code"
+	"k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/runtime/serializer"
+	"k8s.io/klog"
+	"strings"
+)
+
+// ControllerRevision is read only, the revisions are managed by their statefulsets and daemonsets.
+type ControllerRevision struct {
+	*kubernetes.KubeClient
+}
+
+func NewControllerRevision(kubeClient *kubernetes.KubeClient) *ControllerRevision {
+	return &ControllerRevision{KubeClient: kubeClient}
+}
+
+type BuildControllerRevision struct {
+	UID       string      `json:"uid"`
+	Name      string      `json:"name"`
+	Namespace string      `json:"namespace"`
+	Revision  int64       `json:"revision"`
+	OwnerKind string      `json:"owner_kind"`
+	OwnerName string      `json:"owner_name"`
+	Created   metav1.Time `json:"created"`
+}
+
+func (c *ControllerRevision) ToBuildControllerRevision(cr *v1.ControllerRevision) *BuildControllerRevision {
+	if cr == nil {
+		return nil
+	}
+	data := &BuildControllerRevision{
+		UID:       string(cr.UID),
+		Name:      cr.Name,
+		Namespace: cr.Namespace,
+		Revision:  cr.Revision,
+		Created:   cr.CreationTimestamp,
+	}
+	if owner := metav1.GetControllerOf(cr); owner != nil {
+		data.OwnerKind = owner.Kind
+		data.OwnerName = owner.Name
+	}
+	return data
+}
+
+type ControllerRevisionQueryParams struct {
+	Name      string `json:"name"`
+	Namespace string `json:"namespace"`
+	Output    string `json:"output"`
+	OwnerKind string `json:"owner_kind"`
+	OwnerName string `json:"owner_name"`
+}
+
+func (c *ControllerRevision) List(requestParams interface{}) *utils.Response {
+	queryParams := &ControllerRevisionQueryParams{}
+	json.Unmarshal(requestParams.([]byte), queryParams)
+	list, err := c.ControllerRevisionInformer().Lister().ControllerRevisions(queryParams.Namespace).List(labels.Everything())
+	if err != nil {
+		return &utils.Response{Code: code.ListError, Msg: err.Error()}
+	}
+	var crs []*BuildControllerRevision
+	for _, cr := range list {
+		if queryParams.Name != "" && !strings.Contains(cr.Name, queryParams.Name) {
+			continue
+		}
+		bcr := c.ToBuildControllerRevision(cr)
+		if queryParams.OwnerKind != "" && !strings.EqualFold(bcr.OwnerKind, queryParams.OwnerKind) {
+			continue
+		}
+		if queryParams.OwnerName != "" && bcr.OwnerName != queryParams.OwnerName {
+			continue
+		}
+		crs = append(crs, bcr)
+	}
+	return &utils.Response{Code: code.Success, Msg: "Success", Data: crs}
+}
+
+func (c *ControllerRevision) Get(requestParams interface{}) *utils.Response {
+	queryParams := &ControllerRevisionQueryParams{}
+	json.Unmarshal(requestParams.([]byte), queryParams)
+	if queryParams.Name == "" {
+		return &utils.Response{Code: code.ParamsError, Msg: "ControllerRevision name is blank"}
+	}
+	if queryParams.Namespace == "" {
+		return &utils.Response{Code: code.ParamsError, Msg: "Namespace is blank"}
+	}
+	cr, err := c.ControllerRevisionInformer().Lister().ControllerRevisions(queryParams.Namespace).Get(queryParams.Name)
+	if err != nil {
+		return &utils.Response{Code: code.GetError, Msg: err.Error()}
+	}
+	if queryParams.Output == "yaml" {
+		const mediaType = runtime.ContentTypeYAML
+		rscheme := runtime.NewScheme()
+		v1.AddToScheme(rscheme)
+		codecs := serializer.NewCodecFactory(rscheme)
+		info, ok := runtime.SerializerInfoForMediaType(codecs.SupportedMediaTypes(), mediaType)
+		if !ok {
+			return &utils.Response{Code: code.Success, Msg: fmt.Sprintf("unsupported media type %q", mediaType)}
+		}
+
+		encoder := codecs.EncoderForVersion(info.Serializer, schema.GroupVersion{Group: "apps", Version: "v1"})
+		d, e := runtime.Encode(encoder, cr)
+		if e != nil {
+			klog.Error(e)
+			return &utils.Response{Code: code.EncodeError, Msg: e.Error()}
+		}
+		return &utils.Response{Code: code.Success, Msg: "Success", Data: string(d)}
+	}
+	return &utils.Response{Code: code.Success, Msg: "Success", Data: cr}
+}
@@ -0,0 +1,220 @@
+package resource
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/openspacee/ospagent/pkg/utils"
+	"github.com/openspacee/ospagent/pkg/utils/code"
+	"github.com/openspacee/ospagent/pkg/websocket"
+	"k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/klog"
+	"path"
+	"sync"
+	"time"
+)
+
+const (
+	defaultCopyMaxBytes = int64(100 * 1024 * 1024)
+	maxCopyBytes        = int64(1024 * 1024 * 1024)
+	copyProgressPeriod  = time.Second
+)
+
+type CopyParams struct {
+	SessionId string `json:"session_id"`
+	Name      string `json:"name"`
+	Namespace string `json:"namespace"`
+	Container string `json:"container"`
+	Path      string `json:"path"`
+	// Size is the size of the archive sent by the server when copying to the container, it is only for progress.
+	Size     int64 `json:"size"`
+	MaxBytes int64 `json:"max_bytes"`
+}
+
+type CopyProgress struct {
+	Bytes int64  `json:"bytes"`
+	Total int64  `json:"total"`
+	Done  bool   `json:"done"`
+	Error string `json:"error,omitempty"`
+}
+
+// copyCounter counts the bytes of the archive, reports the progress and stops the copy over the size limit.
+type copyCounter struct {
+	sessionId    string
+	total        int64
+	maxBytes     int64
+	sessions     *SessionManager
+	sendResponse websocket.SendResponse
+
+	mutex        sync.Mutex
+	bytes        int64
+	lastProgress time.Time
+	err          error
+}
+
+func (c *copyCounter) add(n int) error {
+	c.mutex.Lock()
+	if c.err != nil {
+		c.mutex.Unlock()
+		return c.err
+	}
+	if c.bytes+int64(n) > c.maxBytes {
+		err := fmt.Errorf("archive exceeds the size limit of %d bytes", c.maxBytes)
+		c.err = err
+		progress := &CopyProgress{Bytes: c.bytes, Total: c.total, Error: err.Error()}
+		c.mutex.Unlock()
+		klog.Errorf("copy session %s error: %v", c.sessionId, err)
+		c.sendResponse(progress, c.sessionId, utils.CopyProgressType)
+		c.sessions.Close(c.sessionId)
+		return err
+	}
+	c.bytes += int64(n)
+	var progress *CopyProgress
+	if time.Since(c.lastProgress) >= copyProgressPeriod {
+		c.lastProgress = time.Now()
+		progress = &CopyProgress{Bytes: c.bytes, Total: c.total}
+	}
+	c.mutex.Unlock()
+	if progress != nil {
+		c.sendResponse(progress, c.sessionId, utils.CopyProgressType)
+	}
+	return nil
+}
+
+func (c *copyCounter) done() {
+	c.mutex.Lock()
+	progress := &CopyProgress{Bytes: c.bytes, Total: c.total, Done: true}
+	if c.err != nil {
+		progress.Error = c.err.Error()
+	}
+	c.mutex.Unlock()
+	c.sendResponse(progress, c.sessionId, utils.CopyProgressType)
+}
+
+// copyWriter sends the archive read from the container to the server.
+type copyWriter struct {
+	execWriter
+	counter *copyCounter
+}
+
+func (w *copyWriter) Write(p []byte) (int, error) {
+	if err := w.counter.add(len(p)); err != nil {
+		return 0, err
+	}
+	return w.execWriter.Write(p)
+}
+
+func (p *Pod) checkCopyParams(params *CopyParams) *utils.Response {
+	if params.SessionId == "" {
+		return &utils.Response{Code: code.ParamsError, Msg: "Session id is blank"}
+	}
+	if err := p.sessions.CheckId(params.SessionId); err != nil {
+		return &utils.Response{Code: code.ParamsError, Msg: err.Error()}
+	}
+	if params.Name == "" || params.Namespace == "" {
+		return &utils.Response{Code: code.ParamsError, Msg: "Pod name or namespace is blank"}
+	}
+	if params.Path == "" {
+		return &utils.Response{Code: code.ParamsError, Msg: "Path is blank"}
+	}
+	if params.MaxBytes <= 0 {
+		params.MaxBytes = defaultCopyMaxBytes
+	}
+	if params.MaxBytes > maxCopyBytes {
+		params.MaxBytes = maxCopyBytes
+	}
+	if params.Size > params.MaxBytes {
+		return &utils.Response{
+			Code: code.ParamsError,
+			Msg:  fmt.Sprintf("Size %d exceeds the size limit of %d bytes", params.Size, params.MaxBytes),
+		}
+	}
+	return nil
+}
+
+func (p *Pod) copyExecOptions(params *CopyParams, command []string, stdin bool) *execSessionOptions {
+	req := p.ClientSet.CoreV1().RESTClient().Post().
+		Resource("pods").
+		Name(params.Name).
+		Namespace(params.Namespace).
+		SubResource("exec").
+		VersionedParams(&v1.PodExecOptions{
+			Container: params.Container,
+			Command:   command,
+			Stdin:     stdin,
+			Stdout:    true,
+			Stderr:    true,
+		}, scheme.ParameterCodec)
+	return &execSessionOptions{
+		SessionId: params.SessionId,
+		Kind:      CopySession,
+		Namespace: params.Namespace,
+		Pod:       params.Name,
+		Url:       req.URL(),
+		Stdin:     stdin,
+		ResType:   utils.CopyType,
+	}
+}
+
+// CopyFrom streams a tar archive of the file or directory in the container to the server,
+// the archive is sent in copy frames of the session.
+func (p *Pod) CopyFrom(requestParams interface{}) *utils.Response {
+	params := &CopyParams{}
+	json.Unmarshal(requestParams.([]byte), params)
+	klog.Info(params)
+	if resp := p.checkCopyParams(params); resp != nil {
+		return resp
+	}
+	srcPath := path.Clean(params.Path)
+	command := []string{"tar", "cf", "-", "-C", path.Dir(srcPath), path.Base(srcPath)}
+	opts := p.copyExecOptions(params, command, false)
+	counter := &copyCounter{
+		sessionId:    params.SessionId,
+		maxBytes:     params.MaxBytes,
+		sessions:     p.sessions,
+		sendResponse: p.SendResponse,
+	}
+	opts.Stdout = &copyWriter{
+		execWriter: execWriter{
+			SessionId:  params.SessionId,
+			resType:    utils.CopyType,
+			stream:     utils.StdoutFrame,
+			sessions:   p.sessions,
+			SendStream: p.sendStream,
+		},
+		counter: counter,
+	}
+	go func() {
+		runExecSession(p.Config, p.sessions, p.SendResponse, p.sendStream, opts)
+		counter.done()
+	}()
+	return &utils.Response{Code: code.Success, Msg: "Success"}
+}
+
+// CopyTo extracts the tar archive sent by the server into the directory of the container, the archive
+// is sent in stdin frames or stdin actions, and the end of the archive is marked by closing stdin.
+func (p *Pod) CopyTo(requestParams interface{}) *utils.Response {
+	params := &CopyParams{}
+	json.Unmarshal(requestParams.([]byte), params)
+	klog.Info(params)
+	if resp := p.checkCopyParams(params); resp != nil {
+		return resp
+	}
+	command := []string{"tar", "xmf", "-", "-C", path.Clean(params.Path)}
+	opts := p.copyExecOptions(params, command, true)
+	counter := &copyCounter{
+		sessionId:    params.SessionId,
+		total:        params.Size,
+		maxBytes:     params.MaxBytes,
+		sessions:     p.sessions,
+		sendResponse: p.SendResponse,
+	}
+	opts.StdinHook = func(data []byte) error {
+		return counter.add(len(data))
+	}
+	go func() {
+		runExecSession(p.Config, p.sessions, p.SendResponse, p.sendStream, opts)
+		counter.done()
+	}()
+	return &utils.Response{Code: code.Success, Msg: "Success"}
+}
@@ -0,0 +1,290 @@
+package resource
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/openspacee/ospagent/pkg/utils"
+	"github.com/openspacee/ospagent/pkg/utils/code"
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	kube_client "k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/klog"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+const (
+	DebugPodLabel = "ospagent.openspacee.io/debug-of"
+
+	EphemeralDebug = "ephemeral"
+	CopyDebug      = "copy"
+
+	defaultDebugImage     = "busybox:1.31"
+	containerStartTimeout = 2 * time.Minute
+
+	// ephemeralContainersMinorVersion is the first Kubernetes 1.x patching spec.ephemeralContainers of the pod
+	// through the ephemeralcontainers subresource, older versions take an EphemeralContainers object.
+	ephemeralContainersMinorVersion = 22
+)
+
+type DebugParams struct {
+	SessionId string   `json:"session_id"`
+	Name      string   `json:"name"`
+	Namespace string   `json:"namespace"`
+	Image     string   `json:"image"`
+	Target    string   `json:"target"`
+	Command   []string `json:"command"`
+	// Copy debugs a copy of the pod with a debug sidecar instead of an ephemeral container.
+	Copy bool `json:"copy"`
+	// KeepCopy keeps the copy of the pod after the session ends.
+	KeepCopy bool `json:"keep_copy"`
+}
+
+type BuildDebug struct {
+	Mode      string `json:"mode"`
+	Pod       string `json:"pod"`
+	Container string `json:"container"`
+}
+
+// ephemeralContainer is v1.EphemeralContainer, which is not in the vendored api yet.
+type ephemeralContainer struct {
+	v1.Container
+	TargetContainerName string `json:"targetContainerName,omitempty"`
+}
+
+type containerPodStatus struct {
+	Status struct {
+		ContainerStatuses          []v1.ContainerStatus `json:"containerStatuses"`
+		EphemeralContainerStatuses []v1.ContainerStatus `json:"ephemeralContainerStatuses"`
+	} `json:"status"`
+}
+
+// Debug adds an ephemeral debug container to the pod and attaches a tty session to it, clusters without
+// ephemeral containers get a copy of the pod with a debug sidecar sharing the process namespace.
+func (p *Pod) Debug(requestParams interface{}) *utils.Response {
+	params := &DebugParams{}
+	json.Unmarshal(requestParams.([]byte), params)
+	klog.Info(params)
+	if params.SessionId == "" {
+		return &utils.Response{Code: code.ParamsError, Msg: "Session id is blank"}
+	}
+	if params.Name == "" || params.Namespace == "" {
+		return &utils.Response{Code: code.ParamsError, Msg: "Pod name or namespace is blank"}
+	}
+	if params.Image == "" {
+		params.Image = defaultDebugImage
+	}
+	pod, err := p.KubeClient.PodInformer().Lister().Pods(params.Namespace).Get(params.Name)
+	if err != nil {
+		return &utils.Response{Code: code.GetError, Msg: err.Error()}
+	}
+	if !params.Copy {
+		if err := p.checkEphemeralContainers(); err != nil {
+			return &utils.Response{Code: code.ParamsError, Msg: err.Error()}
+		}
+	}
+	// the session id is held while the container starts, so the wait stops when the session is closed
+	pending := newPendingSession()
+	if err := p.sessions.Add(params.SessionId, ExecSession, params.Namespace, params.Name, pending); err != nil {
+		return &utils.Response{Code: code.ParamsError, Msg: err.Error()}
+	}
+	container := v1.Container{
+		Name:                     "debugger-" + utils.RandString(5),
+		Image:                    params.Image,
+		Command:                  params.Command,
+		ImagePullPolicy:          v1.PullIfNotPresent,
+		Stdin:                    true,
+		TTY:                      true,
+		TerminationMessagePolicy: v1.TerminationMessageReadFile,
+	}
+	debug := &BuildDebug{Mode: EphemeralDebug, Pod: pod.Name, Container: container.Name}
+	if !params.Copy {
+		err = p.addEphemeralContainer(pod, &container, params.Target)
+		// the ephemeral containers subresource does not exist on older clusters, other errors are returned
+		if err != nil && (errors.IsNotFound(err) || errors.IsMethodNotSupported(err)) {
+			klog.Infof("add ephemeral container to pod %s/%s error: %v, debug a copy of the pod", pod.Namespace, pod.Name, err)
+			params.Copy = true
+		} else if err != nil {
+			p.sessions.Remove(params.SessionId, pending)
+			return &utils.Response{Code: code.UpdateError, Msg: err.Error()}
+		}
+	}
+	if params.Copy {
+		debugPod, err := p.ClientSet.CoreV1().Pods(pod.Namespace).Create(debugPodCopy(pod, &container))
+		if err != nil {
+			p.sessions.Remove(params.SessionId, pending)
+			return &utils.Response{Code: code.CreateError, Msg: err.Error()}
+		}
+		debug.Mode = CopyDebug
+		debug.Pod = debugPod.Name
+	}
+	go p.attachDebug(params, debug, pending)
+	return &utils.Response{Code: code.Success, Msg: "Success", Data: debug}
+}
+
+// checkEphemeralContainers returns a clear error on the clusters older than the ephemeral containers subresource
+// of the pod spec shape.
+func (p *Pod) checkEphemeralContainers() error {
+	info, err := p.KubeClient.DiscoveryClient.ServerVersion()
+	if err != nil {
+		return fmt.Errorf("get server version error: %v", err)
+	}
+	major, majorErr := leadingInt(info.Major)
+	minor, minorErr := leadingInt(info.Minor)
+	if majorErr != nil || minorErr != nil {
+		return fmt.Errorf("unknown server version %s.%s", info.Major, info.Minor)
+	}
+	if major == 1 && minor < ephemeralContainersMinorVersion {
+		return fmt.Errorf("ephemeral debug containers need Kubernetes 1.%d or later, the server is %s, "+
+			"debug a copy of the pod instead", ephemeralContainersMinorVersion, info.GitVersion)
+	}
+	return nil
+}
+
+// leadingInt parses the number at the start of a version part like "22+".
+func leadingInt(s string) (int, error) {
+	end := strings.IndexFunc(s, func(r rune) bool { return !unicode.IsDigit(r) })
+	if end >= 0 {
+		s = s[:end]
+	}
+	return strconv.Atoi(s)
+}
+
+func (p *Pod) addEphemeralContainer(pod *v1.Pod, container *v1.Container, target string) error {
+	patch := map[string]interface{}{
+		"spec": map[string]interface{}{
+			"ephemeralContainers": []*ephemeralContainer{{Container: *container, TargetContainerName: target}},
+		},
+	}
+	data, err := json.Marshal(patch)
+	if err != nil {
+		return err
+	}
+	_, err = p.ClientSet.CoreV1().Pods(pod.Namespace).Patch(pod.Name, types.StrategicMergePatchType, data, "ephemeralcontainers")
+	return err
+}
+
+// debugPodCopy copies the pod without its labels and probes, so the copy gets no traffic and is not restarted.
+func debugPodCopy(pod *v1.Pod, container *v1.Container) *v1.Pod {
+	name := pod.Name
+	if len(name) > 50 {
+		name = name[:50]
+	}
+	debugPod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        fmt.Sprintf("%s-debug-%s", name, utils.RandString(5)),
+			Namespace:   pod.Namespace,
+			Labels:      map[string]string{DebugPodLabel: pod.Name},
+			Annotations: pod.Annotations,
+		},
+		Spec: *pod.Spec.DeepCopy(),
+	}
+	debugPod.Spec.NodeName = ""
+	shareProcessNamespace := true
+	debugPod.Spec.ShareProcessNamespace = &shareProcessNamespace
+	for i := range debugPod.Spec.Containers {
+		debugPod.Spec.Containers[i].LivenessProbe = nil
+		debugPod.Spec.Containers[i].ReadinessProbe = nil
+	}
+	debugPod.Spec.Containers = append(debugPod.Spec.Containers, *container)
+	return debugPod
+}
+
+func (p *Pod) attachDebug(params *DebugParams, debug *BuildDebug, pending *pendingSession) {
+	if debug.Mode == CopyDebug && !params.KeepCopy {
+		defer func() {
+			if err := p.ClientSet.CoreV1().Pods(params.Namespace).Delete(debug.Pod, &metav1.DeleteOptions{}); err != nil {
+				klog.Errorf("delete debug pod %s/%s error: %v", params.Namespace, debug.Pod, err)
+			}
+		}()
+	}
+	ephemeral := debug.Mode == EphemeralDebug
+	err := waitContainerRunning(p.ClientSet, params.Namespace, debug.Pod, debug.Container, ephemeral, pending.done)
+	p.sessions.Remove(params.SessionId, pending)
+	if err != nil {
+		klog.Errorf("wait debug container %s of pod %s/%s error: %v", debug.Container, params.Namespace, debug.Pod, err)
+		sendExecError(p.SendResponse, p.sendStream, utils.ExecType, params.SessionId, err)
+		return
+	}
+	runExecSession(p.Config, p.sessions, p.SendResponse, p.sendStream, &execSessionOptions{
+		SessionId: params.SessionId,
+		Kind:      ExecSession,
+		Namespace: params.Namespace,
+		Pod:       debug.Pod,
+		Url:       attachUrl(p.ClientSet, params.Namespace, debug.Pod, debug.Container),
+		Tty:       true,
+		Stdin:     true,
+	})
+}
+
+// attachUrl is the url of a tty attach session to the container.
+func attachUrl(clientSet kube_client.Interface, namespace, pod, container string) *url.URL {
+	return clientSet.CoreV1().RESTClient().Post().
+		Resource("pods").
+		Name(pod).
+		Namespace(namespace).
+		SubResource("attach").
+		VersionedParams(&v1.PodAttachOptions{
+			Container: container,
+			Stdin:     true,
+			Stdout:    true,
+			TTY:       true,
+		}, scheme.ParameterCodec).
+		URL()
+}
+
+// waitContainerRunning waits for the container of the pod running until stopCh is closed, the pod is read raw
+// for the ephemeral container statuses.
+func waitContainerRunning(clientSet kube_client.Interface, namespace, pod, container string, ephemeral bool, stopCh <-chan struct{}) error {
+	var lastErr error
+	err := wait.PollImmediate(time.Second, containerStartTimeout, func() (bool, error) {
+		select {
+		case <-stopCh:
+			return false, fmt.Errorf("session is closed while waiting for container %s running", container)
+		default:
+		}
+		data, err := clientSet.CoreV1().RESTClient().Get().
+			Namespace(namespace).
+			Resource("pods").
+			Name(pod).
+			DoRaw()
+		if err != nil {
+			lastErr = err
+			return false, nil
+		}
+		podStatus := &containerPodStatus{}
+		if err := json.Unmarshal(data, podStatus); err != nil {
+			return false, err
+		}
+		statuses := podStatus.Status.ContainerStatuses
+		if ephemeral {
+			statuses = podStatus.Status.EphemeralContainerStatuses
+		}
+		for _, s := range statuses {
+			if s.Name != container {
+				continue
+			}
+			if s.State.Running != nil {
+				return true, nil
+			}
+			if s.State.Terminated != nil {
+				return false, fmt.Errorf("container terminated: %s %s", s.State.Terminated.Reason, s.State.Terminated.Message)
+			}
+			if s.State.Waiting != nil {
+				lastErr = fmt.Errorf("container is waiting: %s %s", s.State.Waiting.Reason, s.State.Waiting.Message)
+			}
+		}
+		return false, nil
+	})
+	if err == wait.ErrWaitTimeout && lastErr != nil {
+		return fmt.Errorf("timeout waiting for container running, %v", lastErr)
+	}
+	return err
+}
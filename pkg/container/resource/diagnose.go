@@ -0,0 +1,348 @@
+package resource
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/openspacee/ospagent/pkg/kubernetes"
+	"github.com/openspacee/ospagent/pkg/utils"
+	"github.com/openspacee/ospagent/pkg/utils/code"
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"sort"
+)
+
+const (
+	CriticalSeverity = "critical"
+	WarningSeverity  = "warning"
+
+	CrashLoopRule          = "CrashLoopBackOff"
+	ImagePullRule          = "ImagePullBackOff"
+	UnschedulableRule      = "Unschedulable"
+	OOMKilledRule          = "OOMKilled"
+	ProbeFailureRule       = "ProbeFailure"
+	NoReadyEndpointsRule   = "NoReadyEndpoints"
+	PendingClaimRule       = "PendingClaim"
+	ProgressDeadlineRule   = "ProgressDeadlineExceeded"
+	findingEvents          = 3
+	probeFailedEventReason = "Unhealthy"
+)
+
+var severityRanks = map[string]int{CriticalSeverity: 0, WarningSeverity: 1}
+
+type Finding struct {
+	Severity  string   `json:"severity"`
+	Rule      string   `json:"rule"`
+	Kind      string   `json:"kind"`
+	Namespace string   `json:"namespace"`
+	Name      string   `json:"name"`
+	Container string   `json:"container,omitempty"`
+	Message   string   `json:"message"`
+	Events    []string `json:"events"`
+}
+
+// DiagnoseInput is the objects to diagnose, the events are matched to the findings by involved object.
+type DiagnoseInput struct {
+	Pods                   []*v1.Pod
+	Services               []*v1.Service
+	Endpoints              []*v1.Endpoints
+	PersistentVolumeClaims []*v1.PersistentVolumeClaim
+	Deployments            []*appsv1.Deployment
+	Events                 []*v1.Event
+}
+
+// Diagnose runs the rules on the objects, the findings are ranked by severity.
+func Diagnose(input *DiagnoseInput) []*Finding {
+	var findings []*Finding
+	for _, pod := range input.Pods {
+		findings = append(findings, diagnosePod(pod, input.Events)...)
+	}
+	endpoints := make(map[string]*v1.Endpoints)
+	for _, e := range input.Endpoints {
+		endpoints[e.Namespace+"/"+e.Name] = e
+	}
+	for _, service := range input.Services {
+		if f := diagnoseService(service, endpoints[service.Namespace+"/"+service.Name]); f != nil {
+			findings = append(findings, f)
+		}
+	}
+	for _, pvc := range input.PersistentVolumeClaims {
+		if pvc.Status.Phase == v1.ClaimPending {
+			findings = append(findings, &Finding{
+				Severity:  WarningSeverity,
+				Rule:      PendingClaimRule,
+				Kind:      "PersistentVolumeClaim",
+				Namespace: pvc.Namespace,
+				Name:      pvc.Name,
+				Message:   "Claim is pending",
+			})
+		}
+	}
+	for _, dp := range input.Deployments {
+		for _, c := range dp.Status.Conditions {
+			if c.Type == appsv1.DeploymentProgressing && c.Reason == progressTimeout {
+				findings = append(findings, &Finding{
+					Severity:  CriticalSeverity,
+					Rule:      ProgressDeadlineRule,
+					Kind:      "Deployment",
+					Namespace: dp.Namespace,
+					Name:      dp.Name,
+					Message:   c.Message,
+				})
+			}
+		}
+	}
+	for _, f := range findings {
+		if f.Events == nil {
+			f.Events = objectEvents(input.Events, f.Kind, f.Namespace, f.Name, "")
+		}
+	}
+	sort.SliceStable(findings, func(i, j int) bool {
+		if severityRanks[findings[i].Severity] != severityRanks[findings[j].Severity] {
+			return severityRanks[findings[i].Severity] < severityRanks[findings[j].Severity]
+		}
+		if findings[i].Namespace != findings[j].Namespace {
+			return findings[i].Namespace < findings[j].Namespace
+		}
+		return findings[i].Name < findings[j].Name
+	})
+	return findings
+}
+
+func diagnosePod(pod *v1.Pod, events []*v1.Event) []*Finding {
+	var findings []*Finding
+	newFinding := func(severity, rule, container, message string) *Finding {
+		return &Finding{
+			Severity:  severity,
+			Rule:      rule,
+			Kind:      "Pod",
+			Namespace: pod.Namespace,
+			Name:      pod.Name,
+			Container: container,
+			Message:   message,
+		}
+	}
+	if pod.Status.Phase == v1.PodSucceeded || pod.Status.Phase == v1.PodFailed {
+		return nil
+	}
+	for _, c := range pod.Status.Conditions {
+		if c.Type == v1.PodScheduled && c.Status == v1.ConditionFalse && c.Reason == v1.PodReasonUnschedulable {
+			findings = append(findings, newFinding(CriticalSeverity, UnschedulableRule, "", c.Message))
+		}
+	}
+	statuses := append(append([]v1.ContainerStatus{}, pod.Status.InitContainerStatuses...), pod.Status.ContainerStatuses...)
+	for _, s := range statuses {
+		last := s.LastTerminationState.Terminated
+		if waiting := s.State.Waiting; waiting != nil {
+			switch waiting.Reason {
+			case "CrashLoopBackOff":
+				msg := fmt.Sprintf("Container restarted %d times", s.RestartCount)
+				if last != nil {
+					msg += fmt.Sprintf(", last terminated with %s exit code %d", last.Reason, last.ExitCode)
+					if last.Message != "" {
+						msg += ": " + last.Message
+					}
+				}
+				findings = append(findings, newFinding(CriticalSeverity, CrashLoopRule, s.Name, msg))
+			case "ImagePullBackOff", "ErrImagePull", "InvalidImageName":
+				findings = append(findings, newFinding(CriticalSeverity, ImagePullRule, s.Name,
+					fmt.Sprintf("%s %s: %s", waiting.Reason, s.Image, waiting.Message)))
+			}
+		}
+		if (last != nil && last.Reason == "OOMKilled") || (s.State.Terminated != nil && s.State.Terminated.Reason == "OOMKilled") {
+			findings = append(findings, newFinding(WarningSeverity, OOMKilledRule, s.Name,
+				"Container was killed for exceeding its memory limit"))
+		}
+	}
+	probeEvents := podEvents(events, pod, probeFailedEventReason)
+	if len(probeEvents) > 0 {
+		f := newFinding(WarningSeverity, ProbeFailureRule, "", "Probes are failing")
+		f.Events = probeEvents
+		findings = append(findings, f)
+	}
+	for _, f := range findings {
+		if f.Events == nil {
+			f.Events = podEvents(events, pod, "")
+		}
+	}
+	return findings
+}
+
+func diagnoseService(service *v1.Service, endpoints *v1.Endpoints) *Finding {
+	if len(service.Spec.Selector) == 0 || service.Spec.Type == v1.ServiceTypeExternalName {
+		return nil
+	}
+	notReady := 0
+	if endpoints != nil {
+		for _, subset := range endpoints.Subsets {
+			if len(subset.Addresses) > 0 {
+				return nil
+			}
+			notReady += len(subset.NotReadyAddresses)
+		}
+	}
+	msg := "Service has no endpoints, no pod matches the selector"
+	if notReady > 0 {
+		msg = fmt.Sprintf("Service has no ready endpoints, %d endpoints are not ready", notReady)
+	}
+	return &Finding{
+		Severity:  WarningSeverity,
+		Rule:      NoReadyEndpointsRule,
+		Kind:      "Service",
+		Namespace: service.Namespace,
+		Name:      service.Name,
+		Message:   msg,
+	}
+}
+
+func podEvents(events []*v1.Event, pod *v1.Pod, reason string) []string {
+	return objectEvents(events, "Pod", pod.Namespace, pod.Name, reason)
+}
+
+// objectEvents are the latest events of the object, of the reason when not blank.
+func objectEvents(events []*v1.Event, kind, namespace, name, reason string) []string {
+	var matched []*v1.Event
+	for _, e := range events {
+		if e.InvolvedObject.Kind != kind || e.InvolvedObject.Namespace != namespace || e.InvolvedObject.Name != name {
+			continue
+		}
+		if reason != "" && e.Reason != reason {
+			continue
+		}
+		matched = append(matched, e)
+	}
+	sort.Slice(matched, func(i, j int) bool {
+		return matched[i].LastTimestamp.Before(&matched[j].LastTimestamp)
+	})
+	if len(matched) > findingEvents {
+		matched = matched[len(matched)-findingEvents:]
+	}
+	res := []string{}
+	for _, e := range matched {
+		msg := fmt.Sprintf("%s %s: %s", e.Type, e.Reason, e.Message)
+		if e.Count > 1 {
+			msg += fmt.Sprintf(" (x%d)", e.Count)
+		}
+		res = append(res, msg)
+	}
+	return res
+}
+
+type Diagnosis struct {
+	*kubernetes.KubeClient
+}
+
+func NewDiagnosis(kubeClient *kubernetes.KubeClient) *Diagnosis {
+	return &Diagnosis{KubeClient: kubeClient}
+}
+
+type DiagnoseParams struct {
+	Namespace string `json:"namespace"`
+	// Kind and Name narrow the diagnosis to a workload and its pods, services and claims.
+	Kind string `json:"kind"`
+	Name string `json:"name"`
+}
+
+func (d *Diagnosis) Diagnose(requestParams interface{}) *utils.Response {
+	params := &DiagnoseParams{}
+	json.Unmarshal(requestParams.([]byte), params)
+	if params.Namespace == "" {
+		return &utils.Response{Code: code.ParamsError, Msg: "Namespace is blank"}
+	}
+	input, err := d.diagnoseInput(params)
+	if err != nil {
+		return &utils.Response{Code: code.ListError, Msg: err.Error()}
+	}
+	return &utils.Response{Code: code.Success, Msg: "Success", Data: Diagnose(input)}
+}
+
+func (d *Diagnosis) diagnoseInput(params *DiagnoseParams) (*DiagnoseInput, error) {
+	input := &DiagnoseInput{}
+	var err error
+	namespace := params.Namespace
+	if input.Events, err = d.EventInformer().Lister().Events(namespace).List(labels.Everything()); err != nil {
+		return nil, err
+	}
+	if input.Endpoints, err = d.EndpointsInformer().Lister().Endpoints(namespace).List(labels.Everything()); err != nil {
+		return nil, err
+	}
+	if params.Kind == "" {
+		if input.Pods, err = d.PodInformer().Lister().Pods(namespace).List(labels.Everything()); err != nil {
+			return nil, err
+		}
+		if input.Services, err = d.ServiceInformer().Lister().Services(namespace).List(labels.Everything()); err != nil {
+			return nil, err
+		}
+		if input.PersistentVolumeClaims, err = d.PersistentVolumeClaimInformer().Lister().PersistentVolumeClaims(namespace).List(labels.Everything()); err != nil {
+			return nil, err
+		}
+		if input.Deployments, err = d.DeploymentInformer().Lister().Deployments(namespace).List(labels.Everything()); err != nil {
+			return nil, err
+		}
+		return input, nil
+	}
+
+	var selector *metav1.LabelSelector
+	switch params.Kind {
+	case DeploymentKind:
+		dp, err := d.DeploymentInformer().Lister().Deployments(namespace).Get(params.Name)
+		if err != nil {
+			return nil, err
+		}
+		selector = dp.Spec.Selector
+		input.Deployments = []*appsv1.Deployment{dp}
+	case StatefulSetKind:
+		ss, err := d.StatefulSetInformer().Lister().StatefulSets(namespace).Get(params.Name)
+		if err != nil {
+			return nil, err
+		}
+		selector = ss.Spec.Selector
+	case DaemonSetKind:
+		ds, err := d.DaemonSetInformer().Lister().DaemonSets(namespace).Get(params.Name)
+		if err != nil {
+			return nil, err
+		}
+		selector = ds.Spec.Selector
+	default:
+		return nil, fmt.Errorf("unknown workload kind %s", params.Kind)
+	}
+	podSelector, err := metav1.LabelSelectorAsSelector(selector)
+	if err != nil {
+		return nil, err
+	}
+	if input.Pods, err = d.PodInformer().Lister().Pods(namespace).List(podSelector); err != nil {
+		return nil, err
+	}
+	services, err := d.ServiceInformer().Lister().Services(namespace).List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+	claims := make(map[string]bool)
+	for _, pod := range input.Pods {
+		for _, volume := range pod.Spec.Volumes {
+			if volume.PersistentVolumeClaim != nil {
+				claims[volume.PersistentVolumeClaim.ClaimName] = true
+			}
+		}
+	}
+	// only the claims of the existing pods are known
+	for name := range claims {
+		if pvc, err := d.PersistentVolumeClaimInformer().Lister().PersistentVolumeClaims(namespace).Get(name); err == nil {
+			input.PersistentVolumeClaims = append(input.PersistentVolumeClaims, pvc)
+		}
+	}
+	for _, service := range services {
+		if len(service.Spec.Selector) == 0 {
+			continue
+		}
+		serviceSelector := labels.SelectorFromSet(service.Spec.Selector)
+		for _, pod := range input.Pods {
+			if serviceSelector.Matches(labels.Set(pod.Labels)) {
+				input.Services = append(input.Services, service)
+				break
+			}
+		}
+	}
+	return input, nil
+}
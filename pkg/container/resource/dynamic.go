@@ -1,8 +1,6 @@
 package resource
 
 import (
-	"bufio"
-	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
@@ -10,17 +8,13 @@ import (
 	"github.com/openspacee/ospagent/pkg/utils"
 	"github.com/openspacee/ospagent/pkg/utils/code"
 	"github.com/pkg/errors"
-	"io"
 	"k8s.io/apimachinery/pkg/api/meta"
 	"k8s.io/apimachinery/pkg/runtime"
 	runtimeYaml "k8s.io/apimachinery/pkg/runtime/serializer/yaml"
-	"k8s.io/apimachinery/pkg/types"
-	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
 	"k8s.io/client-go/discovery/cached/memory"
 	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/restmapper"
 	"sigs.k8s.io/yaml"
-	"strings"
 
 	//"gopkg.in/yaml.v2"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -105,60 +99,22 @@ func (d *DynamicResource) UpdateYaml(updateParams interface{}) *utils.Response {
 	return &utils.Response{Code: code.Success}
 }
 
-type ApplyParams struct {
-	YamlStr string `json:"yaml"`
-}
-
-func (d *DynamicResource) ApplyYaml(applyParams interface{}) *utils.Response {
-	params := &ApplyParams{}
-	json.Unmarshal(applyParams.([]byte), params)
-	multidocReader := utilyaml.NewYAMLReader(bufio.NewReader(bytes.NewReader([]byte(params.YamlStr))))
-	var res []string
-	applyErr := false
-	for {
-		buf, err := multidocReader.Read()
-		if err != nil {
-			if err == io.EOF {
-				break
-			}
-			return &utils.Response{Code: code.ParamsError, Msg: "read yaml error: " + err.Error()}
-		}
-		obj, dr, err := d.buildDynamicResourceClient(buf)
-		if err != nil {
-			applyErr = true
-			res = append(res, err.Error())
-			continue
-		}
-
-		// Create or Update
-		_, err = dr.Patch(obj.GetName(), types.ApplyPatchType, buf, metav1.PatchOptions{
-			FieldManager: "ospagent",
-		})
-		if err != nil {
-			applyErr = true
-			res = append(res, obj.GetKind()+"/"+obj.GetName()+" error : "+err.Error())
-		} else {
-			res = append(res, obj.GetKind()+"/"+obj.GetName()+" applied successful.")
-		}
-	}
-	if applyErr {
-		return &utils.Response{Code: code.ApplyError, Msg: strings.Join(res, "\n")}
-	}
-	return &utils.Response{Code: code.Success, Msg: strings.Join(res, "\n")}
-}
-
-func (d *DynamicResource) buildDynamicResourceClient(data []byte) (obj *unstructured.Unstructured, dr dynamic.ResourceInterface, err error) {
+func (d *DynamicResource) decodeYaml(data []byte) (*unstructured.Unstructured, error) {
 	// Decode YAML manifest into unstructured.Unstructured
-	obj = &unstructured.Unstructured{}
-	_, gvk, err := d.decUnstructured.Decode(data, nil, obj)
+	obj := &unstructured.Unstructured{}
+	_, _, err := d.decUnstructured.Decode(data, nil, obj)
 	if err != nil {
-		return obj, dr, errors.Wrap(err, "Decode yaml failed. ")
+		return obj, errors.Wrap(err, "Decode yaml failed. ")
 	}
+	return obj, nil
+}
 
+func (d *DynamicResource) buildDynamicResourceClient(obj *unstructured.Unstructured) (dr dynamic.ResourceInterface, mapping *meta.RESTMapping, err error) {
 	// Find GVR
-	mapping, err := d.restMapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+	gvk := obj.GroupVersionKind()
+	mapping, err = d.restMapper.RESTMapping(gvk.GroupKind(), gvk.Version)
 	if err != nil {
-		return obj, dr, errors.Wrap(err, "Mapping kind with version failed")
+		return dr, mapping, errors.Wrap(err, "Mapping kind with version failed")
 	}
 
 	// Obtain REST interface for the GVR
@@ -169,5 +125,5 @@ func (d *DynamicResource) buildDynamicResourceClient(data []byte) (obj *unstruct
 		// for cluster-wide resources
 		dr = d.DynamicClient.Resource(mapping.Resource)
 	}
-	return obj, dr, nil
+	return dr, mapping, nil
 }
@@ -0,0 +1,312 @@
+package resource
+
+import (
+	"fmt"
+	"github.com/openspacee/ospagent/pkg/utils"
+	"github.com/openspacee/ospagent/pkg/websocket"
+	"io"
+	"k8s.io/apimachinery/pkg/util/httpstream"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/remotecommand"
+	"k8s.io/client-go/transport/spdy"
+	"k8s.io/client-go/util/exec"
+	"k8s.io/klog"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+const stdinTimeout = 10 * time.Second
+
+type execSessionOptions struct {
+	SessionId string
+	Kind      string
+	Namespace string
+	Pod       string
+	Url       *url.URL
+	Tty       bool
+	Stdin     bool
+	// ResType is the response type of the output frames, exec by default.
+	ResType string
+	// Stdout replaces the stdout frames when it is set.
+	Stdout io.Writer
+	// StdinHook is called before the input is written to stdin, the input is dropped when it returns error.
+	StdinHook func([]byte) error
+}
+
+// connTrackingUpgrader keeps the upgraded spdy connection, so the session can be closed from our side.
+type connTrackingUpgrader struct {
+	spdy.Upgrader
+	mutex sync.Mutex
+	conn  httpstream.Connection
+}
+
+func (u *connTrackingUpgrader) NewConnection(resp *http.Response) (httpstream.Connection, error) {
+	conn, err := u.Upgrader.NewConnection(resp)
+	u.mutex.Lock()
+	u.conn = conn
+	u.mutex.Unlock()
+	return conn, err
+}
+
+func (u *connTrackingUpgrader) Close() error {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	if u.conn == nil {
+		return nil
+	}
+	return u.conn.Close()
+}
+
+type execSession struct {
+	SessionId      string
+	inChan         chan []byte
+	pending        []byte
+	resizeEvent    chan remotecommand.TerminalSize
+	done           chan struct{}
+	closeOnce      sync.Once
+	stdinDone      chan struct{}
+	stdinCloseOnce sync.Once
+	stdinHook      func([]byte) error
+	upgrader       *connTrackingUpgrader
+}
+
+func newExecSession(sessionId string, upgrader *connTrackingUpgrader) *execSession {
+	return &execSession{
+		SessionId:   sessionId,
+		inChan:      make(chan []byte, 64),
+		resizeEvent: make(chan remotecommand.TerminalSize, 1),
+		done:        make(chan struct{}),
+		stdinDone:   make(chan struct{}),
+		upgrader:    upgrader,
+	}
+}
+
+func (s *execSession) Read(p []byte) (size int, err error) {
+	if len(s.pending) == 0 {
+		select {
+		case inData := <-s.inChan:
+			s.pending = inData
+		case <-s.done:
+			return 0, io.EOF
+		case <-s.stdinDone:
+			// send what is written before stdin is closed
+			select {
+			case inData := <-s.inChan:
+				s.pending = inData
+			default:
+				return 0, io.EOF
+			}
+		}
+	}
+	size = copy(p, s.pending)
+	s.pending = s.pending[size:]
+	return
+}
+
+// executor回调获取web是否resize
+func (s *execSession) Next() (size *remotecommand.TerminalSize) {
+	select {
+	case ret := <-s.resizeEvent:
+		size = &ret
+	case <-s.done:
+	}
+	return
+}
+
+func (s *execSession) WriteStdin(data []byte) error {
+	select {
+	case <-s.stdinDone:
+		return fmt.Errorf("session %s stdin is closed", s.SessionId)
+	default:
+	}
+	if s.stdinHook != nil {
+		if err := s.stdinHook(data); err != nil {
+			return err
+		}
+	}
+	select {
+	case s.inChan <- data:
+		return nil
+	case <-s.done:
+		return fmt.Errorf("session %s is closed", s.SessionId)
+	case <-time.After(stdinTimeout):
+		return fmt.Errorf("session %s stdin is blocked", s.SessionId)
+	}
+}
+
+func (s *execSession) Resize(width, height uint16) {
+	size := remotecommand.TerminalSize{Width: width, Height: height}
+	select {
+	case s.resizeEvent <- size:
+	default:
+		// drop the stale size not consumed yet
+		select {
+		case <-s.resizeEvent:
+		default:
+		}
+		select {
+		case s.resizeEvent <- size:
+		default:
+		}
+	}
+}
+
+// CloseStdin sends EOF to the remote process after the written input is consumed.
+func (s *execSession) CloseStdin() {
+	s.stdinCloseOnce.Do(func() {
+		close(s.stdinDone)
+	})
+}
+
+func (s *execSession) HandleFrame(frame *utils.Frame) error {
+	switch frame.Stream {
+	case utils.StdinFrame:
+		return s.WriteStdin(frame.Payload)
+	case utils.ResizeFrame:
+		width, height, err := utils.ParseResizePayload(frame.Payload)
+		if err != nil {
+			return err
+		}
+		s.Resize(width, height)
+	case utils.CloseFrame:
+		s.CloseStdin()
+	}
+	return nil
+}
+
+func (s *execSession) isClosed() bool {
+	select {
+	case <-s.done:
+		return true
+	default:
+		return false
+	}
+}
+
+func (s *execSession) Close() error {
+	s.closeOnce.Do(func() {
+		close(s.done)
+	})
+	return s.upgrader.Close()
+}
+
+// execWriter sends the output of stdout or stderr to the server.
+type execWriter struct {
+	SessionId string
+	resType   string
+	stream    byte
+	sessions  *SessionManager
+	websocket.SendStream
+}
+
+func (w *execWriter) Write(p []byte) (size int, err error) {
+	copyData := make([]byte, len(p))
+	copy(copyData, p)
+	size = len(p)
+	w.SendStream(w.resType, &utils.Frame{SessionId: w.SessionId, Stream: w.stream, Payload: copyData})
+	w.sessions.Touch(w.SessionId)
+	return
+}
+
+func sendExecError(sendResponse websocket.SendResponse, sendStream websocket.SendStream, resType, sessionId string, err error) {
+	sendStream(resType, &utils.Frame{SessionId: sessionId, Stream: utils.StdoutFrame, Payload: []byte(err.Error())})
+	sendResponse(&utils.ExecResult{ExitCode: -1, Error: err.Error()}, sessionId, utils.ExecResultType)
+	sendStream(resType, &utils.Frame{SessionId: sessionId, Stream: utils.CloseFrame})
+}
+
+// runExecSession streams an exec or attach request of a container until the remote process exits
+// or the session is closed, then reports the exit code.
+func runExecSession(
+	config *rest.Config,
+	sessions *SessionManager,
+	sendResponse websocket.SendResponse,
+	sendStream websocket.SendStream,
+	opts *execSessionOptions) {
+
+	sessionId := opts.SessionId
+	resType := opts.ResType
+	if resType == "" {
+		resType = utils.ExecType
+	}
+	transport, upgrader, err := spdy.RoundTripperFor(config)
+	if err != nil {
+		klog.Error("exec pod container error", err)
+		sendExecError(sendResponse, sendStream, resType, sessionId, err)
+		return
+	}
+	tracker := &connTrackingUpgrader{Upgrader: upgrader}
+	executor, err := remotecommand.NewSPDYExecutorForTransports(transport, tracker, "POST", opts.Url)
+	if err != nil {
+		klog.Error("exec pod container error", err)
+		sendExecError(sendResponse, sendStream, resType, sessionId, err)
+		return
+	}
+
+	session := newExecSession(sessionId, tracker)
+	session.stdinHook = opts.StdinHook
+	if err := sessions.Add(sessionId, opts.Kind, opts.Namespace, opts.Pod, session); err != nil {
+		klog.Errorf("add exec session %s error: %v", sessionId, err)
+		if _, ok := err.(*sessionExistsError); ok {
+			// the frames and result of the id belong to the running session
+			return
+		}
+		sendExecError(sendResponse, sendStream, resType, sessionId, err)
+		return
+	}
+	defer sessions.Remove(sessionId, session)
+	defer session.Close()
+
+	stdout := &execWriter{
+		SessionId:  sessionId,
+		resType:    resType,
+		stream:     utils.StdoutFrame,
+		sessions:   sessions,
+		SendStream: sendStream,
+	}
+	streamOptions := remotecommand.StreamOptions{
+		Stdout: stdout,
+		Stderr: stdout,
+		Tty:    opts.Tty,
+	}
+	if opts.Stdin {
+		streamOptions.Stdin = session
+	}
+	if opts.Stdout != nil {
+		streamOptions.Stdout = opts.Stdout
+	}
+	if opts.Tty {
+		streamOptions.TerminalSizeQueue = session
+	} else {
+		streamOptions.Stderr = &execWriter{
+			SessionId:  sessionId,
+			resType:    resType,
+			stream:     utils.StderrFrame,
+			sessions:   sessions,
+			SendStream: sendStream,
+		}
+	}
+	klog.Info("start stream session", sessionId)
+	result := &utils.ExecResult{}
+	if err := executor.Stream(streamOptions); err != nil {
+		if exitErr, ok := err.(exec.ExitError); ok && exitErr.Exited() {
+			result.ExitCode = exitErr.ExitStatus()
+		} else {
+			if session.isClosed() {
+				err = fmt.Errorf("session %s is closed", sessionId)
+			}
+			klog.Errorf("exec pod container error session %s: %v", sessionId, err)
+			if opts.Tty {
+				stdout.Write([]byte(err.Error()))
+			}
+			result.ExitCode = -1
+			result.Error = err.Error()
+		}
+	} else if opts.Tty {
+		stdout.Write([]byte("\nConnection closed"))
+	}
+	sendResponse(result, sessionId, utils.ExecResultType)
+	sendStream(resType, &utils.Frame{SessionId: sessionId, Stream: utils.CloseFrame})
+	klog.Info("end stream session", sessionId)
+}
@@ -0,0 +1,292 @@
+package resource
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/openspacee/ospagent/pkg/utils"
+	"github.com/openspacee/ospagent/pkg/utils/code"
+	"github.com/openspacee/ospagent/pkg/websocket"
+	"io"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog"
+	"sync"
+	"time"
+)
+
+const defaultLogTailLines = int64(100)
+
+type OpenPodLogParams struct {
+	Name          string                `json:"name"`
+	Namespace     string                `json:"namespace"`
+	Container     string                `json:"container"`
+	SessionId     string                `json:"session_id"`
+	AllContainers bool                  `json:"all_containers"`
+	LabelSelector *metav1.LabelSelector `json:"label_selector"`
+	Previous      bool                  `json:"previous"`
+	SinceSeconds  *int64                `json:"since_seconds"`
+	SinceTime     *metav1.Time          `json:"since_time"`
+	Timestamps    bool                  `json:"timestamps"`
+	LimitBytes    *int64                `json:"limit_bytes"`
+	TailLines     *int64                `json:"tail_lines"`
+	Follow        *bool                 `json:"follow"`
+	LogFilterParams
+}
+
+type logTarget struct {
+	Pod       string `json:"pod"`
+	Container string `json:"container"`
+}
+
+func (t *logTarget) String() string {
+	if t.Container == "" {
+		return t.Pod
+	}
+	return t.Pod + "/" + t.Container
+}
+
+func (p *Pod) OpenLog(requestParams interface{}) *utils.Response {
+	params := &OpenPodLogParams{}
+	json.Unmarshal(requestParams.([]byte), params)
+	klog.Info(params)
+	if params.SessionId == "" {
+		return &utils.Response{Code: code.ParamsError, Msg: "Session id is blank"}
+	}
+	if params.Namespace == "" {
+		return &utils.Response{Code: code.ParamsError, Msg: "Namespace is blank"}
+	}
+	if params.Name == "" && params.LabelSelector == nil {
+		return &utils.Response{Code: code.ParamsError, Msg: "Pod name and label selector are both blank"}
+	}
+	targets, err := p.logTargets(params)
+	if err != nil {
+		return &utils.Response{Code: code.ParamsError, Msg: err.Error()}
+	}
+	if len(targets) == 0 {
+		return &utils.Response{Code: code.ParamsError, Msg: "No pod container matched"}
+	}
+	podLogOpts := &v1.PodLogOptions{
+		Follow:       true,
+		Previous:     params.Previous,
+		SinceSeconds: params.SinceSeconds,
+		SinceTime:    params.SinceTime,
+		Timestamps:   params.Timestamps,
+		LimitBytes:   params.LimitBytes,
+		TailLines:    params.TailLines,
+	}
+	if params.Follow != nil {
+		podLogOpts.Follow = *params.Follow
+	}
+	if podLogOpts.TailLines == nil && podLogOpts.SinceSeconds == nil && podLogOpts.SinceTime == nil {
+		tailLines := defaultLogTailLines
+		podLogOpts.TailLines = &tailLines
+	} else if podLogOpts.TailLines != nil && *podLogOpts.TailLines < 0 {
+		// negative tail lines means all the logs
+		podLogOpts.TailLines = nil
+	}
+	filter, err := newLogFilter(&params.LogFilterParams, params.Timestamps)
+	if err != nil {
+		return &utils.Response{Code: code.ParamsError, Msg: err.Error()}
+	}
+	session := &logSession{
+		SessionId:  params.SessionId,
+		SendStream: p.sendStream,
+		sessions:   p.sessions,
+		prefix:     len(targets) > 1 || params.AllContainers || params.LabelSelector != nil,
+		filter:     filter,
+	}
+	if err := p.sessions.Add(params.SessionId, LogSession, params.Namespace, params.Name, session); err != nil {
+		klog.Errorf("add log session %s error: %v", params.SessionId, err)
+		return &utils.Response{Code: code.ParamsError, Msg: err.Error()}
+	}
+	go p.logProcess(params.Namespace, session, targets, podLogOpts)
+	return &utils.Response{Code: code.Success, Msg: "Success", Data: targets}
+}
+
+// logTargets finds the containers to stream logs from, a single container by default, all containers of
+// the pod, or all containers of the pods matching the label selector.
+func (p *Pod) logTargets(params *OpenPodLogParams) ([]*logTarget, error) {
+	var pods []*v1.Pod
+	if params.LabelSelector != nil {
+		selector, err := metav1.LabelSelectorAsSelector(params.LabelSelector)
+		if err != nil {
+			return nil, err
+		}
+		pods, err = p.KubeClient.PodInformer().Lister().Pods(params.Namespace).List(selector)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		if !params.AllContainers {
+			return []*logTarget{{Pod: params.Name, Container: params.Container}}, nil
+		}
+		pod, err := p.KubeClient.PodInformer().Lister().Pods(params.Namespace).Get(params.Name)
+		if err != nil {
+			return nil, err
+		}
+		pods = []*v1.Pod{pod}
+	}
+	var targets []*logTarget
+	for _, pod := range pods {
+		if params.Name != "" && params.LabelSelector != nil && pod.Name != params.Name {
+			continue
+		}
+		for _, c := range pod.Spec.InitContainers {
+			if !containerStarted(pod.Status.InitContainerStatuses, c.Name) {
+				continue
+			}
+			if params.Container == "" || params.Container == c.Name {
+				targets = append(targets, &logTarget{Pod: pod.Name, Container: c.Name})
+			}
+		}
+		for _, c := range pod.Spec.Containers {
+			if params.Container == "" || params.Container == c.Name {
+				targets = append(targets, &logTarget{Pod: pod.Name, Container: c.Name})
+			}
+		}
+	}
+	return targets, nil
+}
+
+// containerStarted checks whether the container has ever run, init containers waiting to start have no logs.
+func containerStarted(statuses []v1.ContainerStatus, name string) bool {
+	for _, s := range statuses {
+		if s.Name == name {
+			return s.State.Waiting == nil || s.RestartCount > 0
+		}
+	}
+	return false
+}
+
+type ClosePodLogParams struct {
+	SessionId string `json:"session_id"`
+}
+
+func (p *Pod) CloseLog(requestParams interface{}) *utils.Response {
+	params := &ClosePodLogParams{}
+	json.Unmarshal(requestParams.([]byte), params)
+	klog.Info(params)
+	p.sessions.Close(params.SessionId)
+	return &utils.Response{Code: code.Success, Msg: "Success"}
+}
+
+// logSession merges the log streams of one or more containers, every line is prefixed
+// with pod/container when streaming more than one container.
+type logSession struct {
+	SessionId string
+	websocket.SendStream
+	sessions *SessionManager
+	prefix   bool
+	filter   *logFilter
+	mutex    sync.Mutex
+	streams  []io.ReadCloser
+	closed   bool
+}
+
+func (l *logSession) addStream(stream io.ReadCloser) bool {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.closed {
+		return false
+	}
+	l.streams = append(l.streams, stream)
+	return true
+}
+
+func (l *logSession) Close() error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.closed = true
+	for _, stream := range l.streams {
+		stream.Close()
+	}
+	l.streams = nil
+	return nil
+}
+
+func (l *logSession) isClosed() bool {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.closed
+}
+
+func (l *logSession) HandleFrame(frame *utils.Frame) error {
+	if frame.Stream == utils.CloseFrame {
+		return l.Close()
+	}
+	return nil
+}
+
+func (l *logSession) Write(p []byte) (size int, err error) {
+	copyData := make([]byte, len(p))
+	copy(copyData, p)
+	size = len(p)
+	l.SendStream(utils.LogType, &utils.Frame{SessionId: l.SessionId, Stream: utils.StdoutFrame, Payload: copyData})
+	l.sessions.Touch(l.SessionId)
+	return
+}
+
+func (l *logSession) writeTarget(target *logTarget, line []byte) {
+	if !l.prefix {
+		l.Write(line)
+		return
+	}
+	data := make([]byte, 0, len(target.String())+3+len(line))
+	data = append(data, '[')
+	data = append(data, target.String()...)
+	data = append(data, "] "...)
+	data = append(data, line...)
+	l.Write(data)
+}
+
+func (l *logSession) writeError(target *logTarget, err error) {
+	l.writeTarget(target, []byte(err.Error()+"\n"))
+}
+
+func (p *Pod) logProcess(namespace string, session *logSession, targets []*logTarget, podLogOpts *v1.PodLogOptions) {
+	klog.Info("start log session ", session.SessionId)
+	defer p.sessions.Remove(session.SessionId, session)
+	defer session.SendStream(utils.LogType, &utils.Frame{SessionId: session.SessionId, Stream: utils.CloseFrame})
+
+	var wg sync.WaitGroup
+	for _, target := range targets {
+		wg.Add(1)
+		go func(target *logTarget) {
+			defer wg.Done()
+			p.streamLog(namespace, session, target, podLogOpts)
+		}(target)
+	}
+	wg.Wait()
+	if suppressed := session.filter.Flush(); suppressed > 0 {
+		session.Write(suppressedMarker(suppressed))
+	}
+	klog.Info("end log session ", session.SessionId)
+}
+
+func (p *Pod) streamLog(namespace string, session *logSession, target *logTarget, podLogOpts *v1.PodLogOptions) {
+	opts := podLogOpts.DeepCopy()
+	opts.Container = target.Container
+	req := p.ClientSet.CoreV1().Pods(namespace).GetLogs(target.Pod, opts)
+	podLogs, err := req.Stream()
+	if err != nil {
+		klog.Errorf("open log stream %s of session %s error: %v", target, session.SessionId, err)
+		session.writeError(target, err)
+		return
+	}
+	defer podLogs.Close()
+	if !session.addStream(podLogs) {
+		return
+	}
+
+	writer := session.filter.newWriter(time.Now, func(marker []byte) {
+		session.Write(marker)
+	}, func(line []byte) {
+		session.writeTarget(target, line)
+	})
+	_, err = io.Copy(writer, podLogs)
+	writer.flushLine()
+	if err != nil && !session.isClosed() {
+		klog.Errorf("copy log stream %s of session %s error: %v", target, session.SessionId, err)
+		session.writeError(target, fmt.Errorf("log stream error: %v", err))
+	}
+}
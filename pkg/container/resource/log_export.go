@@ -0,0 +1,362 @@
+package resource
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/openspacee/ospagent/pkg/utils"
+	"github.com/openspacee/ospagent/pkg/utils/code"
+	"github.com/openspacee/ospagent/pkg/websocket"
+	"io"
+	"io/ioutil"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog"
+	"os"
+	"time"
+)
+
+const (
+	defaultExportContainerBytes = int64(10 * 1024 * 1024)
+	defaultExportTotalBytes     = int64(100 * 1024 * 1024)
+	maxExportTotalBytes         = int64(500 * 1024 * 1024)
+	exportChunkSize             = 32 * 1024
+	exportManifestFile          = "manifest.json"
+)
+
+type ExportLogsParams struct {
+	Namespace         string                `json:"namespace"`
+	Pods              []string              `json:"pods"`
+	Containers        []string              `json:"containers"`
+	LabelSelector     *metav1.LabelSelector `json:"label_selector"`
+	Previous          bool                  `json:"previous"`
+	SinceSeconds      *int64                `json:"since_seconds"`
+	SinceTime         *metav1.Time          `json:"since_time"`
+	UntilTime         *metav1.Time          `json:"until_time"`
+	MaxContainerBytes int64                 `json:"max_container_bytes"`
+	MaxTotalBytes     int64                 `json:"max_total_bytes"`
+}
+
+type ExportLogEntry struct {
+	Pod       string `json:"pod"`
+	Container string `json:"container"`
+	Previous  bool   `json:"previous"`
+	File      string `json:"file"`
+	Bytes     int64  `json:"bytes"`
+	Truncated bool   `json:"truncated"`
+	Error     string `json:"error,omitempty"`
+}
+
+type ExportLogsManifest struct {
+	Namespace  string            `json:"namespace"`
+	Created    metav1.Time       `json:"created"`
+	SinceTime  *metav1.Time      `json:"since_time,omitempty"`
+	UntilTime  *metav1.Time      `json:"until_time,omitempty"`
+	Entries    []*ExportLogEntry `json:"entries"`
+	TotalBytes int64             `json:"total_bytes"`
+	Truncated  bool              `json:"truncated"`
+}
+
+// chunkWriter sends the written data to the server in chunks tied to the request id.
+type chunkWriter struct {
+	requestId  string
+	sendStream websocket.SendStream
+	buf        []byte
+}
+
+func (w *chunkWriter) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+	for len(w.buf) >= exportChunkSize {
+		w.send(w.buf[:exportChunkSize])
+		w.buf = w.buf[exportChunkSize:]
+	}
+	return len(p), nil
+}
+
+func (w *chunkWriter) send(data []byte) {
+	chunk := make([]byte, len(data))
+	copy(chunk, data)
+	w.sendStream(utils.ExportType, &utils.Frame{SessionId: w.requestId, Stream: utils.StdoutFrame, Payload: chunk})
+}
+
+func (w *chunkWriter) Close() error {
+	if len(w.buf) > 0 {
+		w.send(w.buf)
+		w.buf = nil
+	}
+	w.sendStream(utils.ExportType, &utils.Frame{SessionId: w.requestId, Stream: utils.CloseFrame})
+	return nil
+}
+
+// ExportLogs collects the logs of pods into a gzip tar with a manifest, the archive is streamed
+// to the server before the response, which carries the manifest.
+func (p *Pod) ExportLogs(requestId string, requestParams interface{}) *utils.Response {
+	params := &ExportLogsParams{}
+	json.Unmarshal(requestParams.([]byte), params)
+	klog.Info(params)
+	if params.Namespace == "" {
+		return &utils.Response{Code: code.ParamsError, Msg: "Namespace is blank"}
+	}
+	if len(params.Pods) == 0 && params.LabelSelector == nil {
+		return &utils.Response{Code: code.ParamsError, Msg: "Pods and label selector are both blank"}
+	}
+	if params.MaxContainerBytes <= 0 {
+		params.MaxContainerBytes = defaultExportContainerBytes
+	}
+	if params.MaxTotalBytes <= 0 {
+		params.MaxTotalBytes = defaultExportTotalBytes
+	}
+	if params.MaxTotalBytes > maxExportTotalBytes {
+		params.MaxTotalBytes = maxExportTotalBytes
+	}
+	entries, err := p.exportLogEntries(params)
+	if err != nil {
+		return &utils.Response{Code: code.ParamsError, Msg: err.Error()}
+	}
+	if len(entries) == 0 {
+		return &utils.Response{Code: code.ParamsError, Msg: "No pod container matched"}
+	}
+
+	manifest := &ExportLogsManifest{
+		Namespace: params.Namespace,
+		Created:   metav1.Now(),
+		SinceTime: params.SinceTime,
+		UntilTime: params.UntilTime,
+		Entries:   entries,
+	}
+	writer := &chunkWriter{requestId: requestId, sendStream: p.sendStream}
+	defer writer.Close()
+	gzipWriter := gzip.NewWriter(writer)
+	tarWriter := tar.NewWriter(gzipWriter)
+	for _, entry := range entries {
+		remain := params.MaxTotalBytes - manifest.TotalBytes
+		if remain <= 0 {
+			entry.Error = "total size cap reached"
+			manifest.Truncated = true
+			continue
+		}
+		limit := params.MaxContainerBytes
+		if remain < limit {
+			limit = remain
+		}
+		logFile, err := p.fetchExportLog(params, entry, limit)
+		if err != nil {
+			klog.Errorf("export log of %s/%s error: %v", entry.Pod, entry.Container, err)
+			entry.Error = err.Error()
+			continue
+		}
+		manifest.TotalBytes += entry.Bytes
+		if entry.Truncated {
+			manifest.Truncated = true
+		}
+		err = writeTarEntry(tarWriter, entry.File, logFile, entry.Bytes, manifest.Created.Time)
+		removeExportLog(logFile)
+		if err != nil {
+			return &utils.Response{Code: code.ExportError, Msg: err.Error()}
+		}
+	}
+	manifestData, _ := json.MarshalIndent(manifest, "", "  ")
+	if err := writeTarFile(tarWriter, exportManifestFile, manifestData, manifest.Created.Time); err != nil {
+		return &utils.Response{Code: code.ExportError, Msg: err.Error()}
+	}
+	if err := tarWriter.Close(); err != nil {
+		return &utils.Response{Code: code.ExportError, Msg: err.Error()}
+	}
+	if err := gzipWriter.Close(); err != nil {
+		return &utils.Response{Code: code.ExportError, Msg: err.Error()}
+	}
+	klog.Infof("export %d logs of request %s, %d bytes", len(entries), requestId, manifest.TotalBytes)
+	return &utils.Response{Code: code.Success, Msg: "Success", Data: manifest}
+}
+
+func (p *Pod) exportLogEntries(params *ExportLogsParams) ([]*ExportLogEntry, error) {
+	var pods []*v1.Pod
+	lister := p.KubeClient.PodInformer().Lister().Pods(params.Namespace)
+	if len(params.Pods) > 0 {
+		for _, name := range params.Pods {
+			pod, err := lister.Get(name)
+			if err != nil {
+				return nil, err
+			}
+			pods = append(pods, pod)
+		}
+	} else {
+		selector, err := metav1.LabelSelectorAsSelector(params.LabelSelector)
+		if err != nil {
+			return nil, err
+		}
+		if pods, err = lister.List(selector); err != nil {
+			return nil, err
+		}
+	}
+	var entries []*ExportLogEntry
+	addEntries := func(pod *v1.Pod, statuses []v1.ContainerStatus, containers []v1.Container, init bool) {
+		for _, c := range containers {
+			if len(params.Containers) > 0 && !utils.Contains(params.Containers, c.Name) {
+				continue
+			}
+			if init && !containerStarted(statuses, c.Name) {
+				continue
+			}
+			entries = append(entries, &ExportLogEntry{
+				Pod:       pod.Name,
+				Container: c.Name,
+				File:      fmt.Sprintf("%s/%s.log", pod.Name, c.Name),
+			})
+			if params.Previous && containerRestarted(statuses, c.Name) {
+				entries = append(entries, &ExportLogEntry{
+					Pod:       pod.Name,
+					Container: c.Name,
+					Previous:  true,
+					File:      fmt.Sprintf("%s/%s.previous.log", pod.Name, c.Name),
+				})
+			}
+		}
+	}
+	for _, pod := range pods {
+		addEntries(pod, pod.Status.InitContainerStatuses, pod.Spec.InitContainers, true)
+		addEntries(pod, pod.Status.ContainerStatuses, pod.Spec.Containers, false)
+	}
+	return entries, nil
+}
+
+func containerRestarted(statuses []v1.ContainerStatus, name string) bool {
+	for _, s := range statuses {
+		if s.Name == name {
+			return s.RestartCount > 0
+		}
+	}
+	return false
+}
+
+var errExportLogLimit = errors.New("export log limit reached")
+
+// exportLogWriter writes the lines of the log into the file until limit bytes, the write fails
+// with errExportLogLimit at the first line over the limit to stop reading the log.
+type exportLogWriter struct {
+	lines     *lineWriter
+	file      *os.File
+	limit     int64
+	size      int64
+	truncated bool
+	err       error
+}
+
+func (w *exportLogWriter) Write(p []byte) (int, error) {
+	w.lines.Write(p)
+	return len(p), w.status()
+}
+
+// Flush writes the buffered partial line.
+func (w *exportLogWriter) Flush() error {
+	w.lines.Flush()
+	return w.status()
+}
+
+func (w *exportLogWriter) writeLine(line []byte) {
+	if w.truncated || w.err != nil {
+		return
+	}
+	if w.size+int64(len(line)) > w.limit {
+		w.truncated = true
+		return
+	}
+	n, err := w.file.Write(line)
+	w.size += int64(n)
+	w.err = err
+}
+
+func (w *exportLogWriter) status() error {
+	if w.err != nil {
+		return w.err
+	}
+	if w.truncated {
+		return errExportLogLimit
+	}
+	return nil
+}
+
+// fetchExportLog writes the container log into a temporary file, lines after the until time are dropped
+// and the log is truncated at limit bytes. The file is rewound for reading.
+func (p *Pod) fetchExportLog(params *ExportLogsParams, entry *ExportLogEntry, limit int64) (*os.File, error) {
+	podLogOpts := &v1.PodLogOptions{
+		Container:    entry.Container,
+		Previous:     entry.Previous,
+		SinceSeconds: params.SinceSeconds,
+		SinceTime:    params.SinceTime,
+		Timestamps:   true,
+	}
+	podLogs, err := p.ClientSet.CoreV1().Pods(params.Namespace).GetLogs(entry.Pod, podLogOpts).Stream()
+	if err != nil {
+		return nil, err
+	}
+	defer podLogs.Close()
+
+	logFile, err := ioutil.TempFile("", "ospagent-log-")
+	if err != nil {
+		return nil, err
+	}
+	writer := &exportLogWriter{file: logFile, limit: limit}
+	writer.lines = &lineWriter{writeLine: func(line []byte) {
+		if params.UntilTime != nil && lineAfter(line, params.UntilTime.Time) {
+			return
+		}
+		writer.writeLine(line)
+	}}
+	_, err = io.Copy(writer, podLogs)
+	if err == nil {
+		err = writer.Flush()
+	}
+	if err == nil || err == errExportLogLimit {
+		_, err = logFile.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		removeExportLog(logFile)
+		return nil, err
+	}
+	entry.Bytes = writer.size
+	entry.Truncated = writer.truncated
+	return logFile, nil
+}
+
+func removeExportLog(logFile *os.File) {
+	logFile.Close()
+	if err := os.Remove(logFile.Name()); err != nil {
+		klog.Errorf("remove export log file %s error: %v", logFile.Name(), err)
+	}
+}
+
+// lineAfter checks the timestamp added by kubelet at the beginning of the line.
+func lineAfter(line []byte, until time.Time) bool {
+	i := bytes.IndexByte(line, ' ')
+	if i < 0 {
+		return false
+	}
+	t, err := time.Parse(time.RFC3339Nano, string(line[:i]))
+	if err != nil {
+		return false
+	}
+	return t.After(until)
+}
+
+func writeTarFile(tarWriter *tar.Writer, name string, data []byte, modTime time.Time) error {
+	return writeTarEntry(tarWriter, name, bytes.NewReader(data), int64(len(data)), modTime)
+}
+
+// writeTarEntry streams size bytes of the reader into the tar entry.
+func writeTarEntry(tarWriter *tar.Writer, name string, reader io.Reader, size int64, modTime time.Time) error {
+	header := &tar.Header{
+		Name:    name,
+		Mode:    0644,
+		Size:    size,
+		ModTime: modTime,
+	}
+	if err := tarWriter.WriteHeader(header); err != nil {
+		return err
+	}
+	_, err := io.CopyN(tarWriter, reader, size)
+	return err
+}
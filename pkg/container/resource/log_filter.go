@@ -0,0 +1,243 @@
+package resource
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultLogLinesPerSecond = 1000
+	maxLogLineSize           = 64 * 1024
+)
+
+type LogFilterParams struct {
+	Include string `json:"include"`
+	Exclude string `json:"exclude"`
+	// JsonFields matches structured log lines by field values, nested fields are joined by dot, like "req.method".
+	JsonFields map[string]string `json:"json_fields"`
+	// LinesPerSecond limits the lines sent to the server, 0 means the default limit and negative means no limit.
+	LinesPerSecond int `json:"lines_per_second"`
+}
+
+// logFilter filters the log lines of a session and drops the lines over the rate limit.
+type logFilter struct {
+	include    *regexp.Regexp
+	exclude    *regexp.Regexp
+	jsonFields map[string]string
+	timestamps bool
+	limit      int
+
+	mutex       sync.Mutex
+	windowStart time.Time
+	count       int
+	suppressed  int
+}
+
+func newLogFilter(params *LogFilterParams, timestamps bool) (*logFilter, error) {
+	f := &logFilter{
+		jsonFields: params.JsonFields,
+		timestamps: timestamps,
+		limit:      params.LinesPerSecond,
+	}
+	if f.limit == 0 {
+		f.limit = defaultLogLinesPerSecond
+	}
+	var err error
+	if params.Include != "" {
+		if f.include, err = regexp.Compile(params.Include); err != nil {
+			return nil, fmt.Errorf("invalid include regex: %v", err)
+		}
+	}
+	if params.Exclude != "" {
+		if f.exclude, err = regexp.Compile(params.Exclude); err != nil {
+			return nil, fmt.Errorf("invalid exclude regex: %v", err)
+		}
+	}
+	return f, nil
+}
+
+func (f *logFilter) Match(line []byte) bool {
+	if f.include != nil && !f.include.Match(line) {
+		return false
+	}
+	if f.exclude != nil && f.exclude.Match(line) {
+		return false
+	}
+	if len(f.jsonFields) > 0 {
+		return f.matchJson(line)
+	}
+	return true
+}
+
+func (f *logFilter) matchJson(line []byte) bool {
+	if f.timestamps {
+		// skip the timestamp added by kubelet
+		if i := bytes.IndexByte(line, ' '); i >= 0 {
+			line = line[i+1:]
+		}
+	}
+	start := bytes.IndexByte(line, '{')
+	if start < 0 {
+		return false
+	}
+	fields := make(map[string]interface{})
+	if err := json.Unmarshal(line[start:], &fields); err != nil {
+		return false
+	}
+	for path, expected := range f.jsonFields {
+		value, ok := jsonField(fields, path)
+		if !ok || fmt.Sprint(value) != expected {
+			return false
+		}
+	}
+	return true
+}
+
+func jsonField(fields map[string]interface{}, path string) (interface{}, bool) {
+	if value, ok := fields[path]; ok {
+		return value, true
+	}
+	keys := strings.Split(path, ".")
+	var value interface{} = fields
+	for _, key := range keys {
+		m, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if value, ok = m[key]; !ok {
+			return nil, false
+		}
+	}
+	return value, true
+}
+
+// Allow counts the line in the current one second window, suppressed is the number of lines
+// dropped in the previous window, it is reported once when the window ends.
+func (f *logFilter) Allow(now time.Time) (allowed bool, suppressed int) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if now.Sub(f.windowStart) >= time.Second {
+		suppressed = f.suppressed
+		f.windowStart = now
+		f.count = 0
+		f.suppressed = 0
+	}
+	if f.limit < 0 || f.count < f.limit {
+		f.count += 1
+		return true, suppressed
+	}
+	f.suppressed += 1
+	return false, suppressed
+}
+
+// Flush returns the number of lines dropped in the current window.
+func (f *logFilter) Flush() int {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	suppressed := f.suppressed
+	f.suppressed = 0
+	return suppressed
+}
+
+func suppressedMarker(suppressed int) []byte {
+	return []byte(fmt.Sprintf("... %d lines suppressed ...\n", suppressed))
+}
+
+// LogFilterWriter splits a log stream into lines and writes the lines passing the filter, the lines
+// suppressed by the rate limit are reported by a marker line.
+type LogFilterWriter struct {
+	filter      *logFilter
+	lines       *lineWriter
+	now         func() time.Time
+	writeMarker func(line []byte)
+	writeLine   func(line []byte)
+}
+
+func NewLogFilterWriter(params *LogFilterParams, timestamps bool, now func() time.Time, write func(line []byte)) (*LogFilterWriter, error) {
+	filter, err := newLogFilter(params, timestamps)
+	if err != nil {
+		return nil, err
+	}
+	return filter.newWriter(now, write, write), nil
+}
+
+// newWriter returns a writer of a stream, the streams of a session share the filter and its rate limit.
+func (f *logFilter) newWriter(now func() time.Time, writeMarker, writeLine func(line []byte)) *LogFilterWriter {
+	w := &LogFilterWriter{filter: f, now: now, writeMarker: writeMarker, writeLine: writeLine}
+	w.lines = &lineWriter{writeLine: w.filterLine}
+	return w
+}
+
+func (w *LogFilterWriter) filterLine(line []byte) {
+	if !w.filter.Match(line) {
+		return
+	}
+	allowed, suppressed := w.filter.Allow(w.now())
+	if suppressed > 0 {
+		w.writeMarker(suppressedMarker(suppressed))
+	}
+	if allowed {
+		w.writeLine(line)
+	}
+}
+
+func (w *LogFilterWriter) Write(p []byte) (int, error) {
+	return w.lines.Write(p)
+}
+
+// flushLine writes the buffered partial line at the end of the stream.
+func (w *LogFilterWriter) flushLine() {
+	w.lines.Flush()
+}
+
+// Close writes the partial line and reports the lines suppressed in the current window.
+func (w *LogFilterWriter) Close() error {
+	w.flushLine()
+	if suppressed := w.filter.Flush(); suppressed > 0 {
+		w.writeMarker(suppressedMarker(suppressed))
+	}
+	return nil
+}
+
+// lineWriter splits the written data into lines, a line written by several Write calls
+// is buffered until its end.
+type lineWriter struct {
+	buf       []byte
+	writeLine func(line []byte)
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	size := len(p)
+	for len(p) > 0 {
+		i := bytes.IndexByte(p, '\n')
+		if i < 0 {
+			w.buf = append(w.buf, p...)
+			if len(w.buf) >= maxLogLineSize {
+				w.Flush()
+			}
+			break
+		}
+		if len(w.buf) > 0 {
+			w.buf = append(w.buf, p[:i+1]...)
+			w.Flush()
+		} else {
+			w.writeLine(p[:i+1])
+		}
+		p = p[i+1:]
+	}
+	return size, nil
+}
+
+// Flush writes the buffered partial line.
+func (w *lineWriter) Flush() {
+	if len(w.buf) == 0 {
+		return
+	}
+	w.writeLine(w.buf)
+	w.buf = nil
+}
@@ -0,0 +1,204 @@
+package resource
+
+import (
+	"encoding/json"
+	"github.com/openspacee/ospagent/pkg/kubernetes"
+	"github.com/openspacee/ospagent/pkg/utils"
+	"github.com/openspacee/ospagent/pkg/utils/code"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/klog"
+)
+
+const (
+	NodeMetricsKind = "node"
+	PodMetricsKind  = "pod"
+)
+
+type Metrics struct {
+	*kubernetes.KubeClient
+}
+
+func NewMetrics(kubeClient *kubernetes.KubeClient) *Metrics {
+	return &Metrics{KubeClient: kubeClient}
+}
+
+type MetricsQueryParams struct {
+	Kind          string                `json:"kind"`
+	Name          string                `json:"name"`
+	Namespace     string                `json:"namespace"`
+	LabelSelector *metav1.LabelSelector `json:"label_selector"`
+}
+
+// BuildUsage is the usage of a node, pod or container, the percentages are of the node allocatable.
+type BuildUsage struct {
+	Cpu           string  `json:"cpu"`
+	Memory        string  `json:"memory"`
+	CpuPercent    float64 `json:"cpu_percent,omitempty"`
+	MemoryPercent float64 `json:"memory_percent,omitempty"`
+}
+
+type BuildContainerMetrics struct {
+	Name  string      `json:"name"`
+	Usage *BuildUsage `json:"usage"`
+}
+
+type BuildNodeMetrics struct {
+	Name      string      `json:"name"`
+	Timestamp metav1.Time `json:"timestamp"`
+	Usage     *BuildUsage `json:"usage"`
+}
+
+type BuildPodMetrics struct {
+	Name       string                   `json:"name"`
+	Namespace  string                   `json:"namespace"`
+	Timestamp  metav1.Time              `json:"timestamp"`
+	Usage      *BuildUsage              `json:"usage"`
+	Containers []*BuildContainerMetrics `json:"containers"`
+}
+
+func toBuildUsage(usage v1.ResourceList) *BuildUsage {
+	return &BuildUsage{Cpu: usage.Cpu().String(), Memory: usage.Memory().String()}
+}
+
+func toBuildNodeUsage(usage, allocatable v1.ResourceList) *BuildUsage {
+	u := toBuildUsage(usage)
+	u.CpuPercent = percent(float64(usage.Cpu().MilliValue()), float64(allocatable.Cpu().MilliValue()))
+	u.MemoryPercent = percent(float64(usage.Memory().Value()), float64(allocatable.Memory().Value()))
+	return u
+}
+
+func toBuildPodMetrics(metrics *kubernetes.PodMetrics) *BuildPodMetrics {
+	usage := v1.ResourceList{}
+	pm := &BuildPodMetrics{Name: metrics.Name, Namespace: metrics.Namespace, Timestamp: metrics.Timestamp}
+	for _, c := range metrics.Containers {
+		addResourceList(usage, c.Usage)
+		pm.Containers = append(pm.Containers, &BuildContainerMetrics{Name: c.Name, Usage: toBuildUsage(c.Usage)})
+	}
+	pm.Usage = toBuildUsage(usage)
+	return pm
+}
+
+func (m *Metrics) metricsError(err error) *utils.Response {
+	if kubernetes.IsMetricsUnavailable(err) {
+		return &utils.Response{Code: code.MetricsUnavailable, Msg: "Metrics api is unavailable: " + err.Error()}
+	}
+	return &utils.Response{Code: code.GetError, Msg: err.Error()}
+}
+
+func (m *Metrics) List(requestParams interface{}) *utils.Response {
+	params := &MetricsQueryParams{}
+	json.Unmarshal(requestParams.([]byte), params)
+	selector := ""
+	if params.LabelSelector != nil {
+		s, err := metav1.LabelSelectorAsSelector(params.LabelSelector)
+		if err != nil {
+			return &utils.Response{Code: code.ParamsError, Msg: err.Error()}
+		}
+		selector = s.String()
+	}
+	switch params.Kind {
+	case NodeMetricsKind:
+		metrics, err := m.Metrics.ListNodeMetrics(selector)
+		if err != nil {
+			return m.metricsError(err)
+		}
+		allocatable := m.nodeAllocatable()
+		var res []*BuildNodeMetrics
+		for _, nm := range metrics {
+			res = append(res, &BuildNodeMetrics{
+				Name:      nm.Name,
+				Timestamp: nm.Timestamp,
+				Usage:     toBuildNodeUsage(nm.Usage, allocatable[nm.Name]),
+			})
+		}
+		return &utils.Response{Code: code.Success, Msg: "Success", Data: res}
+	case PodMetricsKind:
+		metrics, err := m.Metrics.ListPodMetrics(params.Namespace, selector)
+		if err != nil {
+			return m.metricsError(err)
+		}
+		var res []*BuildPodMetrics
+		for i := range metrics {
+			res = append(res, toBuildPodMetrics(&metrics[i]))
+		}
+		return &utils.Response{Code: code.Success, Msg: "Success", Data: res}
+	}
+	return &utils.Response{Code: code.ParamsError, Msg: "Unknown metrics kind " + params.Kind}
+}
+
+func (m *Metrics) Get(requestParams interface{}) *utils.Response {
+	params := &MetricsQueryParams{}
+	json.Unmarshal(requestParams.([]byte), params)
+	if params.Name == "" {
+		return &utils.Response{Code: code.ParamsError, Msg: "Name is blank"}
+	}
+	switch params.Kind {
+	case NodeMetricsKind:
+		nm, err := m.Metrics.GetNodeMetrics(params.Name)
+		if err != nil {
+			return m.metricsError(err)
+		}
+		var allocatable v1.ResourceList
+		if node, err := m.NodeInformer().Lister().Get(params.Name); err == nil {
+			allocatable = node.Status.Allocatable
+		}
+		return &utils.Response{Code: code.Success, Msg: "Success", Data: &BuildNodeMetrics{
+			Name:      nm.Name,
+			Timestamp: nm.Timestamp,
+			Usage:     toBuildNodeUsage(nm.Usage, allocatable),
+		}}
+	case PodMetricsKind:
+		if params.Namespace == "" {
+			return &utils.Response{Code: code.ParamsError, Msg: "Namespace is blank"}
+		}
+		pm, err := m.Metrics.GetPodMetrics(params.Namespace, params.Name)
+		if err != nil {
+			return m.metricsError(err)
+		}
+		return &utils.Response{Code: code.Success, Msg: "Success", Data: toBuildPodMetrics(pm)}
+	}
+	return &utils.Response{Code: code.ParamsError, Msg: "Unknown metrics kind " + params.Kind}
+}
+
+func (m *Metrics) nodeAllocatable() map[string]v1.ResourceList {
+	allocatable := make(map[string]v1.ResourceList)
+	nodes, err := m.NodeInformer().Lister().List(labels.Everything())
+	if err != nil {
+		klog.Errorf("list nodes error: %v", err)
+		return allocatable
+	}
+	for _, node := range nodes {
+		allocatable[node.Name] = node.Status.Allocatable
+	}
+	return allocatable
+}
+
+// nodeUsages is the usage of the nodes by name, nil when the metrics api is unavailable.
+func nodeUsages(metricsClient *kubernetes.MetricsClient) map[string]v1.ResourceList {
+	metrics, err := metricsClient.ListNodeMetrics("")
+	if err != nil {
+		klog.Warningf("list node metrics error: %v", err)
+		return nil
+	}
+	usages := make(map[string]v1.ResourceList)
+	for _, nm := range metrics {
+		usages[nm.Name] = nm.Usage
+	}
+	return usages
+}
+
+// podMetrics is the metrics of the pods by namespace/name, nil when the metrics api is unavailable.
+func podMetrics(metricsClient *kubernetes.MetricsClient, namespace string) map[string]*kubernetes.PodMetrics {
+	metrics, err := metricsClient.ListPodMetrics(namespace, "")
+	if err != nil {
+		klog.Warningf("list pod metrics error: %v", err)
+		return nil
+	}
+	podMetrics := make(map[string]*kubernetes.PodMetrics)
+	for i := range metrics {
+		podMetrics[metrics[i].Namespace+"/"+metrics[i].Name] = &metrics[i]
+	}
+	return podMetrics
+}
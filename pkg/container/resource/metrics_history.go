@@ -0,0 +1,302 @@
+package resource
+
+import (
+	"encoding/json"
+	"github.com/openspacee/ospagent/pkg/kubernetes"
+	"github.com/openspacee/ospagent/pkg/utils"
+	"github.com/openspacee/ospagent/pkg/utils/code"
+	"k8s.io/klog"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	ContainerMetricsKind = "container"
+
+	RawResolution    = "raw"
+	RollupResolution = "rollup"
+)
+
+type MetricsHistoryOptions struct {
+	// Interval is the sampling interval, 0 disables the history.
+	Interval        time.Duration
+	Retention       time.Duration
+	RollupInterval  time.Duration
+	RollupRetention time.Duration
+}
+
+type MetricsPoint struct {
+	Timestamp int64 `json:"timestamp"`
+	// Cpu is in millicores and Memory in bytes.
+	Cpu    int64 `json:"cpu"`
+	Memory int64 `json:"memory"`
+}
+
+// pointRing keeps the latest points, the oldest point is overwritten when full.
+type pointRing struct {
+	points []MetricsPoint
+	start  int
+	size   int
+}
+
+func newPointRing(capacity int) *pointRing {
+	if capacity < 1 {
+		capacity = 1
+	}
+	return &pointRing{points: make([]MetricsPoint, capacity)}
+}
+
+func (r *pointRing) add(point MetricsPoint) {
+	if r.size < len(r.points) {
+		r.points[(r.start+r.size)%len(r.points)] = point
+		r.size += 1
+		return
+	}
+	r.points[r.start] = point
+	r.start = (r.start + 1) % len(r.points)
+}
+
+func (r *pointRing) between(start, end int64) []MetricsPoint {
+	points := []MetricsPoint{}
+	for i := 0; i < r.size; i++ {
+		point := r.points[(r.start+i)%len(r.points)]
+		if point.Timestamp >= start && point.Timestamp <= end {
+			points = append(points, point)
+		}
+	}
+	return points
+}
+
+type metricsSeries struct {
+	kind      string
+	namespace string
+	name      string
+	container string
+	raw       *pointRing
+	rollup    *pointRing
+	// the raw points of the rollup bucket not flushed yet
+	bucket      int64
+	bucketCpu   int64
+	bucketMem   int64
+	bucketCount int64
+	lastSample  time.Time
+}
+
+func (s *metricsSeries) add(point MetricsPoint, rollupInterval int64) {
+	bucket := point.Timestamp - point.Timestamp%rollupInterval
+	if s.bucketCount > 0 && bucket != s.bucket {
+		s.rollup.add(s.bucketPoint())
+		s.bucketCpu, s.bucketMem, s.bucketCount = 0, 0, 0
+	}
+	s.bucket = bucket
+	s.bucketCpu += point.Cpu
+	s.bucketMem += point.Memory
+	s.bucketCount += 1
+	s.raw.add(point)
+}
+
+// bucketPoint is the average of the raw points in the current rollup bucket.
+func (s *metricsSeries) bucketPoint() MetricsPoint {
+	return MetricsPoint{
+		Timestamp: s.bucket,
+		Cpu:       s.bucketCpu / s.bucketCount,
+		Memory:    s.bucketMem / s.bucketCount,
+	}
+}
+
+// MetricsHistory samples the node, pod and container usage into in-memory ring buffers, the raw samples
+// are rolled up into averages of the rollup interval, which are kept longer.
+type MetricsHistory struct {
+	*kubernetes.KubeClient
+	options *MetricsHistoryOptions
+	mutex   sync.RWMutex
+	series  map[string]*metricsSeries
+}
+
+func NewMetricsHistory(kubeClient *kubernetes.KubeClient, metricsOptions *MetricsHistoryOptions) *MetricsHistory {
+	options := *metricsOptions
+	// the points are of unix seconds, and a rollup bucket holds one raw point at least
+	if options.Interval > 0 && options.Interval < time.Second {
+		options.Interval = time.Second
+	}
+	if options.RollupInterval < options.Interval {
+		options.RollupInterval = options.Interval
+	}
+	if options.RollupRetention < options.Retention {
+		options.RollupRetention = options.Retention
+	}
+	h := &MetricsHistory{
+		KubeClient: kubeClient,
+		options:    &options,
+		series:     make(map[string]*metricsSeries),
+	}
+	if options.Interval > 0 {
+		go h.run()
+	}
+	return h
+}
+
+func seriesKey(kind, namespace, name, container string) string {
+	return kind + "/" + namespace + "/" + name + "/" + container
+}
+
+func (h *MetricsHistory) run() {
+	ticker := time.NewTicker(h.options.Interval)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		h.Sample(now)
+	}
+}
+
+// Sample records the usage of the nodes, pods and containers at now, it is called every interval.
+func (h *MetricsHistory) Sample(now time.Time) {
+	nodes, err := h.Metrics.ListNodeMetrics("")
+	if err != nil {
+		klog.V(4).Infof("sample node metrics error: %v", err)
+	}
+	pods, err := h.Metrics.ListPodMetrics("", "")
+	if err != nil {
+		klog.V(4).Infof("sample pod metrics error: %v", err)
+	}
+	timestamp := now.Unix()
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	for _, nm := range nodes {
+		h.add(now, NodeMetricsKind, "", nm.Name, "", MetricsPoint{
+			Timestamp: timestamp,
+			Cpu:       nm.Usage.Cpu().MilliValue(),
+			Memory:    nm.Usage.Memory().Value(),
+		})
+	}
+	for _, pm := range pods {
+		podPoint := MetricsPoint{Timestamp: timestamp}
+		for _, c := range pm.Containers {
+			point := MetricsPoint{Timestamp: timestamp, Cpu: c.Usage.Cpu().MilliValue(), Memory: c.Usage.Memory().Value()}
+			h.add(now, ContainerMetricsKind, pm.Namespace, pm.Name, c.Name, point)
+			podPoint.Cpu += point.Cpu
+			podPoint.Memory += point.Memory
+		}
+		h.add(now, PodMetricsKind, pm.Namespace, pm.Name, "", podPoint)
+	}
+	// the series of deleted objects are dropped after their rollups expire
+	for key, s := range h.series {
+		if now.Sub(s.lastSample) > h.options.RollupRetention {
+			delete(h.series, key)
+		}
+	}
+}
+
+func (h *MetricsHistory) add(now time.Time, kind, namespace, name, container string, point MetricsPoint) {
+	key := seriesKey(kind, namespace, name, container)
+	s, ok := h.series[key]
+	if !ok {
+		s = &metricsSeries{
+			kind:      kind,
+			namespace: namespace,
+			name:      name,
+			container: container,
+			raw:       newPointRing(int(h.options.Retention / h.options.Interval)),
+			rollup:    newPointRing(int(h.options.RollupRetention / h.options.RollupInterval)),
+		}
+		h.series[key] = s
+	}
+	s.lastSample = now
+	s.add(point, int64(h.options.RollupInterval/time.Second))
+}
+
+type MetricsRangeParams struct {
+	Kind      string   `json:"kind"`
+	Namespace string   `json:"namespace"`
+	Names     []string `json:"names"`
+	// Start and End are unix seconds, the last retention when blank.
+	Start int64 `json:"start"`
+	End   int64 `json:"end"`
+	// Resolution is raw or rollup, raw when the range is within the raw retention by default.
+	Resolution string `json:"resolution"`
+}
+
+type BuildMetricsSeries struct {
+	Kind       string         `json:"kind"`
+	Namespace  string         `json:"namespace"`
+	Name       string         `json:"name"`
+	Container  string         `json:"container,omitempty"`
+	Resolution string         `json:"resolution"`
+	Step       int64          `json:"step"`
+	Points     []MetricsPoint `json:"points"`
+}
+
+// Range returns the time series of the named objects ordered by name and container, the container series are of
+// the named pods.
+func (h *MetricsHistory) Range(requestParams interface{}) *utils.Response {
+	params := &MetricsRangeParams{}
+	json.Unmarshal(requestParams.([]byte), params)
+	if h.options.Interval <= 0 {
+		return &utils.Response{Code: code.MetricsUnavailable, Msg: "Metrics history is disabled"}
+	}
+	switch params.Kind {
+	case NodeMetricsKind, PodMetricsKind, ContainerMetricsKind:
+	default:
+		return &utils.Response{Code: code.ParamsError, Msg: "Unknown metrics kind " + params.Kind}
+	}
+	if len(params.Names) == 0 {
+		return &utils.Response{Code: code.ParamsError, Msg: "Names are blank"}
+	}
+	if params.Kind != NodeMetricsKind && params.Namespace == "" {
+		return &utils.Response{Code: code.ParamsError, Msg: "Namespace is blank"}
+	}
+	now := time.Now()
+	if params.End == 0 {
+		params.End = now.Unix()
+	}
+	if params.Start == 0 {
+		params.Start = params.End - int64(h.options.Retention/time.Second)
+	}
+	if params.Start > params.End {
+		return &utils.Response{Code: code.ParamsError, Msg: "Start is after end"}
+	}
+	if params.Resolution == "" {
+		params.Resolution = RawResolution
+		if params.Start < now.Add(-h.options.Retention).Unix() {
+			params.Resolution = RollupResolution
+		}
+	}
+	if params.Resolution != RawResolution && params.Resolution != RollupResolution {
+		return &utils.Response{Code: code.ParamsError, Msg: "Unknown resolution " + params.Resolution}
+	}
+
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+	keys := make([]string, 0, len(h.series))
+	for key := range h.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	res := []*BuildMetricsSeries{}
+	for _, key := range keys {
+		s := h.series[key]
+		if s.kind != params.Kind || s.namespace != params.Namespace || !utils.Contains(params.Names, s.name) {
+			continue
+		}
+		series := &BuildMetricsSeries{
+			Kind:       s.kind,
+			Namespace:  s.namespace,
+			Name:       s.name,
+			Container:  s.container,
+			Resolution: params.Resolution,
+		}
+		if params.Resolution == RawResolution {
+			series.Step = int64(h.options.Interval / time.Second)
+			series.Points = s.raw.between(params.Start, params.End)
+		} else {
+			series.Step = int64(h.options.RollupInterval / time.Second)
+			series.Points = s.rollup.between(params.Start, params.End)
+			// the current bucket is partial, it is the average so far
+			if s.bucketCount > 0 && s.bucket >= params.Start && s.bucket <= params.End {
+				series.Points = append(series.Points, s.bucketPoint())
+			}
+		}
+		res = append(res, series)
+	}
+	return &utils.Response{Code: code.Success, Msg: "Success", Data: res}
+}
@@ -6,6 +6,7 @@ import (
 	"github.com/openspacee/ospagent/pkg/kubernetes"
 	"github.com/openspacee/ospagent/pkg/utils"
 	"github.com/openspacee/ospagent/pkg/utils/code"
+	"github.com/openspacee/ospagent/pkg/websocket"
 	"k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/labels"
@@ -20,14 +21,29 @@ import (
 
 type Node struct {
 	*kubernetes.KubeClient
-	watch *WatchResource
+	sendResponse websocket.SendResponse
+	sendStream   websocket.SendStream
+	watch        *WatchResource
+	sessions     *SessionManager
+	shell        *NodeShellOptions
 	*DynamicResource
 }
 
-func NewNode(kubeClient *kubernetes.KubeClient, watch *WatchResource) *Node {
+func NewNode(
+	kubeClient *kubernetes.KubeClient,
+	sendResponse websocket.SendResponse,
+	sendStream websocket.SendStream,
+	watch *WatchResource,
+	sessions *SessionManager,
+	shell *NodeShellOptions) *Node {
+
 	n := &Node{
-		KubeClient: kubeClient,
-		watch:      watch,
+		KubeClient:   kubeClient,
+		sendResponse: sendResponse,
+		sendStream:   sendStream,
+		watch:        watch,
+		sessions:     sessions,
+		shell:        shell,
 		DynamicResource: NewDynamicResource(kubeClient, &schema.GroupVersionResource{
 			Group:    "",
 			Version:  "v1",
@@ -35,6 +51,7 @@ func NewNode(kubeClient *kubernetes.KubeClient, watch *WatchResource) *Node {
 		}),
 	}
 	n.DoWatch()
+	go n.cleanShellPods()
 	return n
 }
 
@@ -66,9 +83,11 @@ type BuildNode struct {
 	AllocatableMem   string            `json:"allocatable_mem"`
 	InternalIP       string            `json:"internal_ip"`
 	Created          metav1.Time       `json:"created"`
+	Allocation       *BuildAllocation  `json:"allocation"`
+	Usage            *BuildUsage       `json:"usage,omitempty"`
 }
 
-func (n *Node) ToBuildNode(node *v1.Node) *BuildNode {
+func (n *Node) ToBuildNode(node *v1.Node, pods []*v1.Pod) *BuildNode {
 	if node == nil {
 		return nil
 	}
@@ -87,6 +106,7 @@ func (n *Node) ToBuildNode(node *v1.Node) *BuildNode {
 		AllocatableMem:   node.Status.Allocatable.Memory().String(),
 		TotalMem:         node.Status.Capacity.Memory().String(),
 		Created:          node.CreationTimestamp,
+		Allocation:       computeAllocation(pods, node.Status.Allocatable),
 	}
 	dur := time.Now().Sub(node.CreationTimestamp.Time)
 	nodeData.Age = fmt.Sprintf("%vd", math.Floor(dur.Hours()/24))
@@ -108,11 +128,14 @@ func (n *Node) ToBuildNode(node *v1.Node) *BuildNode {
 }
 
 type NodeQueryParams struct {
-	Name   string `json:"name"`
-	Output string `json:"output"`
+	Name        string `json:"name"`
+	Output      string `json:"output"`
+	WithMetrics bool   `json:"with_metrics"`
 }
 
 func (n *Node) List(requestParams interface{}) *utils.Response {
+	queryParams := &NodeQueryParams{}
+	json.Unmarshal(requestParams.([]byte), queryParams)
 	nodeList, err := n.KubeClient.InformerRegistry.NodeInformer().Lister().List(labels.Everything())
 	if err != nil {
 		return &utils.Response{
@@ -120,9 +143,22 @@ func (n *Node) List(requestParams interface{}) *utils.Response {
 			Msg:  err.Error(),
 		}
 	}
+	pods, err := n.PodInformer().Lister().List(labels.Everything())
+	if err != nil {
+		return &utils.Response{Code: code.ListError, Msg: err.Error()}
+	}
+	nodePods := podsByNode(pods)
+	var usages map[string]v1.ResourceList
+	if queryParams.WithMetrics {
+		usages = nodeUsages(n.Metrics)
+	}
 	var nodeResource []*BuildNode
 	for _, node := range nodeList {
-		nodeResource = append(nodeResource, n.ToBuildNode(node))
+		bn := n.ToBuildNode(node, nodePods[node.Name])
+		if usage, ok := usages[node.Name]; ok {
+			bn.Usage = toBuildNodeUsage(usage, node.Status.Allocatable)
+		}
+		nodeResource = append(nodeResource, bn)
 	}
 	return &utils.Response{Code: code.Success, Msg: "Success", Data: nodeResource}
 }
@@ -156,5 +192,16 @@ func (n *Node) Get(requestParams interface{}) *utils.Response {
 		return &utils.Response{Code: code.Success, Msg: "Success", Data: string(d)}
 	}
 
-	return &utils.Response{Code: code.Success, Msg: "Success", Data: sc}
+	pods, err := n.PodInformer().Lister().List(labels.Everything())
+	if err != nil {
+		return &utils.Response{Code: code.ListError, Msg: err.Error()}
+	}
+	nodeData := &struct {
+		*v1.Node
+		Allocation *BuildAllocation `json:"allocation"`
+	}{
+		Node:       sc,
+		Allocation: computeAllocation(podsByNode(pods)[sc.Name], sc.Status.Allocatable),
+	}
+	return &utils.Response{Code: code.Success, Msg: "Success", Data: nodeData}
 }
@@ -0,0 +1,226 @@
+package resource
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/openspacee/ospagent/pkg/utils"
+	"github.com/openspacee/ospagent/pkg/utils/code"
+	"k8s.io/api/core/v1"
+	policyv1beta1 "k8s.io/api/policy/v1beta1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	mirrorPodAnnotation = "kubernetes.io/config.mirror"
+
+	defaultDrainTimeout = 300
+	evictionRetryPeriod = 5 * time.Second
+
+	DrainEvicting = "evicting"
+	DrainBlocked  = "blocked"
+	DrainEvicted  = "evicted"
+	DrainFailed   = "failed"
+)
+
+type NodeParams struct {
+	Name string `json:"name"`
+}
+
+func (n *Node) setUnschedulable(name string, unschedulable bool) error {
+	patch := fmt.Sprintf(`{"spec":{"unschedulable":%t}}`, unschedulable)
+	_, err := n.ClientSet.CoreV1().Nodes().Patch(name, types.StrategicMergePatchType, []byte(patch))
+	return err
+}
+
+func (n *Node) Cordon(requestParams interface{}) *utils.Response {
+	params := &NodeParams{}
+	json.Unmarshal(requestParams.([]byte), params)
+	if params.Name == "" {
+		return &utils.Response{Code: code.ParamsError, Msg: "Name is blank"}
+	}
+	if err := n.setUnschedulable(params.Name, true); err != nil {
+		return &utils.Response{Code: code.UpdateError, Msg: err.Error()}
+	}
+	return &utils.Response{Code: code.Success, Msg: "Success"}
+}
+
+func (n *Node) Uncordon(requestParams interface{}) *utils.Response {
+	params := &NodeParams{}
+	json.Unmarshal(requestParams.([]byte), params)
+	if params.Name == "" {
+		return &utils.Response{Code: code.ParamsError, Msg: "Name is blank"}
+	}
+	if err := n.setUnschedulable(params.Name, false); err != nil {
+		return &utils.Response{Code: code.UpdateError, Msg: err.Error()}
+	}
+	return &utils.Response{Code: code.Success, Msg: "Success"}
+}
+
+type DrainParams struct {
+	Name string `json:"name"`
+	// Force evicts the pods not managed by a controller, they are not recreated.
+	Force              bool   `json:"force"`
+	DeleteEmptyDirData bool   `json:"delete_emptydir_data"`
+	GracePeriodSeconds *int64 `json:"grace_period_seconds"`
+	Timeout            int    `json:"timeout"`
+}
+
+type DrainProgress struct {
+	Namespace string `json:"namespace"`
+	Pod       string `json:"pod"`
+	Status    string `json:"status"`
+	Msg       string `json:"msg"`
+}
+
+type DrainResult struct {
+	Evicted []string `json:"evicted"`
+	Skipped []string `json:"skipped"`
+	Failed  []string `json:"failed"`
+}
+
+// Drain cordons the node and evicts its pods through the eviction api, so the pod disruption budgets
+// are respected. DaemonSet pods and mirror pods are skipped, the progress is sent with the request id.
+func (n *Node) Drain(requestId string, requestParams interface{}) *utils.Response {
+	params := &DrainParams{}
+	json.Unmarshal(requestParams.([]byte), params)
+	klog.Info(params)
+	if params.Name == "" {
+		return &utils.Response{Code: code.ParamsError, Msg: "Name is blank"}
+	}
+	if params.Timeout <= 0 {
+		params.Timeout = defaultDrainTimeout
+	}
+	if err := n.setUnschedulable(params.Name, true); err != nil {
+		return &utils.Response{Code: code.UpdateError, Msg: err.Error()}
+	}
+	podList, err := n.ClientSet.CoreV1().Pods("").List(metav1.ListOptions{
+		FieldSelector: fields.OneTermEqualSelector("spec.nodeName", params.Name).String(),
+	})
+	if err != nil {
+		return &utils.Response{Code: code.ListError, Msg: err.Error()}
+	}
+	result := &DrainResult{}
+	var pods []v1.Pod
+	var errs []string
+	for _, pod := range podList.Items {
+		skip, err := drainFilter(&pod, params)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s/%s: %v", pod.Namespace, pod.Name, err))
+		} else if skip {
+			result.Skipped = append(result.Skipped, pod.Namespace+"/"+pod.Name)
+		} else {
+			pods = append(pods, pod)
+		}
+	}
+	if len(errs) > 0 {
+		// nothing is evicted like kubectl drain, the node is left cordoned
+		return &utils.Response{Code: code.ParamsError, Msg: "Cannot drain node: " + strings.Join(errs, "; ")}
+	}
+
+	deadline := time.Now().Add(time.Duration(params.Timeout) * time.Second)
+	var mutex sync.Mutex
+	var wg sync.WaitGroup
+	for i := range pods {
+		wg.Add(1)
+		go func(pod *v1.Pod) {
+			defer wg.Done()
+			name := pod.Namespace + "/" + pod.Name
+			err := n.evictPod(requestId, pod, params.GracePeriodSeconds, deadline)
+			mutex.Lock()
+			defer mutex.Unlock()
+			if err != nil {
+				n.sendDrainProgress(requestId, pod, DrainFailed, err.Error())
+				result.Failed = append(result.Failed, name)
+			} else {
+				n.sendDrainProgress(requestId, pod, DrainEvicted, "")
+				result.Evicted = append(result.Evicted, name)
+			}
+		}(&pods[i])
+	}
+	wg.Wait()
+	msg := fmt.Sprintf("%d evicted, %d skipped, %d failed", len(result.Evicted), len(result.Skipped), len(result.Failed))
+	if len(result.Failed) > 0 {
+		return &utils.Response{Code: code.DrainError, Msg: msg, Data: result}
+	}
+	return &utils.Response{Code: code.Success, Msg: msg, Data: result}
+}
+
+// drainFilter skips the pods not to evict, and returns error for the pods blocking the drain.
+func drainFilter(pod *v1.Pod, params *DrainParams) (bool, error) {
+	if _, ok := pod.Annotations[mirrorPodAnnotation]; ok {
+		return true, nil
+	}
+	controller := metav1.GetControllerOf(pod)
+	if controller != nil && controller.Kind == "DaemonSet" {
+		return true, nil
+	}
+	if pod.Status.Phase == v1.PodSucceeded || pod.Status.Phase == v1.PodFailed {
+		return false, nil
+	}
+	if controller == nil && !params.Force {
+		return false, fmt.Errorf("pod not managed by a controller, use force to evict")
+	}
+	if !params.DeleteEmptyDirData {
+		for _, volume := range pod.Spec.Volumes {
+			if volume.EmptyDir != nil {
+				return false, fmt.Errorf("pod with emptyDir volume %s, use delete_emptydir_data to evict", volume.Name)
+			}
+		}
+	}
+	return false, nil
+}
+
+func (n *Node) sendDrainProgress(requestId string, pod *v1.Pod, status, msg string) {
+	n.sendResponse(&DrainProgress{
+		Namespace: pod.Namespace,
+		Pod:       pod.Name,
+		Status:    status,
+		Msg:       msg,
+	}, requestId, utils.DrainProgressType)
+}
+
+// evictPod evicts the pod until it is allowed by the disruption budgets, then waits for the pod deleted.
+func (n *Node) evictPod(requestId string, pod *v1.Pod, gracePeriodSeconds *int64, deadline time.Time) error {
+	eviction := &policyv1beta1.Eviction{
+		ObjectMeta:    metav1.ObjectMeta{Name: pod.Name, Namespace: pod.Namespace},
+		DeleteOptions: &metav1.DeleteOptions{GracePeriodSeconds: gracePeriodSeconds},
+	}
+	n.sendDrainProgress(requestId, pod, DrainEvicting, "")
+	for {
+		err := n.ClientSet.CoreV1().Pods(pod.Namespace).Evict(eviction)
+		if err == nil || errors.IsNotFound(err) {
+			break
+		}
+		if !errors.IsTooManyRequests(err) {
+			return err
+		}
+		n.sendDrainProgress(requestId, pod, DrainBlocked, err.Error())
+		if time.Now().Add(evictionRetryPeriod).After(deadline) {
+			return fmt.Errorf("timeout evicting pod blocked by disruption budget: %v", err)
+		}
+		time.Sleep(evictionRetryPeriod)
+	}
+	timeout := time.Until(deadline)
+	if timeout <= 0 {
+		timeout = time.Second
+	}
+	err := wait.PollImmediate(time.Second, timeout, func() (bool, error) {
+		p, err := n.ClientSet.CoreV1().Pods(pod.Namespace).Get(pod.Name, metav1.GetOptions{})
+		if errors.IsNotFound(err) || (err == nil && p.UID != pod.UID) {
+			return true, nil
+		}
+		return false, nil
+	})
+	if err == wait.ErrWaitTimeout {
+		return fmt.Errorf("timeout waiting for pod deleted")
+	}
+	return err
+}
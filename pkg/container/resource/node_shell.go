@@ -0,0 +1,141 @@
+package resource
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/openspacee/ospagent/pkg/utils"
+	"github.com/openspacee/ospagent/pkg/utils/code"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog"
+	"strings"
+)
+
+const (
+	NodeShellLabel = "ospagent.openspacee.io/node-shell"
+
+	nodeShellContainer = "shell"
+)
+
+type NodeShellOptions struct {
+	Namespace string
+	Image     string
+}
+
+type NodeShellParams struct {
+	SessionId string   `json:"session_id"`
+	Name      string   `json:"name"`
+	Image     string   `json:"image"`
+	Command   []string `json:"command"`
+}
+
+type BuildNodeShell struct {
+	Namespace string `json:"namespace"`
+	Pod       string `json:"pod"`
+}
+
+// Shell schedules a privileged helper pod on the node, which enters the namespaces of the node init process,
+// and attaches a tty session to it. The helper pod is deleted when the session ends.
+func (n *Node) Shell(requestParams interface{}) *utils.Response {
+	params := &NodeShellParams{}
+	json.Unmarshal(requestParams.([]byte), params)
+	klog.Info(params)
+	if params.SessionId == "" {
+		return &utils.Response{Code: code.ParamsError, Msg: "Session id is blank"}
+	}
+	if err := n.sessions.CheckId(params.SessionId); err != nil {
+		return &utils.Response{Code: code.ParamsError, Msg: err.Error()}
+	}
+	if params.Name == "" {
+		return &utils.Response{Code: code.ParamsError, Msg: "Node name is blank"}
+	}
+	if _, err := n.NodeInformer().Lister().Get(params.Name); err != nil {
+		return &utils.Response{Code: code.GetError, Msg: err.Error()}
+	}
+	if params.Image == "" {
+		params.Image = n.shell.Image
+	}
+	if len(params.Command) == 0 {
+		params.Command = []string{"sh", "-l"}
+	}
+	pod, err := n.ClientSet.CoreV1().Pods(n.shell.Namespace).Create(nodeShellPod(n.shell.Namespace, params))
+	if err != nil {
+		return &utils.Response{Code: code.CreateError, Msg: err.Error()}
+	}
+	go n.attachShell(params.SessionId, pod.Name)
+	return &utils.Response{Code: code.Success, Msg: "Success", Data: &BuildNodeShell{Namespace: pod.Namespace, Pod: pod.Name}}
+}
+
+func nodeShellPod(namespace string, params *NodeShellParams) *v1.Pod {
+	name := params.Name
+	if len(name) > 45 {
+		name = strings.TrimRight(name[:45], "-.")
+	}
+	privileged := true
+	gracePeriod := int64(0)
+	command := append([]string{"nsenter", "--target", "1", "--mount", "--uts", "--ipc", "--net", "--pid", "--"}, params.Command...)
+	return &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      fmt.Sprintf("node-shell-%s-%s", name, utils.RandString(5)),
+			Namespace: namespace,
+			Labels:    map[string]string{NodeShellLabel: name},
+		},
+		Spec: v1.PodSpec{
+			NodeName:                      params.Name,
+			HostPID:                       true,
+			HostNetwork:                   true,
+			HostIPC:                       true,
+			RestartPolicy:                 v1.RestartPolicyNever,
+			TerminationGracePeriodSeconds: &gracePeriod,
+			// tolerate every taint, the pod is bound to the node already
+			Tolerations: []v1.Toleration{{Operator: v1.TolerationOpExists}},
+			Containers: []v1.Container{{
+				Name:            nodeShellContainer,
+				Image:           params.Image,
+				ImagePullPolicy: v1.PullIfNotPresent,
+				Command:         command,
+				Stdin:           true,
+				TTY:             true,
+				SecurityContext: &v1.SecurityContext{Privileged: &privileged},
+			}},
+		},
+	}
+}
+
+func (n *Node) attachShell(sessionId, pod string) {
+	namespace := n.shell.Namespace
+	defer n.deleteShellPod(pod)
+	if err := waitContainerRunning(n.ClientSet, namespace, pod, nodeShellContainer, false, nil); err != nil {
+		klog.Errorf("wait node shell pod %s/%s error: %v", namespace, pod, err)
+		sendExecError(n.sendResponse, n.sendStream, utils.ExecType, sessionId, err)
+		return
+	}
+	runExecSession(n.Config, n.sessions, n.sendResponse, n.sendStream, &execSessionOptions{
+		SessionId: sessionId,
+		Kind:      ExecSession,
+		Namespace: namespace,
+		Pod:       pod,
+		Url:       attachUrl(n.ClientSet, namespace, pod, nodeShellContainer),
+		Tty:       true,
+		Stdin:     true,
+	})
+}
+
+func (n *Node) deleteShellPod(pod string) {
+	err := n.ClientSet.CoreV1().Pods(n.shell.Namespace).Delete(pod, &metav1.DeleteOptions{})
+	if err != nil {
+		klog.Errorf("delete node shell pod %s/%s error: %v", n.shell.Namespace, pod, err)
+	} else {
+		klog.Infof("delete node shell pod %s/%s", n.shell.Namespace, pod)
+	}
+}
+
+// cleanShellPods deletes the helper pods left by the agent before restarting, their sessions are gone.
+func (n *Node) cleanShellPods() {
+	err := n.ClientSet.CoreV1().Pods(n.shell.Namespace).DeleteCollection(
+		&metav1.DeleteOptions{},
+		metav1.ListOptions{LabelSelector: NodeShellLabel})
+	if err != nil {
+		klog.Errorf("clean node shell pods in namespace %s error: %v", n.shell.Namespace, err)
+	}
+}
@@ -0,0 +1,274 @@
+package resource
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/openspacee/ospagent/pkg/utils"
+	"github.com/openspacee/ospagent/pkg/utils/code"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/retry"
+	"k8s.io/klog"
+)
+
+// NodeSelectParams selects the nodes by names, label selector or both.
+type NodeSelectParams struct {
+	Names    []string              `json:"names"`
+	Selector *metav1.LabelSelector `json:"selector"`
+}
+
+type NodeTaintParams struct {
+	NodeSelectParams
+	Add []v1.Taint `json:"add"`
+	// Remove removes the taints by key and effect, all effects of the key when effect is blank.
+	Remove []v1.Taint `json:"remove"`
+}
+
+type NodeLabelParams struct {
+	NodeSelectParams
+	Set    map[string]string `json:"set"`
+	Remove []string          `json:"remove"`
+}
+
+type TaintPreviewParams struct {
+	NodeSelectParams
+	Taint v1.Taint `json:"taint"`
+}
+
+type NodePatchResult struct {
+	Node    string `json:"node"`
+	Success bool   `json:"success"`
+	Msg     string `json:"msg"`
+}
+
+type TaintPreviewPod struct {
+	Node      string `json:"node"`
+	Namespace string `json:"namespace"`
+	Pod       string `json:"pod"`
+	// TolerationSeconds is how long the pod is bound to the node after tainted, nil is evicted at once.
+	TolerationSeconds *int64 `json:"toleration_seconds"`
+}
+
+func (n *Node) selectNodes(params *NodeSelectParams) ([]*v1.Node, error) {
+	if len(params.Names) == 0 && params.Selector == nil {
+		return nil, fmt.Errorf("node names and selector are both blank")
+	}
+	selector := labels.Everything()
+	if params.Selector != nil {
+		var err error
+		if selector, err = metav1.LabelSelectorAsSelector(params.Selector); err != nil {
+			return nil, err
+		}
+	}
+	nodeList, err := n.NodeInformer().Lister().List(selector)
+	if err != nil {
+		return nil, err
+	}
+	var nodes []*v1.Node
+	for _, node := range nodeList {
+		if len(params.Names) > 0 && !utils.Contains(params.Names, node.Name) {
+			continue
+		}
+		nodes = append(nodes, node)
+	}
+	if len(nodes) == 0 {
+		return nil, fmt.Errorf("no node matched")
+	}
+	return nodes, nil
+}
+
+func validTaint(taint *v1.Taint, requireEffect bool) error {
+	if taint.Key == "" {
+		return fmt.Errorf("taint key is blank")
+	}
+	switch taint.Effect {
+	case v1.TaintEffectNoSchedule, v1.TaintEffectPreferNoSchedule, v1.TaintEffectNoExecute:
+	case "":
+		if requireEffect {
+			return fmt.Errorf("taint %s effect is blank", taint.Key)
+		}
+	default:
+		return fmt.Errorf("taint %s effect %s is invalid", taint.Key, taint.Effect)
+	}
+	return nil
+}
+
+// updateTaints removes and adds the taints, an added taint replaces the taint with the same key and effect.
+func updateTaints(taints []v1.Taint, add, remove []v1.Taint) []v1.Taint {
+	result := []v1.Taint{}
+	for _, taint := range taints {
+		removed := false
+		for _, r := range remove {
+			if r.Key == taint.Key && (r.Effect == "" || r.Effect == taint.Effect) {
+				removed = true
+				break
+			}
+		}
+		for i := range add {
+			if add[i].MatchTaint(&taint) {
+				removed = true
+				break
+			}
+		}
+		if !removed {
+			result = append(result, taint)
+		}
+	}
+	for _, taint := range add {
+		if taint.Effect == v1.TaintEffectNoExecute && taint.TimeAdded == nil {
+			now := metav1.Now()
+			taint.TimeAdded = &now
+		}
+		result = append(result, taint)
+	}
+	return result
+}
+
+// patchNodes patches the nodes one by one, the patch is rebuilt from the latest node on conflict.
+func (n *Node) patchNodes(nodes []*v1.Node, buildPatch func(node *v1.Node) (interface{}, error)) *utils.Response {
+	var results []*NodePatchResult
+	failed := 0
+	for _, node := range nodes {
+		name := node.Name
+		err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+			latest, err := n.ClientSet.CoreV1().Nodes().Get(name, metav1.GetOptions{})
+			if err != nil {
+				return err
+			}
+			patch, err := buildPatch(latest)
+			if err != nil {
+				return err
+			}
+			data, err := json.Marshal(patch)
+			if err != nil {
+				return err
+			}
+			_, err = n.ClientSet.CoreV1().Nodes().Patch(name, types.StrategicMergePatchType, data)
+			return err
+		})
+		result := &NodePatchResult{Node: name, Success: err == nil, Msg: "Success"}
+		if err != nil {
+			klog.Errorf("patch node %s error: %v", name, err)
+			result.Msg = err.Error()
+			failed += 1
+		}
+		results = append(results, result)
+	}
+	msg := fmt.Sprintf("%d nodes patched, %d failed", len(results)-failed, failed)
+	if failed > 0 {
+		return &utils.Response{Code: code.UpdateError, Msg: msg, Data: results}
+	}
+	return &utils.Response{Code: code.Success, Msg: msg, Data: results}
+}
+
+func (n *Node) Taint(requestParams interface{}) *utils.Response {
+	params := &NodeTaintParams{}
+	json.Unmarshal(requestParams.([]byte), params)
+	klog.Info(params)
+	if len(params.Add) == 0 && len(params.Remove) == 0 {
+		return &utils.Response{Code: code.ParamsError, Msg: "No taint to add or remove"}
+	}
+	for i := range params.Add {
+		if err := validTaint(&params.Add[i], true); err != nil {
+			return &utils.Response{Code: code.ParamsError, Msg: err.Error()}
+		}
+	}
+	for i := range params.Remove {
+		if err := validTaint(&params.Remove[i], false); err != nil {
+			return &utils.Response{Code: code.ParamsError, Msg: err.Error()}
+		}
+	}
+	nodes, err := n.selectNodes(&params.NodeSelectParams)
+	if err != nil {
+		return &utils.Response{Code: code.ParamsError, Msg: err.Error()}
+	}
+	return n.patchNodes(nodes, func(node *v1.Node) (interface{}, error) {
+		// taints are replaced as a whole list, the resource version makes the patch fail on conflict
+		return map[string]interface{}{
+			"metadata": map[string]interface{}{"resourceVersion": node.ResourceVersion},
+			"spec":     map[string]interface{}{"taints": updateTaints(node.Spec.Taints, params.Add, params.Remove)},
+		}, nil
+	})
+}
+
+func (n *Node) Label(requestParams interface{}) *utils.Response {
+	params := &NodeLabelParams{}
+	json.Unmarshal(requestParams.([]byte), params)
+	klog.Info(params)
+	if len(params.Set) == 0 && len(params.Remove) == 0 {
+		return &utils.Response{Code: code.ParamsError, Msg: "No label to set or remove"}
+	}
+	nodes, err := n.selectNodes(&params.NodeSelectParams)
+	if err != nil {
+		return &utils.Response{Code: code.ParamsError, Msg: err.Error()}
+	}
+	nodeLabels := make(map[string]interface{})
+	for _, key := range params.Remove {
+		nodeLabels[key] = nil
+	}
+	for key, value := range params.Set {
+		nodeLabels[key] = value
+	}
+	return n.patchNodes(nodes, func(node *v1.Node) (interface{}, error) {
+		return map[string]interface{}{
+			"metadata": map[string]interface{}{"labels": nodeLabels},
+		}, nil
+	})
+}
+
+// TaintPreview lists the pods evicted by a new NoExecute taint from the pod cache, the pods tolerating the
+// taint for a while are listed with their toleration seconds.
+func (n *Node) TaintPreview(requestParams interface{}) *utils.Response {
+	params := &TaintPreviewParams{}
+	json.Unmarshal(requestParams.([]byte), params)
+	if err := validTaint(&params.Taint, true); err != nil {
+		return &utils.Response{Code: code.ParamsError, Msg: err.Error()}
+	}
+	nodes, err := n.selectNodes(&params.NodeSelectParams)
+	if err != nil {
+		return &utils.Response{Code: code.ParamsError, Msg: err.Error()}
+	}
+	evicted := []*TaintPreviewPod{}
+	if params.Taint.Effect != v1.TaintEffectNoExecute {
+		return &utils.Response{Code: code.Success, Msg: "Only NoExecute taint evicts pods", Data: evicted}
+	}
+	pods, err := n.PodInformer().Lister().List(labels.Everything())
+	if err != nil {
+		return &utils.Response{Code: code.ListError, Msg: err.Error()}
+	}
+	nodeNames := make(map[string]bool)
+	for _, node := range nodes {
+		nodeNames[node.Name] = true
+	}
+	for _, pod := range pods {
+		if !nodeNames[pod.Spec.NodeName] || pod.Status.Phase == v1.PodSucceeded || pod.Status.Phase == v1.PodFailed {
+			continue
+		}
+		tolerated := false
+		var tolerationSeconds *int64
+		for i := range pod.Spec.Tolerations {
+			toleration := &pod.Spec.Tolerations[i]
+			if !toleration.ToleratesTaint(&params.Taint) {
+				continue
+			}
+			tolerated = true
+			// the pod is evicted after the shortest toleration seconds, like the taint manager
+			if toleration.TolerationSeconds != nil &&
+				(tolerationSeconds == nil || *toleration.TolerationSeconds < *tolerationSeconds) {
+				tolerationSeconds = toleration.TolerationSeconds
+			}
+		}
+		if tolerated && tolerationSeconds == nil {
+			continue
+		}
+		evicted = append(evicted, &TaintPreviewPod{
+			Node:              pod.Spec.NodeName,
+			Namespace:         pod.Namespace,
+			Pod:               pod.Name,
+			TolerationSeconds: tolerationSeconds,
+		})
+	}
+	return &utils.Response{Code: code.Success, Msg: "Success", Data: evicted}
+}
@@ -0,0 +1,92 @@
+package resource
+
+import (
+	"github.com/openspacee/ospagent/pkg/kubernetes"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// maxOwnerDepth stops the owner chain on a reference loop.
+const maxOwnerDepth = 5
+
+type BuildOwner struct {
+	Kind string `json:"kind"`
+	Name string `json:"name"`
+}
+
+// cachedObject gets the object from the informer cache, it is nil without error for the kinds not cached.
+func cachedObject(kubeClient *kubernetes.KubeClient, kind, namespace, name string) (metav1.Object, error) {
+	var obj metav1.Object
+	var err error
+	switch kind {
+	case "Pod":
+		obj, err = kubeClient.PodInformer().Lister().Pods(namespace).Get(name)
+	case "ReplicaSet":
+		obj, err = kubeClient.ReplicaSetInformer().Lister().ReplicaSets(namespace).Get(name)
+	case "Deployment":
+		obj, err = kubeClient.DeploymentInformer().Lister().Deployments(namespace).Get(name)
+	case "StatefulSet":
+		obj, err = kubeClient.StatefulSetInformer().Lister().StatefulSets(namespace).Get(name)
+	case "DaemonSet":
+		obj, err = kubeClient.DaemonSetInformer().Lister().DaemonSets(namespace).Get(name)
+	case "ControllerRevision":
+		obj, err = kubeClient.ControllerRevisionInformer().Lister().ControllerRevisions(namespace).Get(name)
+	case "Job":
+		obj, err = kubeClient.JobInformer().Lister().Jobs(namespace).Get(name)
+	case "CronJob":
+		obj, err = kubeClient.CronJobInformer().Lister().CronJobs(namespace).Get(name)
+	case "Service":
+		obj, err = kubeClient.ServiceInformer().Lister().Services(namespace).Get(name)
+	case "Endpoints":
+		obj, err = kubeClient.EndpointsInformer().Lister().Endpoints(namespace).Get(name)
+	case "Ingress":
+		obj, err = kubeClient.IngressInformer().Lister().Ingresses(namespace).Get(name)
+	case "ConfigMap":
+		obj, err = kubeClient.ConfigMapInformer().Lister().ConfigMaps(namespace).Get(name)
+	case "Secret":
+		obj, err = kubeClient.SecretInformer().Lister().Secrets(namespace).Get(name)
+	case "PersistentVolumeClaim":
+		obj, err = kubeClient.PersistentVolumeClaimInformer().Lister().PersistentVolumeClaims(namespace).Get(name)
+	case "PersistentVolume":
+		obj, err = kubeClient.PersistentVolumeInformer().Lister().Get(name)
+	case "StorageClass":
+		obj, err = kubeClient.StorageClassInformer().Lister().Get(name)
+	case "HorizontalPodAutoscaler":
+		obj, err = kubeClient.HorizontalPodAutoscalerInformer().Lister().HorizontalPodAutoscalers(namespace).Get(name)
+	case "ServiceAccount":
+		obj, err = kubeClient.ServiceAccountInformer().Lister().ServiceAccounts(namespace).Get(name)
+	case "RoleBinding":
+		obj, err = kubeClient.RoleBindingInformer().Lister().RoleBindings(namespace).Get(name)
+	case "Role":
+		obj, err = kubeClient.RoleInformer().Lister().Roles(namespace).Get(name)
+	case "ClusterRole":
+		obj, err = kubeClient.ClusterRoleInformer().Lister().Get(name)
+	case "Node":
+		obj, err = kubeClient.NodeInformer().Lister().Get(name)
+	default:
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return obj, nil
+}
+
+// ownerChain follows the controller references of the object through the informer cache, from the direct
+// controller to the top-level workload. The chain stops at an owner not in the cache.
+func ownerChain(kubeClient *kubernetes.KubeClient, obj metav1.Object) []*BuildOwner {
+	var chain []*BuildOwner
+	namespace := obj.GetNamespace()
+	for i := 0; i < maxOwnerDepth && obj != nil; i++ {
+		ref := metav1.GetControllerOf(obj)
+		if ref == nil {
+			break
+		}
+		chain = append(chain, &BuildOwner{Kind: ref.Kind, Name: ref.Name})
+		owner, err := cachedObject(kubeClient, ref.Kind, namespace, ref.Name)
+		if err != nil || owner == nil || owner.GetUID() != ref.UID {
+			break
+		}
+		obj = owner
+	}
+	return chain
+}
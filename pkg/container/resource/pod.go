@@ -8,7 +8,6 @@ import (
 	"github.com/openspacee/ospagent/pkg/utils"
 	"github.com/openspacee/ospagent/pkg/utils/code"
 	"github.com/openspacee/ospagent/pkg/websocket"
-	"io"
 	"k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/labels"
@@ -17,25 +16,30 @@ import (
 	"k8s.io/apimachinery/pkg/runtime/serializer"
 	"k8s.io/client-go/kubernetes/scheme"
 	"k8s.io/client-go/tools/cache"
-	"k8s.io/client-go/tools/remotecommand"
 	"k8s.io/klog"
 	"strings"
 )
 
 type Pod struct {
 	websocket.SendResponse
-	watch        *WatchResource
-	execSessions map[string]*streamHandler
-	logSessions  map[string]*logHandler
+	sendStream websocket.SendStream
+	watch      *WatchResource
+	sessions   *SessionManager
 	*DynamicResource
 }
 
-func NewPod(kubeClient *kubernetes.KubeClient, sendResponse websocket.SendResponse, watch *WatchResource) *Pod {
+func NewPod(
+	kubeClient *kubernetes.KubeClient,
+	sendResponse websocket.SendResponse,
+	sendStream websocket.SendStream,
+	watch *WatchResource,
+	sessions *SessionManager) *Pod {
+
 	pod := &Pod{
 		SendResponse: sendResponse,
+		sendStream:   sendStream,
 		watch:        watch,
-		execSessions: make(map[string]*streamHandler),
-		logSessions:  make(map[string]*logHandler),
+		sessions:     sessions,
 		DynamicResource: NewDynamicResource(kubeClient, &schema.GroupVersionResource{
 			Group:    "",
 			Version:  "v1",
@@ -61,6 +65,7 @@ type PodQueryParams struct {
 	Output        string                `json:"output"`
 	LabelSelector *metav1.LabelSelector `json:"label_selector"`
 	Names         []string              `json:"names"`
+	WithMetrics   bool                  `json:"with_metrics"`
 }
 
 type DeletePodParams struct {
@@ -69,10 +74,11 @@ type DeletePodParams struct {
 }
 
 type BuildContainer struct {
-	Name     string `json:"name"`
-	Status   string `json:"status"`
-	Restarts int32  `json:"restarts"`
-	Ready    bool   `json:"ready"`
+	Name     string      `json:"name"`
+	Status   string      `json:"status"`
+	Restarts int32       `json:"restarts"`
+	Ready    bool        `json:"ready"`
+	Usage    *BuildUsage `json:"usage,omitempty"`
 }
 
 type BuildPod struct {
@@ -91,6 +97,9 @@ type BuildPod struct {
 	ResourceVersion string            `json:"resource_version"`
 	ContainerNum    int               `json:"containerNum"`
 	Restarts        int32             `json:"restarts"`
+	Usage           *BuildUsage       `json:"usage,omitempty"`
+	// Owners is the controller chain of the pod, the last one is the top-level workload.
+	Owners []*BuildOwner `json:"owners"`
 }
 
 func (p *Pod) ToBuildContainer(statuses []v1.ContainerStatus, container *v1.Container) *BuildContainer {
@@ -161,6 +170,7 @@ func (p *Pod) ToBuildPod(pod *v1.Pod) *BuildPod {
 		ResourceVersion: pod.ResourceVersion,
 		ContainerNum:    cn,
 		Restarts:        restarts,
+		Owners:          ownerChain(p.KubeClient, pod),
 	}
 }
 
@@ -184,6 +194,10 @@ func (p *Pod) List(requestParams interface{}) *utils.Response {
 			Msg:  err.Error(),
 		}
 	}
+	var metrics map[string]*kubernetes.PodMetrics
+	if queryParams.WithMetrics {
+		metrics = podMetrics(p.Metrics, queryParams.Namespace)
+	}
 	var podRes []*BuildPod
 	for _, pod := range podList {
 		if queryParams.Name != "" && !strings.Contains(pod.Name, queryParams.Name) {
@@ -192,11 +206,27 @@ func (p *Pod) List(requestParams interface{}) *utils.Response {
 		if len(queryParams.Names) > 0 && !utils.Contains(queryParams.Names, pod.Name) {
 			continue
 		}
-		podRes = append(podRes, p.ToBuildPod(pod))
+		bp := p.ToBuildPod(pod)
+		if pm, ok := metrics[pod.Namespace+"/"+pod.Name]; ok {
+			setPodUsage(bp, pm)
+		}
+		podRes = append(podRes, bp)
 	}
 	return &utils.Response{Code: code.Success, Msg: "Success", Data: podRes}
 }
 
+func setPodUsage(bp *BuildPod, metrics *kubernetes.PodMetrics) {
+	pm := toBuildPodMetrics(metrics)
+	bp.Usage = pm.Usage
+	for _, cm := range pm.Containers {
+		for _, bc := range bp.Containers {
+			if bc.Name == cm.Name {
+				bc.Usage = cm.Usage
+			}
+		}
+	}
+}
+
 func (p *Pod) Get(requestParams interface{}) *utils.Response {
 	queryParams := &PodQueryParams{}
 	json.Unmarshal(requestParams.([]byte), queryParams)
@@ -233,74 +263,67 @@ func (p *Pod) Get(requestParams interface{}) *utils.Response {
 }
 
 type PodExecParams struct {
-	Name      string `json:"name"`
-	Namespace string `json:"namespace"`
-	Container string `json:"container"`
-	SessionId string `json:"session_id"`
-	Rows      string `json:"rows"`
-	Cols      string `json:"cols"`
+	Name      string   `json:"name"`
+	Namespace string   `json:"namespace"`
+	Container string   `json:"container"`
+	SessionId string   `json:"session_id"`
+	Rows      string   `json:"rows"`
+	Cols      string   `json:"cols"`
+	Command   []string `json:"command"`
+	Tty       *bool    `json:"tty"`
+	Stdin     bool     `json:"stdin"`
 }
 
 func (p *Pod) Exec(requestParams interface{}) *utils.Response {
 	params := &PodExecParams{}
 	json.Unmarshal(requestParams.([]byte), params)
 	klog.Info(params)
-	go p.startProcess(params.Name, params.Namespace, params.Container, params.SessionId, params.Rows, params.Cols)
+	if err := p.sessions.CheckId(params.SessionId); err != nil {
+		return &utils.Response{Code: code.ParamsError, Msg: err.Error()}
+	}
+	if params.Tty == nil {
+		tty := true
+		params.Tty = &tty
+	}
+	go p.startProcess(params)
 	return &utils.Response{Code: code.Success, Msg: "Success"}
 }
 
-func (p *Pod) startProcess(podName, namespace, container, sessionId, rows, cols string) {
-	execCmd := []string{"/bin/sh", "-c",
-		fmt.Sprintf(`export LINES=%s; export COLUMNS=%s; 
+func (p *Pod) startProcess(params *PodExecParams) {
+	tty := *params.Tty
+	execCmd := params.Command
+	if len(execCmd) == 0 {
+		execCmd = []string{"/bin/sh", "-c",
+			fmt.Sprintf(`export LINES=%s; export COLUMNS=%s; 
 	 TERM=xterm-256color; export TERM;
 	 [ -x /bin/bash ] && ([ -x /usr/bin/script ] && /usr/bin/script -q -c \"/bin/bash\" /dev/null || exec /bin/bash) || exec /bin/sh`,
-			rows, cols)}
+				params.Rows, params.Cols)}
+	}
+	stdin := tty || params.Stdin
 	klog.Info(execCmd)
 	sshReq := p.ClientSet.CoreV1().RESTClient().Post().
 		Resource("pods").
-		Name(podName).
-		Namespace(namespace).
+		Name(params.Name).
+		Namespace(params.Namespace).
 		SubResource("exec").
 		VersionedParams(&v1.PodExecOptions{
-			Container: container,
+			Container: params.Container,
 			Command:   execCmd,
-			Stdin:     true,
+			Stdin:     stdin,
 			Stdout:    true,
 			Stderr:    true,
-			TTY:       true,
+			TTY:       tty,
 		}, scheme.ParameterCodec)
 
-	executor, err := remotecommand.NewSPDYExecutor(p.Config, "POST", sshReq.URL())
-	if err != nil {
-		klog.Error("exec pod container error", err)
-		p.SendResponse(base64.StdEncoding.EncodeToString([]byte(err.Error())), sessionId, utils.ExecType)
-		return
-	}
-
-	handler := &streamHandler{
-		SessionId:    sessionId,
-		resizeEvent:  make(chan remotecommand.TerminalSize),
-		InChan:       make(chan []byte),
-		SendResponse: p.SendResponse,
-	}
-	klog.Info("start stream session", sessionId)
-	p.execSessions[sessionId] = handler
-	defer func() {
-		delete(p.execSessions, sessionId)
-	}()
-	if err := executor.Stream(remotecommand.StreamOptions{
-		Stdin:             handler,
-		Stdout:            handler,
-		Stderr:            handler,
-		TerminalSizeQueue: handler,
-		Tty:               true,
-	}); err != nil {
-		klog.Errorf("exec pod container error session %s: %v", sessionId, err)
-		p.SendResponse(base64.StdEncoding.EncodeToString([]byte(err.Error())), sessionId, utils.ExecType)
-		return
-	}
-	p.SendResponse(base64.StdEncoding.EncodeToString([]byte(fmt.Sprint("\nConnection closed"))), sessionId, utils.ExecType)
-	klog.Info("end stream session", sessionId)
+	runExecSession(p.Config, p.sessions, p.SendResponse, p.sendStream, &execSessionOptions{
+		SessionId: params.SessionId,
+		Kind:      ExecSession,
+		Namespace: params.Namespace,
+		Pod:       params.Name,
+		Url:       sshReq.URL(),
+		Tty:       tty,
+		Stdin:     stdin,
+	})
 }
 
 type StdInParams struct {
@@ -308,143 +331,57 @@ type StdInParams struct {
 	Input     string `json:"input"`
 	Width     uint16 `json:"width"`
 	Height    uint16 `json:"height"`
+	// Eof closes stdin after the input is written.
+	Eof bool `json:"eof"`
 }
 
+// ExecStdIn queues the input to the session as the stream frames, so it is written in the order of the requests
+// like the binary frames.
 func (p *Pod) ExecStdIn(requestParams interface{}) *utils.Response {
 	params := &StdInParams{}
 	json.Unmarshal(requestParams.([]byte), params)
-	handler := p.execSessions[params.SessionId]
-	if handler == nil {
+	if _, ok := p.sessions.Get(params.SessionId).(*execSession); !ok {
 		return &utils.Response{Code: code.ParamsError, Msg: "Not found session id"}
 	}
+	var frames []*utils.Frame
 	if params.Width > 0 && params.Height > 0 {
-		handler.resizeEvent <- remotecommand.TerminalSize{Width: params.Width, Height: params.Height}
+		frames = append(frames, &utils.Frame{
+			SessionId: params.SessionId,
+			Stream:    utils.ResizeFrame,
+			Payload:   utils.ResizePayload(params.Width, params.Height),
+		})
 	}
-	handler.InChan <- []byte(params.Input)
-	return &utils.Response{Code: code.Success, Msg: "Success"}
-}
-
-type streamHandler struct {
-	SessionId string
-	InChan    chan []byte
-	websocket.SendResponse
-	resizeEvent chan remotecommand.TerminalSize
-}
-
-func (s *streamHandler) Read(p []byte) (size int, err error) {
-	select {
-	case inData, ok := <-s.InChan:
-		if ok {
-			d, err := base64.StdEncoding.DecodeString(string(inData))
-			if err != nil {
-				klog.Errorf("decode stream input data error: %s", err.Error())
-			} else {
-				klog.Info(string(d))
-				size = len(d)
-				copy(p, d)
-			}
-			//klog.Info(string(inData))
-			//size = len(inData)
-			//copy(p, inData)
+	if params.Input != "" {
+		d, err := base64.StdEncoding.DecodeString(params.Input)
+		if err != nil {
+			klog.Errorf("decode stream input data error: %s", err.Error())
+			return &utils.Response{Code: code.ParamsError, Msg: err.Error()}
 		}
+		frames = append(frames, &utils.Frame{SessionId: params.SessionId, Stream: utils.StdinFrame, Payload: d})
 	}
-	return
-}
-
-func (s *streamHandler) Write(p []byte) (size int, err error) {
-	copyData := make([]byte, len(p))
-	copy(copyData, p)
-	size = len(p)
-	s.SendResponse(copyData, s.SessionId, utils.ExecType)
-	return
-}
-
-// executor回调获取web是否resize
-func (s *streamHandler) Next() (size *remotecommand.TerminalSize) {
-	ret := <-s.resizeEvent
-	size = &ret
-	return
-}
-
-type OpenPodLogParams struct {
-	Name      string `json:"name"`
-	Namespace string `json:"namespace"`
-	Container string `json:"container"`
-	SessionId string `json:"session_id"`
-}
-
-func (p *Pod) OpenLog(requestParams interface{}) *utils.Response {
-	params := &OpenPodLogParams{}
-	json.Unmarshal(requestParams.([]byte), params)
-	klog.Info(params)
-	tailLines := int64(100)
-	podLogOpts := &v1.PodLogOptions{
-		Container: params.Container,
-		Follow:    true,
-		TailLines: &tailLines,
-		//Timestamps: true,
+	if params.Eof {
+		frames = append(frames, &utils.Frame{SessionId: params.SessionId, Stream: utils.CloseFrame})
+	}
+	for _, frame := range frames {
+		if err := p.sessions.Enqueue(frame); err != nil {
+			return &utils.Response{Code: code.ParamsError, Msg: err.Error()}
+		}
 	}
-	go p.logProcess(params.Namespace, params.Name, params.SessionId, podLogOpts)
 	return &utils.Response{Code: code.Success, Msg: "Success"}
 }
 
-type ClosePodLogParams struct {
+type CloseExecParams struct {
 	SessionId string `json:"session_id"`
 }
 
-func (p *Pod) CloseLog(requestParams interface{}) *utils.Response {
-	params := &ClosePodLogParams{}
+func (p *Pod) CloseExec(requestParams interface{}) *utils.Response {
+	params := &CloseExecParams{}
 	json.Unmarshal(requestParams.([]byte), params)
-	klog.Info(params)
-	handler := p.logSessions[params.SessionId]
-	if handler != nil {
-		klog.Info("close log session ", handler.SessionId)
-		handler.PodLogs.Close()
+	if params.SessionId == "" {
+		return &utils.Response{Code: code.ParamsError, Msg: "Session id is blank"}
 	}
-	return &utils.Response{Code: code.Success, Msg: "Success"}
-}
-
-type logHandler struct {
-	SessionId string
-	websocket.SendResponse
-	PodLogs io.ReadCloser
-}
-
-func (l *logHandler) Write(p []byte) (size int, err error) {
-	copyData := make([]byte, len(p))
-	copy(copyData, p)
-	size = len(p)
-	l.SendResponse(copyData, l.SessionId, utils.LogType)
-	return
-}
-
-func (p *Pod) logProcess(namespace, name, sessionId string, podLogOpts *v1.PodLogOptions) {
-
-	req := p.ClientSet.CoreV1().Pods(namespace).GetLogs(name, podLogOpts)
-	podLogs, err := req.Stream()
-	if err != nil {
-		klog.Errorf("open log stream session %s error: %v", sessionId, err)
-		p.SendResponse(base64.StdEncoding.EncodeToString([]byte(err.Error())), sessionId, utils.LogType)
-		return
-	}
-	defer podLogs.Close()
-
-	handler := &logHandler{
-		SessionId:    sessionId,
-		SendResponse: p.SendResponse,
-		PodLogs:      podLogs,
+	if err := p.sessions.Close(params.SessionId); err != nil {
+		return &utils.Response{Code: code.ParamsError, Msg: err.Error()}
 	}
-	klog.Info("start log session ", sessionId)
-	p.logSessions[sessionId] = handler
-	defer func() {
-		delete(p.logSessions, sessionId)
-	}()
-
-	_, err = io.Copy(handler, podLogs)
-	if err != nil {
-		klog.Errorf("copy log session %s error: %v", sessionId, err)
-		p.SendResponse(base64.StdEncoding.EncodeToString([]byte(err.Error())), sessionId, utils.LogType)
-		return
-	}
-	klog.Info("end log session ", sessionId)
+	return &utils.Response{Code: code.Success, Msg: "Success"}
 }
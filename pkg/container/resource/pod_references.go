@@ -0,0 +1,147 @@
+package resource
+
+import (
+	"github.com/openspacee/ospagent/pkg/kubernetes"
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+const (
+	VolumeReference          = "volume"
+	EnvReference             = "env"
+	EnvFromReference         = "envFrom"
+	ImagePullSecretReference = "imagePullSecret"
+)
+
+// BuildPodReference is a reference of a pod container to a config map or secret.
+type BuildPodReference struct {
+	Pod       string      `json:"pod"`
+	Namespace string      `json:"namespace"`
+	Owner     *BuildOwner `json:"owner"`
+	Container string      `json:"container"`
+	Type      string      `json:"type"`
+	// Keys are the referenced keys, all keys when blank.
+	Keys []string `json:"keys"`
+	// SubPath mounts are not updated when the object changes.
+	SubPath bool `json:"sub_path"`
+}
+
+func podContainers(pod *v1.Pod) []v1.Container {
+	return append(append([]v1.Container{}, pod.Spec.InitContainers...), pod.Spec.Containers...)
+}
+
+// volumeKeys returns whether the volume projects the config map or secret, and the projected keys.
+func volumeKeys(volume *v1.Volume, kind, name string) (bool, []string) {
+	found := false
+	var keys []string
+	addItems := func(items []v1.KeyToPath) {
+		found = true
+		for _, item := range items {
+			keys = append(keys, item.Key)
+		}
+	}
+	if kind == "ConfigMap" && volume.ConfigMap != nil && volume.ConfigMap.Name == name {
+		addItems(volume.ConfigMap.Items)
+	}
+	if kind == "Secret" && volume.Secret != nil && volume.Secret.SecretName == name {
+		addItems(volume.Secret.Items)
+	}
+	if volume.Projected != nil {
+		for _, source := range volume.Projected.Sources {
+			if kind == "ConfigMap" && source.ConfigMap != nil && source.ConfigMap.Name == name {
+				addItems(source.ConfigMap.Items)
+			}
+			if kind == "Secret" && source.Secret != nil && source.Secret.Name == name {
+				addItems(source.Secret.Items)
+			}
+		}
+	}
+	return found, keys
+}
+
+// podReferences lists the references of the pod containers to the config map or secret of the kind.
+func podReferences(pod *v1.Pod, kind, name string) []*BuildPodReference {
+	var refs []*BuildPodReference
+	newRef := func(container, typ string, keys []string) *BuildPodReference {
+		ref := &BuildPodReference{Pod: pod.Name, Namespace: pod.Namespace, Container: container, Type: typ, Keys: keys}
+		refs = append(refs, ref)
+		return ref
+	}
+	containers := podContainers(pod)
+	for i := range pod.Spec.Volumes {
+		volume := &pod.Spec.Volumes[i]
+		found, keys := volumeKeys(volume, kind, name)
+		if !found {
+			continue
+		}
+		for _, container := range containers {
+			var ref *BuildPodReference
+			for _, mount := range container.VolumeMounts {
+				if mount.Name != volume.Name {
+					continue
+				}
+				if ref == nil {
+					ref = newRef(container.Name, VolumeReference, keys)
+				}
+				if mount.SubPath != "" || mount.SubPathExpr != "" {
+					ref.SubPath = true
+				}
+			}
+		}
+	}
+	for _, container := range containers {
+		for _, envFrom := range container.EnvFrom {
+			if (kind == "ConfigMap" && envFrom.ConfigMapRef != nil && envFrom.ConfigMapRef.Name == name) ||
+				(kind == "Secret" && envFrom.SecretRef != nil && envFrom.SecretRef.Name == name) {
+				newRef(container.Name, EnvFromReference, nil)
+			}
+		}
+		var keys []string
+		for _, env := range container.Env {
+			if env.ValueFrom == nil {
+				continue
+			}
+			if kind == "ConfigMap" && env.ValueFrom.ConfigMapKeyRef != nil && env.ValueFrom.ConfigMapKeyRef.Name == name {
+				keys = append(keys, env.ValueFrom.ConfigMapKeyRef.Key)
+			}
+			if kind == "Secret" && env.ValueFrom.SecretKeyRef != nil && env.ValueFrom.SecretKeyRef.Name == name {
+				keys = append(keys, env.ValueFrom.SecretKeyRef.Key)
+			}
+		}
+		if len(keys) > 0 {
+			newRef(container.Name, EnvReference, keys)
+		}
+	}
+	if kind == "Secret" {
+		for _, secret := range pod.Spec.ImagePullSecrets {
+			if secret.Name == name {
+				newRef("", ImagePullSecretReference, nil)
+			}
+		}
+	}
+	return refs
+}
+
+// namespaceReferences lists the references of the pods in the namespace, with their top-level owners.
+func namespaceReferences(kubeClient *kubernetes.KubeClient, namespace, kind, name string) ([]*BuildPodReference, error) {
+	pods, err := kubeClient.PodInformer().Lister().Pods(namespace).List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+	refs := []*BuildPodReference{}
+	for _, pod := range pods {
+		podRefs := podReferences(pod, kind, name)
+		if len(podRefs) == 0 {
+			continue
+		}
+		var owner *BuildOwner
+		if owners := ownerChain(kubeClient, pod); len(owners) > 0 {
+			owner = owners[len(owners)-1]
+		}
+		for _, ref := range podRefs {
+			ref.Owner = owner
+		}
+		refs = append(refs, podRefs...)
+	}
+	return refs, nil
+}
@@ -0,0 +1,304 @@
+package resource
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"github.com/openspacee/ospagent/pkg/utils"
+	"github.com/openspacee/ospagent/pkg/utils/code"
+	"github.com/openspacee/ospagent/pkg/websocket"
+	"io"
+	"io/ioutil"
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/httpstream"
+	"k8s.io/client-go/transport/spdy"
+	"k8s.io/klog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const portForwardProtocolV1Name = "portforward.k8s.io"
+
+// portForwardStream is a tcp connection forwarded to the pod port, it is a pair of data and error spdy streams.
+type portForwardStream struct {
+	id          string
+	data        httpstream.Stream
+	errorStream httpstream.Stream
+	closeOnce   sync.Once
+}
+
+func (s *portForwardStream) closeWrite() {
+	s.closeOnce.Do(func() {
+		s.data.Close()
+	})
+}
+
+// portForwardSession multiplexes the tcp connections of the server to a pod port over one spdy connection,
+// the frames of a connection have the session id "<session id>/<connection id>".
+type portForwardSession struct {
+	SessionId string
+	port      int
+	conn      httpstream.Connection
+	websocket.SendStream
+	sessions *SessionManager
+
+	mutex   sync.Mutex
+	streams map[string]*portForwardStream
+	// closed are the connections closed by either side, their late data is rejected instead of opening
+	// a new connection to the pod.
+	closed    map[string]bool
+	requestId int
+}
+
+func portForwardFrameId(sessionId, connId string) string {
+	return sessionId + "/" + connId
+}
+
+func (s *portForwardSession) Close() error {
+	return s.conn.Close()
+}
+
+func (s *portForwardSession) HandleFrame(frame *utils.Frame) error {
+	connId := strings.TrimPrefix(frame.SessionId, s.SessionId+"/")
+	if connId == frame.SessionId || connId == "" {
+		if frame.Stream == utils.CloseFrame {
+			return s.Close()
+		}
+		return fmt.Errorf("port forward frame %s without connection id", frame.SessionId)
+	}
+	switch frame.Stream {
+	case utils.StdinFrame:
+		return s.write(connId, frame.Payload)
+	case utils.CloseFrame:
+		s.closeConn(connId)
+	}
+	return nil
+}
+
+func (s *portForwardSession) write(connId string, data []byte) error {
+	stream, err := s.getStream(connId)
+	if err != nil {
+		return err
+	}
+	s.sessions.Touch(s.SessionId)
+	_, err = stream.data.Write(data)
+	return err
+}
+
+func (s *portForwardSession) closeConn(connId string) {
+	s.mutex.Lock()
+	stream, ok := s.streams[connId]
+	s.closed[connId] = true
+	s.mutex.Unlock()
+	if ok {
+		stream.closeWrite()
+	}
+}
+
+// getStream returns the streams of the connection, they are created on the first data of the connection.
+// The streams are created outside the mutex, as creating them is a round trip to the kubelet.
+func (s *portForwardSession) getStream(connId string) (*portForwardStream, error) {
+	s.mutex.Lock()
+	if s.closed[connId] {
+		s.mutex.Unlock()
+		return nil, fmt.Errorf("port forward connection %s is closed", connId)
+	}
+	if stream, ok := s.streams[connId]; ok {
+		s.mutex.Unlock()
+		return stream, nil
+	}
+	requestId := s.requestId
+	s.requestId += 1
+	s.mutex.Unlock()
+
+	headers := http.Header{}
+	headers.Set(v1.StreamType, v1.StreamTypeError)
+	headers.Set(v1.PortHeader, strconv.Itoa(s.port))
+	headers.Set(v1.PortForwardRequestIDHeader, strconv.Itoa(requestId))
+	errorStream, err := s.conn.CreateStream(headers)
+	if err != nil {
+		return nil, fmt.Errorf("create error stream of port %d error: %v", s.port, err)
+	}
+	// we only read from the error stream
+	errorStream.Close()
+
+	headers.Set(v1.StreamType, v1.StreamTypeData)
+	dataStream, err := s.conn.CreateStream(headers)
+	if err != nil {
+		errorStream.Reset()
+		return nil, fmt.Errorf("create data stream of port %d error: %v", s.port, err)
+	}
+	stream := &portForwardStream{id: connId, data: dataStream, errorStream: errorStream}
+	s.mutex.Lock()
+	existing, ok := s.streams[connId]
+	closed := s.closed[connId]
+	if ok || closed {
+		s.mutex.Unlock()
+		dataStream.Reset()
+		errorStream.Reset()
+		if closed {
+			return nil, fmt.Errorf("port forward connection %s is closed", connId)
+		}
+		return existing, nil
+	}
+	s.streams[connId] = stream
+	s.mutex.Unlock()
+	go s.copyStream(stream)
+	return stream, nil
+}
+
+func (s *portForwardSession) copyStream(stream *portForwardStream) {
+	frameId := portForwardFrameId(s.SessionId, stream.id)
+	go func() {
+		message, err := ioutil.ReadAll(stream.errorStream)
+		if err != nil {
+			message = []byte(fmt.Sprintf("read error stream error: %v", err))
+		}
+		if len(message) > 0 {
+			klog.Errorf("port forward %s error: %s", frameId, string(message))
+			s.SendStream(utils.PortForwardType, &utils.Frame{SessionId: frameId, Stream: utils.StderrFrame, Payload: message})
+		}
+	}()
+	writer := &portForwardWriter{frameId: frameId, session: s}
+	if _, err := io.Copy(writer, stream.data); err != nil {
+		klog.Errorf("port forward %s copy error: %v", frameId, err)
+	}
+	stream.closeWrite()
+	stream.data.Reset()
+	s.mutex.Lock()
+	delete(s.streams, stream.id)
+	s.closed[stream.id] = true
+	s.mutex.Unlock()
+	s.SendStream(utils.PortForwardType, &utils.Frame{SessionId: frameId, Stream: utils.CloseFrame})
+}
+
+type portForwardWriter struct {
+	frameId string
+	session *portForwardSession
+}
+
+func (w *portForwardWriter) Write(p []byte) (int, error) {
+	data := make([]byte, len(p))
+	copy(data, p)
+	w.session.SendStream(utils.PortForwardType, &utils.Frame{SessionId: w.frameId, Stream: utils.StdoutFrame, Payload: data})
+	w.session.sessions.Touch(w.session.SessionId)
+	return len(p), nil
+}
+
+type PortForwardParams struct {
+	SessionId string `json:"session_id"`
+	Name      string `json:"name"`
+	Namespace string `json:"namespace"`
+	Port      int    `json:"port"`
+	ConnId    string `json:"conn_id"`
+	Data      string `json:"data"`
+}
+
+func (p *Pod) OpenPortForward(requestParams interface{}) *utils.Response {
+	params := &PortForwardParams{}
+	json.Unmarshal(requestParams.([]byte), params)
+	klog.Info(params)
+	if params.SessionId == "" {
+		return &utils.Response{Code: code.ParamsError, Msg: "Session id is blank"}
+	}
+	if params.Name == "" || params.Namespace == "" {
+		return &utils.Response{Code: code.ParamsError, Msg: "Pod name or namespace is blank"}
+	}
+	if params.Port <= 0 || params.Port > 65535 {
+		return &utils.Response{Code: code.ParamsError, Msg: fmt.Sprintf("Invalid port %d", params.Port)}
+	}
+	transport, upgrader, err := spdy.RoundTripperFor(p.Config)
+	if err != nil {
+		return &utils.Response{Code: code.ParamsError, Msg: err.Error()}
+	}
+	req := p.ClientSet.CoreV1().RESTClient().Post().
+		Resource("pods").
+		Namespace(params.Namespace).
+		Name(params.Name).
+		SubResource("portforward")
+	dialer := spdy.NewDialer(upgrader, &http.Client{Transport: transport}, "POST", req.URL())
+	conn, _, err := dialer.Dial(portForwardProtocolV1Name)
+	if err != nil {
+		klog.Errorf("dial port forward of pod %s/%s error: %v", params.Namespace, params.Name, err)
+		return &utils.Response{Code: code.ParamsError, Msg: err.Error()}
+	}
+	session := &portForwardSession{
+		SessionId:  params.SessionId,
+		port:       params.Port,
+		conn:       conn,
+		SendStream: p.sendStream,
+		sessions:   p.sessions,
+		streams:    make(map[string]*portForwardStream),
+		closed:     make(map[string]bool),
+	}
+	if err := p.sessions.Add(params.SessionId, PortForwardSession, params.Namespace, params.Name, session); err != nil {
+		conn.Close()
+		return &utils.Response{Code: code.ParamsError, Msg: err.Error()}
+	}
+	go func() {
+		<-conn.CloseChan()
+		klog.Info("end port forward session ", params.SessionId)
+		p.sessions.Remove(params.SessionId, session)
+		p.sendStream(utils.PortForwardType, &utils.Frame{SessionId: params.SessionId, Stream: utils.CloseFrame})
+	}()
+	klog.Info("start port forward session ", params.SessionId)
+	return &utils.Response{Code: code.Success, Msg: "Success"}
+}
+
+func (s *portForwardSession) isClosed(connId string) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.closed[connId]
+}
+
+// PortForwardData queues the base64 data of a connection to the session like the binary frames, so the data is
+// written in the order of the requests. It is for the server not speaking the binary stream protocol.
+func (p *Pod) PortForwardData(requestParams interface{}) *utils.Response {
+	params := &PortForwardParams{}
+	json.Unmarshal(requestParams.([]byte), params)
+	session, ok := p.sessions.Get(params.SessionId).(*portForwardSession)
+	if !ok {
+		return &utils.Response{Code: code.ParamsError, Msg: "Not found session id"}
+	}
+	if params.ConnId == "" {
+		return &utils.Response{Code: code.ParamsError, Msg: "Connection id is blank"}
+	}
+	if session.isClosed(params.ConnId) {
+		return &utils.Response{Code: code.ParamsError, Msg: fmt.Sprintf("Connection %s is closed", params.ConnId)}
+	}
+	data, err := base64.StdEncoding.DecodeString(params.Data)
+	if err != nil {
+		return &utils.Response{Code: code.ParamsError, Msg: err.Error()}
+	}
+	frame := &utils.Frame{SessionId: portForwardFrameId(params.SessionId, params.ConnId), Stream: utils.StdinFrame, Payload: data}
+	if err := utils.CheckFrameSessionId(frame.SessionId); err != nil {
+		return &utils.Response{Code: code.ParamsError, Msg: err.Error()}
+	}
+	if err := p.sessions.Enqueue(frame); err != nil {
+		return &utils.Response{Code: code.ParamsError, Msg: err.Error()}
+	}
+	return &utils.Response{Code: code.Success, Msg: "Success"}
+}
+
+// ClosePortForward closes a connection, or the whole session when the connection id is blank.
+func (p *Pod) ClosePortForward(requestParams interface{}) *utils.Response {
+	params := &PortForwardParams{}
+	json.Unmarshal(requestParams.([]byte), params)
+	if params.ConnId == "" {
+		if err := p.sessions.Close(params.SessionId); err != nil {
+			return &utils.Response{Code: code.ParamsError, Msg: err.Error()}
+		}
+		return &utils.Response{Code: code.Success, Msg: "Success"}
+	}
+	if _, ok := p.sessions.Get(params.SessionId).(*portForwardSession); !ok {
+		return &utils.Response{Code: code.ParamsError, Msg: "Not found session id"}
+	}
+	// the connection is closed after its queued data is written
+	frame := &utils.Frame{SessionId: portForwardFrameId(params.SessionId, params.ConnId), Stream: utils.CloseFrame}
+	if err := p.sessions.Enqueue(frame); err != nil {
+		return &utils.Response{Code: code.ParamsError, Msg: err.Error()}
+	}
+	return &utils.Response{Code: code.Success, Msg: "Success"}
+}
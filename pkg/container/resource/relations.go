@@ -0,0 +1,412 @@
+package resource
+
+import (
+	"encoding/json"
+	"github.com/openspacee/ospagent/pkg/kubernetes"
+	"github.com/openspacee/ospagent/pkg/utils"
+	"github.com/openspacee/ospagent/pkg/utils/code"
+	appsv1 "k8s.io/api/apps/v1"
+	hpav2beta1 "k8s.io/api/autoscaling/v2beta1"
+	batchv1 "k8s.io/api/batch/v1"
+	batchv1beta1 "k8s.io/api/batch/v1beta1"
+	"k8s.io/api/core/v1"
+	extv1beta1 "k8s.io/api/extensions/v1beta1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog"
+)
+
+const (
+	defaultRelationDepth = 3
+	maxRelationNodes     = 300
+
+	OwnsRelation     = "owns"
+	SelectsRelation  = "selects"
+	EndpointRelation = "endpoints"
+	TargetsRelation  = "targets"
+	RoutesRelation   = "routes"
+	MountsRelation   = "mounts"
+	RefersRelation   = "refers"
+	BindsRelation    = "binds"
+	ScalesRelation   = "scales"
+	RunsOnRelation   = "runs_on"
+)
+
+// the kinds of the cluster scoped objects, their namespace is blank
+var clusterScopedKinds = map[string]bool{
+	"Node":             true,
+	"PersistentVolume": true,
+	"StorageClass":     true,
+	"ClusterRole":      true,
+	"User":             true,
+	"Group":            true,
+}
+
+type Relations struct {
+	*kubernetes.KubeClient
+}
+
+func NewRelations(kubeClient *kubernetes.KubeClient) *Relations {
+	return &Relations{KubeClient: kubeClient}
+}
+
+type RelationsParams struct {
+	Kind      string `json:"kind"`
+	Name      string `json:"name"`
+	Namespace string `json:"namespace"`
+	// Depth is the max number of edges from the object, the owner chain upward is always followed.
+	Depth int `json:"depth"`
+}
+
+type RelationNode struct {
+	Id        string `json:"id"`
+	Kind      string `json:"kind"`
+	Name      string `json:"name"`
+	Namespace string `json:"namespace"`
+	// Missing is a referenced object not found, like a pod mounting a deleted config map.
+	Missing bool `json:"missing"`
+}
+
+type RelationEdge struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+	Type string `json:"type"`
+}
+
+type RelationGraph struct {
+	Nodes     []*RelationNode `json:"nodes"`
+	Edges     []*RelationEdge `json:"edges"`
+	Truncated bool            `json:"truncated"`
+}
+
+type relation struct {
+	kind      string
+	namespace string
+	name      string
+	typ       string
+	// reverse is an edge from the related object to the object
+	reverse bool
+	// upward relations are followed beyond the depth
+	upward bool
+}
+
+type relationItem struct {
+	node  *RelationNode
+	obj   metav1.Object
+	depth int
+}
+
+func relationId(kind, namespace, name string) string {
+	return kind + "/" + namespace + "/" + name
+}
+
+// Get walks the relation graph of the object from the informer caches.
+func (r *Relations) Get(requestParams interface{}) *utils.Response {
+	params := &RelationsParams{}
+	json.Unmarshal(requestParams.([]byte), params)
+	if params.Kind == "" || params.Name == "" {
+		return &utils.Response{Code: code.ParamsError, Msg: "Kind or name is blank"}
+	}
+	if clusterScopedKinds[params.Kind] {
+		params.Namespace = ""
+	} else if params.Namespace == "" {
+		return &utils.Response{Code: code.ParamsError, Msg: "Namespace is blank"}
+	}
+	if params.Depth <= 0 {
+		params.Depth = defaultRelationDepth
+	}
+	obj, err := r.getObject(params.Kind, params.Namespace, params.Name)
+	if err != nil || obj == nil {
+		msg := "Unsupported kind " + params.Kind
+		if err != nil {
+			msg = err.Error()
+		}
+		return &utils.Response{Code: code.GetError, Msg: msg}
+	}
+
+	graph := &RelationGraph{}
+	nodes := make(map[string]*RelationNode)
+	edges := make(map[string]bool)
+	start := &RelationNode{
+		Id:        relationId(params.Kind, params.Namespace, params.Name),
+		Kind:      params.Kind,
+		Name:      params.Name,
+		Namespace: params.Namespace,
+	}
+	nodes[start.Id] = start
+	graph.Nodes = append(graph.Nodes, start)
+	queue := []*relationItem{{node: start, obj: obj}}
+	for len(queue) > 0 {
+		item := queue[0]
+		queue = queue[1:]
+		for _, rel := range r.relations(item.node.Kind, item.obj) {
+			if item.depth >= params.Depth && !rel.upward {
+				continue
+			}
+			namespace := rel.namespace
+			if clusterScopedKinds[rel.kind] {
+				namespace = ""
+			}
+			id := relationId(rel.kind, namespace, rel.name)
+			node, ok := nodes[id]
+			if !ok {
+				if len(nodes) >= maxRelationNodes {
+					graph.Truncated = true
+					continue
+				}
+				node = &RelationNode{Id: id, Kind: rel.kind, Name: rel.name, Namespace: namespace}
+				nodes[id] = node
+				graph.Nodes = append(graph.Nodes, node)
+				related, err := r.getObject(rel.kind, namespace, rel.name)
+				if err != nil {
+					node.Missing = true
+				} else if related != nil {
+					queue = append(queue, &relationItem{node: node, obj: related, depth: item.depth + 1})
+				}
+			}
+			from, to := item.node.Id, id
+			if rel.reverse {
+				from, to = to, from
+			}
+			key := from + ">" + to + ">" + rel.typ
+			if !edges[key] {
+				edges[key] = true
+				graph.Edges = append(graph.Edges, &RelationEdge{From: from, To: to, Type: rel.typ})
+			}
+		}
+	}
+	return &utils.Response{Code: code.Success, Msg: "Success", Data: graph}
+}
+
+// getObject gets the object from the informer cache, it is nil without error for the kinds not cached.
+func (r *Relations) getObject(kind, namespace, name string) (metav1.Object, error) {
+	return cachedObject(r.KubeClient, kind, namespace, name)
+}
+
+func (r *Relations) relations(kind string, obj metav1.Object) []relation {
+	namespace := obj.GetNamespace()
+	var rels []relation
+	for _, ref := range obj.GetOwnerReferences() {
+		rels = append(rels, relation{kind: ref.Kind, namespace: namespace, name: ref.Name, typ: OwnsRelation, reverse: true, upward: true})
+	}
+	switch o := obj.(type) {
+	case *appsv1.Deployment:
+		rels = append(rels, r.ownedReplicaSets(o.UID, namespace)...)
+	case *appsv1.ReplicaSet:
+		rels = append(rels, r.ownedPods(o.UID, namespace)...)
+	case *appsv1.StatefulSet:
+		rels = append(rels, r.ownedPods(o.UID, namespace)...)
+		rels = append(rels, r.ownedControllerRevisions(o.UID, namespace)...)
+	case *appsv1.DaemonSet:
+		rels = append(rels, r.ownedPods(o.UID, namespace)...)
+		rels = append(rels, r.ownedControllerRevisions(o.UID, namespace)...)
+	case *batchv1.Job:
+		rels = append(rels, r.ownedPods(o.UID, namespace)...)
+	case *batchv1beta1.CronJob:
+		rels = append(rels, r.ownedJobs(o.UID, namespace)...)
+	case *v1.Pod:
+		rels = append(rels, podRelations(o)...)
+		rels = append(rels, r.podServices(o)...)
+	case *v1.Service:
+		rels = append(rels, relation{kind: "Endpoints", namespace: namespace, name: o.Name, typ: EndpointRelation})
+		if len(o.Spec.Selector) > 0 {
+			pods, _ := r.PodInformer().Lister().Pods(namespace).List(labels.SelectorFromSet(o.Spec.Selector))
+			for _, pod := range pods {
+				rels = append(rels, relation{kind: "Pod", namespace: namespace, name: pod.Name, typ: SelectsRelation})
+			}
+		}
+	case *v1.Endpoints:
+		for _, subset := range o.Subsets {
+			for _, addresses := range [][]v1.EndpointAddress{subset.Addresses, subset.NotReadyAddresses} {
+				for _, address := range addresses {
+					if address.TargetRef != nil && address.TargetRef.Kind == "Pod" {
+						rels = append(rels, relation{kind: "Pod", namespace: address.TargetRef.Namespace, name: address.TargetRef.Name, typ: TargetsRelation})
+					}
+				}
+			}
+		}
+	case *extv1beta1.Ingress:
+		rels = append(rels, ingressRelations(o)...)
+	case *v1.PersistentVolumeClaim:
+		if o.Spec.VolumeName != "" {
+			rels = append(rels, relation{kind: "PersistentVolume", name: o.Spec.VolumeName, typ: BindsRelation})
+		}
+		if o.Spec.StorageClassName != nil && *o.Spec.StorageClassName != "" {
+			rels = append(rels, relation{kind: "StorageClass", name: *o.Spec.StorageClassName, typ: RefersRelation})
+		}
+	case *v1.PersistentVolume:
+		if o.Spec.ClaimRef != nil {
+			rels = append(rels, relation{kind: "PersistentVolumeClaim", namespace: o.Spec.ClaimRef.Namespace, name: o.Spec.ClaimRef.Name, typ: BindsRelation, reverse: true})
+		}
+		if o.Spec.StorageClassName != "" {
+			rels = append(rels, relation{kind: "StorageClass", name: o.Spec.StorageClassName, typ: RefersRelation})
+		}
+	case *hpav2beta1.HorizontalPodAutoscaler:
+		rels = append(rels, relation{kind: o.Spec.ScaleTargetRef.Kind, namespace: namespace, name: o.Spec.ScaleTargetRef.Name, typ: ScalesRelation})
+	case *rbacv1.RoleBinding:
+		rels = append(rels, relation{kind: o.RoleRef.Kind, namespace: namespace, name: o.RoleRef.Name, typ: BindsRelation})
+		for _, subject := range o.Subjects {
+			subjectNamespace := subject.Namespace
+			if subjectNamespace == "" {
+				subjectNamespace = namespace
+			}
+			rels = append(rels, relation{kind: subject.Kind, namespace: subjectNamespace, name: subject.Name, typ: BindsRelation})
+		}
+	}
+	return rels
+}
+
+func podRelations(pod *v1.Pod) []relation {
+	namespace := pod.Namespace
+	var rels []relation
+	add := func(kind, name, typ string) {
+		if name != "" {
+			rels = append(rels, relation{kind: kind, namespace: namespace, name: name, typ: typ})
+		}
+	}
+	for _, volume := range pod.Spec.Volumes {
+		if volume.ConfigMap != nil {
+			add("ConfigMap", volume.ConfigMap.Name, MountsRelation)
+		}
+		if volume.Secret != nil {
+			add("Secret", volume.Secret.SecretName, MountsRelation)
+		}
+		if volume.PersistentVolumeClaim != nil {
+			add("PersistentVolumeClaim", volume.PersistentVolumeClaim.ClaimName, MountsRelation)
+		}
+		if volume.Projected != nil {
+			for _, source := range volume.Projected.Sources {
+				if source.ConfigMap != nil {
+					add("ConfigMap", source.ConfigMap.Name, MountsRelation)
+				}
+				if source.Secret != nil {
+					add("Secret", source.Secret.Name, MountsRelation)
+				}
+			}
+		}
+	}
+	containers := append(append([]v1.Container{}, pod.Spec.InitContainers...), pod.Spec.Containers...)
+	for _, container := range containers {
+		for _, envFrom := range container.EnvFrom {
+			if envFrom.ConfigMapRef != nil {
+				add("ConfigMap", envFrom.ConfigMapRef.Name, RefersRelation)
+			}
+			if envFrom.SecretRef != nil {
+				add("Secret", envFrom.SecretRef.Name, RefersRelation)
+			}
+		}
+		for _, env := range container.Env {
+			if env.ValueFrom == nil {
+				continue
+			}
+			if env.ValueFrom.ConfigMapKeyRef != nil {
+				add("ConfigMap", env.ValueFrom.ConfigMapKeyRef.Name, RefersRelation)
+			}
+			if env.ValueFrom.SecretKeyRef != nil {
+				add("Secret", env.ValueFrom.SecretKeyRef.Name, RefersRelation)
+			}
+		}
+	}
+	for _, secret := range pod.Spec.ImagePullSecrets {
+		add("Secret", secret.Name, RefersRelation)
+	}
+	add("ServiceAccount", pod.Spec.ServiceAccountName, RefersRelation)
+	if pod.Spec.NodeName != "" {
+		rels = append(rels, relation{kind: "Node", name: pod.Spec.NodeName, typ: RunsOnRelation})
+	}
+	return rels
+}
+
+func ingressRelations(ingress *extv1beta1.Ingress) []relation {
+	var rels []relation
+	if ingress.Spec.Backend != nil && ingress.Spec.Backend.ServiceName != "" {
+		rels = append(rels, relation{kind: "Service", namespace: ingress.Namespace, name: ingress.Spec.Backend.ServiceName, typ: RoutesRelation})
+	}
+	for _, rule := range ingress.Spec.Rules {
+		if rule.HTTP == nil {
+			continue
+		}
+		for _, path := range rule.HTTP.Paths {
+			if path.Backend.ServiceName != "" {
+				rels = append(rels, relation{kind: "Service", namespace: ingress.Namespace, name: path.Backend.ServiceName, typ: RoutesRelation})
+			}
+		}
+	}
+	for _, tls := range ingress.Spec.TLS {
+		if tls.SecretName != "" {
+			rels = append(rels, relation{kind: "Secret", namespace: ingress.Namespace, name: tls.SecretName, typ: RefersRelation})
+		}
+	}
+	return rels
+}
+
+// podServices are the services selecting the pod.
+func (r *Relations) podServices(pod *v1.Pod) []relation {
+	services, err := r.ServiceInformer().Lister().Services(pod.Namespace).List(labels.Everything())
+	if err != nil {
+		klog.Errorf("list services error: %v", err)
+		return nil
+	}
+	var rels []relation
+	for _, service := range services {
+		if len(service.Spec.Selector) > 0 && labels.SelectorFromSet(service.Spec.Selector).Matches(labels.Set(pod.Labels)) {
+			rels = append(rels, relation{kind: "Service", namespace: pod.Namespace, name: service.Name, typ: SelectsRelation, reverse: true})
+		}
+	}
+	return rels
+}
+
+func ownedBy(obj metav1.Object, uid types.UID) bool {
+	for _, ref := range obj.GetOwnerReferences() {
+		if ref.UID == uid {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *Relations) ownedPods(uid types.UID, namespace string) []relation {
+	pods, _ := r.PodInformer().Lister().Pods(namespace).List(labels.Everything())
+	var rels []relation
+	for _, pod := range pods {
+		if ownedBy(pod, uid) {
+			rels = append(rels, relation{kind: "Pod", namespace: namespace, name: pod.Name, typ: OwnsRelation})
+		}
+	}
+	return rels
+}
+
+func (r *Relations) ownedReplicaSets(uid types.UID, namespace string) []relation {
+	rss, _ := r.ReplicaSetInformer().Lister().ReplicaSets(namespace).List(labels.Everything())
+	var rels []relation
+	for _, rs := range rss {
+		if ownedBy(rs, uid) {
+			rels = append(rels, relation{kind: "ReplicaSet", namespace: namespace, name: rs.Name, typ: OwnsRelation})
+		}
+	}
+	return rels
+}
+
+func (r *Relations) ownedControllerRevisions(uid types.UID, namespace string) []relation {
+	crs, _ := r.ControllerRevisionInformer().Lister().ControllerRevisions(namespace).List(labels.Everything())
+	var rels []relation
+	for _, cr := range crs {
+		if ownedBy(cr, uid) {
+			rels = append(rels, relation{kind: "ControllerRevision", namespace: namespace, name: cr.Name, typ: OwnsRelation})
+		}
+	}
+	return rels
+}
+
+func (r *Relations) ownedJobs(uid types.UID, namespace string) []relation {
+	jobs, _ := r.JobInformer().Lister().Jobs(namespace).List(labels.Everything())
+	var rels []relation
+	for _, job := range jobs {
+		if ownedBy(job, uid) {
+			rels = append(rels, relation{kind: "Job", namespace: namespace, name: job.Name, typ: OwnsRelation})
+		}
+	}
+	return rels
+}
@@ -0,0 +1,149 @@
+package resource
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/openspacee/ospagent/pkg/kubernetes"
+	"github.com/openspacee/ospagent/pkg/utils"
+	"github.com/openspacee/ospagent/pkg/utils/code"
+	"k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/runtime/serializer"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog"
+	"strings"
+)
+
+type ReplicaSet struct {
+	watch *WatchResource
+	*DynamicResource
+}
+
+func NewReplicaSet(kubeClient *kubernetes.KubeClient, watch *WatchResource) *ReplicaSet {
+	r := &ReplicaSet{
+		watch: watch,
+		DynamicResource: NewDynamicResource(kubeClient, &schema.GroupVersionResource{
+			Group:    "apps",
+			Version:  "v1",
+			Resource: "replicasets",
+		}),
+	}
+	r.DoWatch()
+	return r
+}
+
+func (r *ReplicaSet) DoWatch() {
+	informer := r.KubeClient.ReplicaSetInformer().Informer()
+	informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    r.watch.WatchAdd(utils.WatchReplicaset),
+		UpdateFunc: r.watch.WatchUpdate(utils.WatchReplicaset),
+		DeleteFunc: r.watch.WatchDelete(utils.WatchReplicaset),
+	})
+}
+
+type BuildReplicaSet struct {
+	UID               string      `json:"uid"`
+	Name              string      `json:"name"`
+	Namespace         string      `json:"namespace"`
+	Replicas          int32       `json:"replicas"`
+	StatusReplicas    int32       `json:"status_replicas"`
+	ReadyReplicas     int32       `json:"ready_replicas"`
+	AvailableReplicas int32       `json:"available_replicas"`
+	Revision          string      `json:"revision"`
+	Deployment        string      `json:"deployment"`
+	ResourceVersion   string      `json:"resource_version"`
+	Created           metav1.Time `json:"created"`
+}
+
+func (r *ReplicaSet) ToBuildReplicaSet(rs *v1.ReplicaSet) *BuildReplicaSet {
+	if rs == nil {
+		return nil
+	}
+	data := &BuildReplicaSet{
+		UID:               string(rs.UID),
+		Name:              rs.Name,
+		Namespace:         rs.Namespace,
+		StatusReplicas:    rs.Status.Replicas,
+		ReadyReplicas:     rs.Status.ReadyReplicas,
+		AvailableReplicas: rs.Status.AvailableReplicas,
+		Revision:          rs.Annotations[revisionAnnotation],
+		ResourceVersion:   rs.ResourceVersion,
+		Created:           rs.CreationTimestamp,
+	}
+	if rs.Spec.Replicas != nil {
+		data.Replicas = *rs.Spec.Replicas
+	}
+	if owner := metav1.GetControllerOf(rs); owner != nil && owner.Kind == "Deployment" {
+		data.Deployment = owner.Name
+	}
+	return data
+}
+
+type ReplicaSetQueryParams struct {
+	Name       string `json:"name"`
+	Namespace  string `json:"namespace"`
+	UID        string `json:"uid"`
+	Output     string `json:"output"`
+	Deployment string `json:"deployment"`
+}
+
+func (r *ReplicaSet) List(requestParams interface{}) *utils.Response {
+	queryParams := &ReplicaSetQueryParams{}
+	json.Unmarshal(requestParams.([]byte), queryParams)
+	list, err := r.KubeClient.ReplicaSetInformer().Lister().ReplicaSets(queryParams.Namespace).List(labels.Everything())
+	if err != nil {
+		return &utils.Response{Code: code.ListError, Msg: err.Error()}
+	}
+	var rss []*BuildReplicaSet
+	for _, rs := range list {
+		if queryParams.UID != "" && string(rs.UID) != queryParams.UID {
+			continue
+		}
+		if queryParams.Name != "" && !strings.Contains(rs.Name, queryParams.Name) {
+			continue
+		}
+		brs := r.ToBuildReplicaSet(rs)
+		if queryParams.Deployment != "" && brs.Deployment != queryParams.Deployment {
+			continue
+		}
+		rss = append(rss, brs)
+	}
+	return &utils.Response{Code: code.Success, Msg: "Success", Data: rss}
+}
+
+func (r *ReplicaSet) Get(requestParams interface{}) *utils.Response {
+	queryParams := &ReplicaSetQueryParams{}
+	json.Unmarshal(requestParams.([]byte), queryParams)
+	if queryParams.Name == "" {
+		return &utils.Response{Code: code.ParamsError, Msg: "ReplicaSet name is blank"}
+	}
+	if queryParams.Namespace == "" {
+		return &utils.Response{Code: code.ParamsError, Msg: "Namespace is blank"}
+	}
+	rs, err := r.KubeClient.ReplicaSetInformer().Lister().ReplicaSets(queryParams.Namespace).Get(queryParams.Name)
+	if err != nil {
+		return &utils.Response{Code: code.GetError, Msg: err.Error()}
+	}
+	if queryParams.Output == "yaml" {
+		const mediaType = runtime.ContentTypeYAML
+		rscheme := runtime.NewScheme()
+		v1.AddToScheme(rscheme)
+		codecs := serializer.NewCodecFactory(rscheme)
+		info, ok := runtime.SerializerInfoForMediaType(codecs.SupportedMediaTypes(), mediaType)
+		if !ok {
+			return &utils.Response{Code: code.Success, Msg: fmt.Sprintf("unsupported media type %q", mediaType)}
+		}
+
+		encoder := codecs.EncoderForVersion(info.Serializer, r.GroupVersion())
+		d, e := runtime.Encode(encoder, rs)
+		if e != nil {
+			klog.Error(e)
+			return &utils.Response{Code: code.EncodeError, Msg: e.Error()}
+		}
+		return &utils.Response{Code: code.Success, Msg: "Success", Data: string(d)}
+	}
+	return &utils.Response{Code: code.Success, Msg: "Success", Data: rs}
+}
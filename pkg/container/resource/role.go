@@ -15,6 +15,8 @@ import (
 	"k8s.io/client-go/tools/cache"
 	"k8s.io/client-go/util/retry"
 	"k8s.io/klog"
+	"reflect"
+	"sort"
 	"strings"
 )
 
@@ -60,13 +62,14 @@ func (s *Role) DoWatch() {
 }
 
 type BuildRole struct {
-	UID       string `json:"uid"`
-	Kind      string `json:"kind"`
-	Name      string `json:"name"`
-	Namespace string `json:"namespace"`
-	//Rules []rbacv1.PolicyRule `json:"rules"`
-	ResourceVersion string      `json:"resource_version"`
-	Created         metav1.Time `json:"created"`
+	UID             string                  `json:"uid"`
+	Kind            string                  `json:"kind"`
+	Name            string                  `json:"name"`
+	Namespace       string                  `json:"namespace"`
+	Rules           []rbacv1.PolicyRule     `json:"rules"`
+	AggregationRule *rbacv1.AggregationRule `json:"aggregation_rule"`
+	ResourceVersion string                  `json:"resource_version"`
+	Created         metav1.Time             `json:"created"`
 }
 
 func (s *Role) ToBuildRole(role *rbacv1.Role) *BuildRole {
@@ -74,11 +77,11 @@ func (s *Role) ToBuildRole(role *rbacv1.Role) *BuildRole {
 		return nil
 	}
 	data := &BuildRole{
-		UID:       string(role.UID),
-		Name:      role.Name,
-		Kind:      "Role",
-		Namespace: role.Namespace,
-		//Rules: role.Rules,
+		UID:             string(role.UID),
+		Name:            role.Name,
+		Kind:            "Role",
+		Namespace:       role.Namespace,
+		Rules:           role.Rules,
 		Created:         role.CreationTimestamp,
 		ResourceVersion: role.ResourceVersion,
 	}
@@ -91,11 +94,12 @@ func (s *Role) ToBuildClusterRole(role *rbacv1.ClusterRole) *BuildRole {
 		return nil
 	}
 	data := &BuildRole{
-		UID:       string(role.UID),
-		Kind:      "ClusterRole",
-		Name:      role.Name,
-		Namespace: role.Namespace,
-		//Rules: role.Rules,
+		UID:             string(role.UID),
+		Kind:            "ClusterRole",
+		Name:            role.Name,
+		Namespace:       role.Namespace,
+		Rules:           role.Rules,
+		AggregationRule: role.AggregationRule,
 		Created:         role.CreationTimestamp,
 		ResourceVersion: role.ResourceVersion,
 	}
@@ -111,10 +115,34 @@ type RoleQueryParams struct {
 	Output    string `json:"output"`
 }
 
+// RoleUpdateParams creates a role or updates the fields given, the aggregation rule is only for cluster roles.
 type RoleUpdateParams struct {
-	Name      string `json:"name"`
-	Namespace string `json:"namespace"`
-	Replicas  int32  `json:"replicas"`
+	Kind            string                  `json:"kind"`
+	Name            string                  `json:"name"`
+	Namespace       string                  `json:"namespace"`
+	Labels          map[string]string       `json:"labels"`
+	Rules           []rbacv1.PolicyRule     `json:"rules"`
+	AggregationRule *rbacv1.AggregationRule `json:"aggregation_rule"`
+}
+
+type RoleDeleteParams struct {
+	Kind string `json:"kind"`
+}
+
+func checkRoleParams(params *RoleUpdateParams) string {
+	if params.Name == "" {
+		return "Role name is blank"
+	}
+	if params.Kind != "ClusterRole" && params.Kind != "Role" {
+		return "Kind is not correct"
+	}
+	if params.Kind == "Role" && params.Namespace == "" {
+		return "Namespace is blank"
+	}
+	if params.Kind == "Role" && params.AggregationRule != nil {
+		return "Aggregation rule is only for cluster roles"
+	}
+	return ""
 }
 
 func (s *Role) List(requestParams interface{}) *utils.Response {
@@ -142,7 +170,7 @@ func (s *Role) List(requestParams interface{}) *utils.Response {
 		if queryParams.Namespace != "" && ds.Namespace != queryParams.Namespace {
 			continue
 		}
-		if queryParams.Name != "" && strings.Contains(ds.Name, queryParams.Name) {
+		if queryParams.Name != "" && !strings.Contains(ds.Name, queryParams.Name) {
 			continue
 		}
 		roles = append(roles, s.ToBuildRole(ds))
@@ -154,7 +182,7 @@ func (s *Role) List(requestParams interface{}) *utils.Response {
 		if queryParams.Namespace != "" && ds.Namespace != queryParams.Namespace {
 			continue
 		}
-		if queryParams.Name != "" && strings.Contains(ds.Name, queryParams.Name) {
+		if queryParams.Name != "" && !strings.Contains(ds.Name, queryParams.Name) {
 			continue
 		}
 		roles = append(roles, s.ToBuildClusterRole(ds))
@@ -171,7 +199,7 @@ func (s *Role) Get(requestParams interface{}) *utils.Response {
 	if queryParams.Kind == "" || (queryParams.Kind != "ClusterRole" && queryParams.Kind != "Role") {
 		return &utils.Response{Code: code.ParamsError, Msg: "Kind is not correct"}
 	}
-	if queryParams.Namespace == "" {
+	if queryParams.Namespace == "" && queryParams.Kind == "Role" {
 		return &utils.Response{Code: code.ParamsError, Msg: "Namespace is blank"}
 	}
 	var role runtime.Object
@@ -199,44 +227,98 @@ func (s *Role) Get(requestParams interface{}) *utils.Response {
 		} else {
 			encoder = codecs.EncoderForVersion(info.Serializer, s.roleDynamic.GroupVersion())
 		}
-		//klog.Info(a)
 		d, e := runtime.Encode(encoder, role)
 		if e != nil {
 			klog.Error(e)
-			return &utils.Response{Code: code.Success, Msg: e.Error()}
+			return &utils.Response{Code: code.EncodeError, Msg: e.Error()}
 		}
 		return &utils.Response{Code: code.Success, Msg: "Success", Data: string(d)}
 	}
 	return &utils.Response{Code: code.Success, Msg: "Success", Data: role}
 }
 
+func (s *Role) Create(createParams interface{}) *utils.Response {
+	params := &RoleUpdateParams{}
+	json.Unmarshal(createParams.([]byte), params)
+	if msg := checkRoleParams(params); msg != "" {
+		return &utils.Response{Code: code.ParamsError, Msg: msg}
+	}
+	meta := metav1.ObjectMeta{Name: params.Name, Namespace: params.Namespace, Labels: params.Labels}
+	var data *BuildRole
+	if params.Kind == "Role" {
+		role, err := s.ClientSet.RbacV1().Roles(params.Namespace).Create(&rbacv1.Role{ObjectMeta: meta, Rules: params.Rules})
+		if err != nil {
+			klog.Errorf("Create Role failed: %v", err)
+			return &utils.Response{Code: code.CreateError, Msg: err.Error()}
+		}
+		data = s.ToBuildRole(role)
+	} else {
+		meta.Namespace = ""
+		role, err := s.ClientSet.RbacV1().ClusterRoles().Create(&rbacv1.ClusterRole{
+			ObjectMeta:      meta,
+			Rules:           params.Rules,
+			AggregationRule: params.AggregationRule,
+		})
+		if err != nil {
+			klog.Errorf("Create ClusterRole failed: %v", err)
+			return &utils.Response{Code: code.CreateError, Msg: err.Error()}
+		}
+		data = s.ToBuildClusterRole(role)
+	}
+	return &utils.Response{Code: code.Success, Msg: "Success", Data: data}
+}
+
+// UpdateObj replaces the rules, the aggregation rule and the labels of the role when given. The rules of an
+// aggregated cluster role are managed by the controller, they can not be edited.
 func (s *Role) UpdateObj(updateParams interface{}) *utils.Response {
 	params := &RoleUpdateParams{}
 	json.Unmarshal(updateParams.([]byte), params)
-	if params.Name == "" {
-		return &utils.Response{Code: code.ParamsError, Msg: "Role name is blank"}
+	if msg := checkRoleParams(params); msg != "" {
+		return &utils.Response{Code: code.ParamsError, Msg: msg}
 	}
-	if params.Namespace == "" {
-		return &utils.Response{Code: code.ParamsError, Msg: "Namespace is blank"}
+	if params.Rules == nil && params.AggregationRule == nil && params.Labels == nil {
+		return &utils.Response{Code: code.ParamsError, Msg: "Rules, aggregation rule and labels are blank"}
 	}
-	if params.Replicas < 1 {
-		return &utils.Response{Code: code.ParamsError, Msg: "Replicas is less than 1"}
+	if params.Rules != nil && params.AggregationRule != nil {
+		return &utils.Response{Code: code.ParamsError, Msg: "Rules of an aggregated cluster role can not be edited"}
 	}
 	retryErr := retry.RetryOnConflict(retry.DefaultRetry, func() error {
-		// Retrieve the latest version of Deployment before attempting update
-		// RetryOnConflict uses exponential backoff to avoid exhausting the apiserver
-		result, getErr := s.KubeClient.InformerRegistry.RoleInformer().Lister().Roles(params.Namespace).Get(params.Name)
+		if params.Kind == "Role" {
+			result, getErr := s.ClientSet.RbacV1().Roles(params.Namespace).Get(params.Name, metav1.GetOptions{})
+			if getErr != nil {
+				return getErr
+			}
+			if params.Rules != nil {
+				result.Rules = params.Rules
+			}
+			if params.Labels != nil {
+				result.Labels = params.Labels
+			}
+			_, updateErr := s.ClientSet.RbacV1().Roles(params.Namespace).Update(result)
+			return updateErr
+		}
+		result, getErr := s.ClientSet.RbacV1().ClusterRoles().Get(params.Name, metav1.GetOptions{})
 		if getErr != nil {
-			panic(fmt.Errorf("failed to get latest version of Role: %v", getErr))
+			return getErr
 		}
-
-		//result.Spec.Replicas = &params.Replicas
-		_, updateErr := s.ClientSet.RbacV1().Roles(params.Namespace).Update(result)
+		if params.Rules != nil {
+			if result.AggregationRule != nil {
+				return fmt.Errorf("rules of the aggregated cluster role %s are managed by its aggregation rule", result.Name)
+			}
+			result.Rules = params.Rules
+		}
+		if params.AggregationRule != nil {
+			result.AggregationRule = params.AggregationRule
+		}
+		if params.Labels != nil {
+			result.Labels = params.Labels
+		}
+		_, updateErr := s.ClientSet.RbacV1().ClusterRoles().Update(result)
 		return updateErr
 	})
 	if retryErr != nil {
 		klog.Errorf("Update failed: %v", retryErr)
-		return &utils.Response{Code: code.ParamsError, Msg: retryErr.Error()}
+		return &utils.Response{Code: code.UpdateError, Msg: retryErr.Error()}
 	}
 	return &utils.Response{Code: code.Success, Msg: "Success"}
 }
@@ -251,3 +333,100 @@ func (s *Role) UpdateYaml(updateParams interface{}) *utils.Response {
 	}
 	return &utils.Response{Code: code.ParamsError, Msg: "Kind parameter is not correct"}
 }
+
+func (s *Role) Delete(deleteParams interface{}) *utils.Response {
+	params := &RoleDeleteParams{}
+	json.Unmarshal(deleteParams.([]byte), params)
+	if params.Kind == "ClusterRole" {
+		return s.clusterRoleDynamic.Delete(deleteParams)
+	} else if params.Kind == "Role" {
+		return s.roleDynamic.Delete(deleteParams)
+	}
+	return &utils.Response{Code: code.ParamsError, Msg: "Kind parameter is not correct"}
+}
+
+type BuildAggregatedRole struct {
+	Name  string              `json:"name"`
+	Rules []rbacv1.PolicyRule `json:"rules"`
+}
+
+type BuildResolvedRole struct {
+	Name            string                  `json:"name"`
+	AggregationRule *rbacv1.AggregationRule `json:"aggregation_rule"`
+	Sources         []*BuildAggregatedRole  `json:"sources"`
+	Rules           []rbacv1.PolicyRule     `json:"rules"`
+}
+
+// ResolveAggregation returns the cluster roles selected by the aggregation rule and the union of their rules,
+// the roles without aggregation rule resolve to their own rules.
+func ResolveAggregation(role *rbacv1.ClusterRole, clusterRoles []*rbacv1.ClusterRole) (*BuildResolvedRole, error) {
+	res := &BuildResolvedRole{
+		Name:            role.Name,
+		AggregationRule: role.AggregationRule,
+		Sources:         []*BuildAggregatedRole{},
+		Rules:           []rbacv1.PolicyRule{},
+	}
+	if role.AggregationRule == nil {
+		res.Rules = append(res.Rules, role.Rules...)
+		return res, nil
+	}
+	var selectors []labels.Selector
+	for i := range role.AggregationRule.ClusterRoleSelectors {
+		selector, err := metav1.LabelSelectorAsSelector(&role.AggregationRule.ClusterRoleSelectors[i])
+		if err != nil {
+			return nil, err
+		}
+		selectors = append(selectors, selector)
+	}
+	sorted := append([]*rbacv1.ClusterRole{}, clusterRoles...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Name < sorted[j].Name })
+	for _, cr := range sorted {
+		if cr.Name == role.Name {
+			continue
+		}
+		for _, selector := range selectors {
+			if !selector.Matches(labels.Set(cr.Labels)) {
+				continue
+			}
+			res.Sources = append(res.Sources, &BuildAggregatedRole{Name: cr.Name, Rules: cr.Rules})
+			for _, rule := range cr.Rules {
+				if !containsRule(res.Rules, rule) {
+					res.Rules = append(res.Rules, rule)
+				}
+			}
+			break
+		}
+	}
+	return res, nil
+}
+
+func containsRule(rules []rbacv1.PolicyRule, rule rbacv1.PolicyRule) bool {
+	for _, r := range rules {
+		if reflect.DeepEqual(r, rule) {
+			return true
+		}
+	}
+	return false
+}
+
+// Resolve resolves the rules of the aggregated cluster role from the cluster roles selected.
+func (s *Role) Resolve(requestParams interface{}) *utils.Response {
+	queryParams := &RoleQueryParams{}
+	json.Unmarshal(requestParams.([]byte), queryParams)
+	if queryParams.Name == "" {
+		return &utils.Response{Code: code.ParamsError, Msg: "Role name is blank"}
+	}
+	role, err := s.ClusterRoleInformer().Lister().Get(queryParams.Name)
+	if err != nil {
+		return &utils.Response{Code: code.GetError, Msg: err.Error()}
+	}
+	clusterRoles, err := s.ClusterRoleInformer().Lister().List(labels.Everything())
+	if err != nil {
+		return &utils.Response{Code: code.ListError, Msg: err.Error()}
+	}
+	res, err := ResolveAggregation(role, clusterRoles)
+	if err != nil {
+		return &utils.Response{Code: code.ParamsError, Msg: err.Error()}
+	}
+	return &utils.Response{Code: code.Success, Msg: "Success", Data: res}
+}
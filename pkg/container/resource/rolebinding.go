@@ -7,6 +7,7 @@ import (
 	"github.com/openspacee/ospagent/pkg/utils"
 	"github.com/openspacee/ospagent/pkg/utils/code"
 	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
@@ -18,6 +19,10 @@ import (
 	"strings"
 )
 
+// GrantedBindingLabel marks the bindings created by grant, revoke only deletes these bindings when they are
+// left without subjects.
+const GrantedBindingLabel = "ospagent.openspacee.io/granted"
+
 type RoleBinding struct {
 	*kubernetes.KubeClient
 	watch                     *WatchResource
@@ -114,10 +119,25 @@ type RoleBindingQueryParams struct {
 	Output    string `json:"output"`
 }
 
+// RoleBindingUpdateParams creates a binding or replaces its subjects and labels when given, the role of a binding
+// can not be changed.
 type RoleBindingUpdateParams struct {
-	Name      string `json:"name"`
-	Namespace string `json:"namespace"`
-	Replicas  int32  `json:"replicas"`
+	Kind      string            `json:"kind"`
+	Name      string            `json:"name"`
+	Namespace string            `json:"namespace"`
+	Labels    map[string]string `json:"labels"`
+	Subjects  []rbacv1.Subject  `json:"subjects"`
+	Role      rbacv1.RoleRef    `json:"role"`
+}
+
+// GrantParams grants the role to the subject in the namespace, cluster wide with a cluster role binding when
+// the namespace is blank. The binding is named after the role and the subject when blank.
+type GrantParams struct {
+	Subject   rbacv1.Subject `json:"subject"`
+	RoleKind  string         `json:"role_kind"`
+	Role      string         `json:"role"`
+	Namespace string         `json:"namespace"`
+	Binding   string         `json:"binding"`
 }
 
 func (s *RoleBinding) List(requestParams interface{}) *utils.Response {
@@ -145,7 +165,7 @@ func (s *RoleBinding) List(requestParams interface{}) *utils.Response {
 		if queryParams.Namespace != "" && ds.Namespace != queryParams.Namespace {
 			continue
 		}
-		if queryParams.Name != "" && strings.Contains(ds.Name, queryParams.Name) {
+		if queryParams.Name != "" && !strings.Contains(ds.Name, queryParams.Name) {
 			continue
 		}
 		roleBindings = append(roleBindings, s.ToBuildRoleBinding(ds))
@@ -157,7 +177,7 @@ func (s *RoleBinding) List(requestParams interface{}) *utils.Response {
 		if queryParams.Namespace != "" && ds.Namespace != queryParams.Namespace {
 			continue
 		}
-		if queryParams.Name != "" && strings.Contains(ds.Name, queryParams.Name) {
+		if queryParams.Name != "" && !strings.Contains(ds.Name, queryParams.Name) {
 			continue
 		}
 		roleBindings = append(roleBindings, s.ToBuildClusterRoleBinding(ds))
@@ -202,48 +222,318 @@ func (s *RoleBinding) Get(requestParams interface{}) *utils.Response {
 		} else {
 			encoder = codecs.EncoderForVersion(info.Serializer, s.roleBindingDynamic.GroupVersion())
 		}
-		//klog.Info(a)
 		d, e := runtime.Encode(encoder, roleBinding)
 		if e != nil {
 			klog.Error(e)
-			return &utils.Response{Code: code.Success, Msg: e.Error()}
+			return &utils.Response{Code: code.EncodeError, Msg: e.Error()}
 		}
 		return &utils.Response{Code: code.Success, Msg: "Success", Data: string(d)}
 	}
 	return &utils.Response{Code: code.Success, Msg: "Success", Data: roleBinding}
 }
 
-func (s *RoleBinding) UpdateObj(updateParams interface{}) *utils.Response {
-	params := &RoleBindingUpdateParams{}
-	json.Unmarshal(updateParams.([]byte), params)
+func checkRoleBindingParams(params *RoleBindingUpdateParams) string {
 	if params.Name == "" {
-		return &utils.Response{Code: code.ParamsError, Msg: "RoleBinding name is blank"}
+		return "RoleBinding name is blank"
 	}
-	if params.Namespace == "" {
-		return &utils.Response{Code: code.ParamsError, Msg: "Namespace is blank"}
+	if params.Kind != "ClusterRoleBinding" && params.Kind != "RoleBinding" {
+		return "Kind is not correct"
 	}
-	if params.Replicas < 1 {
-		return &utils.Response{Code: code.ParamsError, Msg: "Replicas is less than 1"}
+	if params.Kind == "RoleBinding" && params.Namespace == "" {
+		return "Namespace is blank"
 	}
-	retryErr := retry.RetryOnConflict(retry.DefaultRetry, func() error {
-		// Retrieve the latest version of Deployment before attempting update
-		// RetryOnConflict uses exponential backoff to avoid exhausting the apiserver
-		result, getErr := s.KubeClient.InformerRegistry.RoleBindingInformer().Lister().RoleBindings(params.Namespace).Get(params.Name)
-		if getErr != nil {
-			panic(fmt.Errorf("failed to get latest version of RoleBinding: %v", getErr))
+	return ""
+}
+
+func checkRoleRef(bindingKind string, roleRef *rbacv1.RoleRef) string {
+	if roleRef.Name == "" {
+		return "Role name is blank"
+	}
+	if roleRef.Kind != "ClusterRole" && (roleRef.Kind != "Role" || bindingKind != "RoleBinding") {
+		return fmt.Sprintf("%s can not bind role kind %s", bindingKind, roleRef.Kind)
+	}
+	roleRef.APIGroup = rbacv1.GroupName
+	return ""
+}
+
+// normalizeSubject sets the api group of the subject, service accounts default to the namespace of the binding.
+func normalizeSubject(subject *rbacv1.Subject, namespace string) string {
+	if subject.Name == "" {
+		return "Subject name is blank"
+	}
+	switch subject.Kind {
+	case rbacv1.UserKind, rbacv1.GroupKind:
+		subject.APIGroup = rbacv1.GroupName
+		subject.Namespace = ""
+	case rbacv1.ServiceAccountKind:
+		subject.APIGroup = ""
+		if subject.Namespace == "" {
+			subject.Namespace = namespace
 		}
+		if subject.Namespace == "" {
+			return "Service account namespace is blank"
+		}
+	default:
+		return fmt.Sprintf("Subject kind %s is not correct", subject.Kind)
+	}
+	return ""
+}
 
-		//result.Spec.Replicas = &params.Replicas
-		_, updateErr := s.ClientSet.RbacV1().RoleBindings(params.Namespace).Update(result)
+func hasSubject(subjects []rbacv1.Subject, subject rbacv1.Subject) bool {
+	for _, s := range subjects {
+		if s.Kind == subject.Kind && s.Name == subject.Name && s.Namespace == subject.Namespace {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *RoleBinding) Create(createParams interface{}) *utils.Response {
+	params := &RoleBindingUpdateParams{}
+	json.Unmarshal(createParams.([]byte), params)
+	if msg := checkRoleBindingParams(params); msg != "" {
+		return &utils.Response{Code: code.ParamsError, Msg: msg}
+	}
+	if msg := checkRoleRef(params.Kind, &params.Role); msg != "" {
+		return &utils.Response{Code: code.ParamsError, Msg: msg}
+	}
+	for i := range params.Subjects {
+		if msg := normalizeSubject(&params.Subjects[i], params.Namespace); msg != "" {
+			return &utils.Response{Code: code.ParamsError, Msg: msg}
+		}
+	}
+	data, err := s.createBinding(params)
+	if err != nil {
+		klog.Errorf("Create %s failed: %v", params.Kind, err)
+		return &utils.Response{Code: code.CreateError, Msg: err.Error()}
+	}
+	return &utils.Response{Code: code.Success, Msg: "Success", Data: data}
+}
+
+func (s *RoleBinding) createBinding(params *RoleBindingUpdateParams) (*BuildRoleBinding, error) {
+	meta := metav1.ObjectMeta{Name: params.Name, Namespace: params.Namespace, Labels: params.Labels}
+	if params.Kind == "RoleBinding" {
+		binding, err := s.ClientSet.RbacV1().RoleBindings(params.Namespace).Create(&rbacv1.RoleBinding{
+			ObjectMeta: meta,
+			Subjects:   params.Subjects,
+			RoleRef:    params.Role,
+		})
+		if err != nil {
+			return nil, err
+		}
+		return s.ToBuildRoleBinding(binding), nil
+	}
+	meta.Namespace = ""
+	binding, err := s.ClientSet.RbacV1().ClusterRoleBindings().Create(&rbacv1.ClusterRoleBinding{
+		ObjectMeta: meta,
+		Subjects:   params.Subjects,
+		RoleRef:    params.Role,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s.ToBuildClusterRoleBinding(binding), nil
+}
+
+// updateBinding updates the subjects and the metadata of the binding of the kind with the latest version.
+func (s *RoleBinding) updateBinding(kind, namespace, name string, update func(*metav1.ObjectMeta, *rbacv1.RoleRef, []rbacv1.Subject) ([]rbacv1.Subject, error)) error {
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		if kind == "RoleBinding" {
+			result, getErr := s.ClientSet.RbacV1().RoleBindings(namespace).Get(name, metav1.GetOptions{})
+			if getErr != nil {
+				return getErr
+			}
+			subjects, err := update(&result.ObjectMeta, &result.RoleRef, result.Subjects)
+			if err != nil {
+				return err
+			}
+			result.Subjects = subjects
+			_, updateErr := s.ClientSet.RbacV1().RoleBindings(namespace).Update(result)
+			return updateErr
+		}
+		result, getErr := s.ClientSet.RbacV1().ClusterRoleBindings().Get(name, metav1.GetOptions{})
+		if getErr != nil {
+			return getErr
+		}
+		subjects, err := update(&result.ObjectMeta, &result.RoleRef, result.Subjects)
+		if err != nil {
+			return err
+		}
+		result.Subjects = subjects
+		_, updateErr := s.ClientSet.RbacV1().ClusterRoleBindings().Update(result)
 		return updateErr
 	})
+}
+
+func (s *RoleBinding) UpdateObj(updateParams interface{}) *utils.Response {
+	params := &RoleBindingUpdateParams{}
+	json.Unmarshal(updateParams.([]byte), params)
+	if msg := checkRoleBindingParams(params); msg != "" {
+		return &utils.Response{Code: code.ParamsError, Msg: msg}
+	}
+	for i := range params.Subjects {
+		if msg := normalizeSubject(&params.Subjects[i], params.Namespace); msg != "" {
+			return &utils.Response{Code: code.ParamsError, Msg: msg}
+		}
+	}
+	retryErr := s.updateBinding(params.Kind, params.Namespace, params.Name, func(meta *metav1.ObjectMeta, roleRef *rbacv1.RoleRef, subjects []rbacv1.Subject) ([]rbacv1.Subject, error) {
+		if params.Role.Name != "" && (params.Role.Name != roleRef.Name || params.Role.Kind != roleRef.Kind) {
+			return nil, fmt.Errorf("role of the binding can not be changed, delete and create the binding instead")
+		}
+		if params.Labels != nil {
+			meta.Labels = params.Labels
+		}
+		if params.Subjects != nil {
+			subjects = params.Subjects
+		}
+		return subjects, nil
+	})
 	if retryErr != nil {
 		klog.Errorf("Update failed: %v", retryErr)
-		return &utils.Response{Code: code.ParamsError, Msg: retryErr.Error()}
+		return &utils.Response{Code: code.UpdateError, Msg: retryErr.Error()}
 	}
 	return &utils.Response{Code: code.Success, Msg: "Success"}
 }
 
+func (s *RoleBinding) Delete(deleteParams interface{}) *utils.Response {
+	params := &RoleDeleteParams{}
+	json.Unmarshal(deleteParams.([]byte), params)
+	if params.Kind == "ClusterRoleBinding" {
+		return s.clusterRoleBindingDynamic.Delete(deleteParams)
+	} else if params.Kind == "RoleBinding" {
+		return s.roleBindingDynamic.Delete(deleteParams)
+	}
+	return &utils.Response{Code: code.ParamsError, Msg: "Kind parameter is not correct"}
+}
+
+func (s *RoleBinding) grantParams(requestParams interface{}) (*GrantParams, string, string) {
+	params := &GrantParams{}
+	json.Unmarshal(requestParams.([]byte), params)
+	bindingKind := "RoleBinding"
+	if params.Namespace == "" {
+		bindingKind = "ClusterRoleBinding"
+		if params.RoleKind == "" {
+			params.RoleKind = "ClusterRole"
+		}
+	}
+	roleRef := &rbacv1.RoleRef{Kind: params.RoleKind, Name: params.Role}
+	if msg := checkRoleRef(bindingKind, roleRef); msg != "" {
+		return nil, "", msg
+	}
+	if msg := normalizeSubject(&params.Subject, params.Namespace); msg != "" {
+		return nil, "", msg
+	}
+	if params.Binding == "" {
+		params.Binding = strings.ToLower(fmt.Sprintf("%s-%s-%s", params.Role, params.Subject.Kind, params.Subject.Name))
+		params.Binding = strings.NewReplacer(":", "-", "@", "-").Replace(params.Binding)
+	}
+	return params, bindingKind, ""
+}
+
+// Grant adds the subject to the binding of the role, the binding is created with GrantedBindingLabel when not found.
+func (s *RoleBinding) Grant(requestParams interface{}) *utils.Response {
+	params, bindingKind, msg := s.grantParams(requestParams)
+	if msg != "" {
+		return &utils.Response{Code: code.ParamsError, Msg: msg}
+	}
+	var err error
+	if bindingKind == "RoleBinding" {
+		_, err = s.RoleBindingInformer().Lister().RoleBindings(params.Namespace).Get(params.Binding)
+	} else {
+		_, err = s.ClusterRoleBindingInformer().Lister().Get(params.Binding)
+	}
+	if errors.IsNotFound(err) {
+		data, createErr := s.createBinding(&RoleBindingUpdateParams{
+			Kind:      bindingKind,
+			Name:      params.Binding,
+			Namespace: params.Namespace,
+			Labels:    map[string]string{GrantedBindingLabel: "true"},
+			Subjects:  []rbacv1.Subject{params.Subject},
+			Role:      rbacv1.RoleRef{Kind: params.RoleKind, Name: params.Role},
+		})
+		if createErr == nil {
+			return &utils.Response{Code: code.Success, Msg: "Success", Data: data}
+		}
+		// the binding is created after the cache is synced, the subject is added to it below
+		if !errors.IsAlreadyExists(createErr) {
+			klog.Errorf("Grant failed: %v", createErr)
+			return &utils.Response{Code: code.CreateError, Msg: createErr.Error()}
+		}
+	} else if err != nil {
+		return &utils.Response{Code: code.GetError, Msg: err.Error()}
+	}
+	err = s.updateBinding(bindingKind, params.Namespace, params.Binding, func(_ *metav1.ObjectMeta, roleRef *rbacv1.RoleRef, subjects []rbacv1.Subject) ([]rbacv1.Subject, error) {
+		if roleRef.Kind != params.RoleKind || roleRef.Name != params.Role {
+			return nil, fmt.Errorf("binding %s is bound to %s %s", params.Binding, roleRef.Kind, roleRef.Name)
+		}
+		if hasSubject(subjects, params.Subject) {
+			return subjects, nil
+		}
+		return append(subjects, params.Subject), nil
+	})
+	if err != nil {
+		klog.Errorf("Grant failed: %v", err)
+		return &utils.Response{Code: code.UpdateError, Msg: err.Error()}
+	}
+	return &utils.Response{Code: code.Success, Msg: "Success"}
+}
+
+// Revoke removes the subject from the bindings of the role in the namespace, cluster wide when the namespace is blank.
+// The bindings created by grant are deleted when they are left without subjects, other bindings are kept.
+func (s *RoleBinding) Revoke(requestParams interface{}) *utils.Response {
+	params, bindingKind, msg := s.grantParams(requestParams)
+	if msg != "" {
+		return &utils.Response{Code: code.ParamsError, Msg: msg}
+	}
+	var bindings []string
+	if bindingKind == "RoleBinding" {
+		list, err := s.RoleBindingInformer().Lister().RoleBindings(params.Namespace).List(labels.Everything())
+		if err != nil {
+			return &utils.Response{Code: code.ListError, Msg: err.Error()}
+		}
+		for _, rb := range list {
+			if rb.RoleRef.Kind == params.RoleKind && rb.RoleRef.Name == params.Role && hasSubject(rb.Subjects, params.Subject) {
+				bindings = append(bindings, rb.Name)
+			}
+		}
+	} else {
+		list, err := s.ClusterRoleBindingInformer().Lister().List(labels.Everything())
+		if err != nil {
+			return &utils.Response{Code: code.ListError, Msg: err.Error()}
+		}
+		for _, crb := range list {
+			if crb.RoleRef.Name == params.Role && hasSubject(crb.Subjects, params.Subject) {
+				bindings = append(bindings, crb.Name)
+			}
+		}
+	}
+	for _, name := range bindings {
+		deleteBinding := false
+		err := s.updateBinding(bindingKind, params.Namespace, name, func(meta *metav1.ObjectMeta, _ *rbacv1.RoleRef, subjects []rbacv1.Subject) ([]rbacv1.Subject, error) {
+			var left []rbacv1.Subject
+			for _, subject := range subjects {
+				if !hasSubject([]rbacv1.Subject{params.Subject}, subject) {
+					left = append(left, subject)
+				}
+			}
+			deleteBinding = len(left) == 0 && meta.Labels[GrantedBindingLabel] == "true"
+			return left, nil
+		})
+		if err == nil && deleteBinding {
+			if bindingKind == "RoleBinding" {
+				err = s.ClientSet.RbacV1().RoleBindings(params.Namespace).Delete(name, &metav1.DeleteOptions{})
+			} else {
+				err = s.ClientSet.RbacV1().ClusterRoleBindings().Delete(name, &metav1.DeleteOptions{})
+			}
+		}
+		if err != nil {
+			klog.Errorf("Revoke failed: %v", err)
+			return &utils.Response{Code: code.UpdateError, Msg: err.Error()}
+		}
+	}
+	return &utils.Response{Code: code.Success, Msg: "Success", Data: bindings}
+}
+
 func (s *RoleBinding) UpdateYaml(updateParams interface{}) *utils.Response {
 	params := &DynamicUpdateParams{}
 	json.Unmarshal(updateParams.([]byte), params)
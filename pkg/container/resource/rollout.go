@@ -0,0 +1,397 @@
+package resource
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/openspacee/ospagent/pkg/kubernetes"
+	"github.com/openspacee/ospagent/pkg/utils"
+	"github.com/openspacee/ospagent/pkg/utils/code"
+	"github.com/openspacee/ospagent/pkg/websocket"
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog"
+	"sigs.k8s.io/yaml"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	DeploymentKind  = "deployment"
+	StatefulSetKind = "statefulset"
+	DaemonSetKind   = "daemonset"
+
+	restartedAtAnnotation = "kubectl.kubernetes.io/restartedAt"
+	changeCauseAnnotation = "kubernetes.io/change-cause"
+	revisionAnnotation    = "deployment.kubernetes.io/revision"
+)
+
+type Rollout struct {
+	*kubernetes.KubeClient
+	sendResponse websocket.SendResponse
+}
+
+func NewRollout(kubeClient *kubernetes.KubeClient, sendResponse websocket.SendResponse) *Rollout {
+	return &Rollout{KubeClient: kubeClient, sendResponse: sendResponse}
+}
+
+type RolloutParams struct {
+	Kind      string `json:"kind"`
+	Name      string `json:"name"`
+	Namespace string `json:"namespace"`
+	// Revision is the revision to undo to, the previous revision when 0.
+	Revision int64 `json:"revision"`
+}
+
+type BuildRevision struct {
+	Revision    int64       `json:"revision"`
+	Name        string      `json:"name"`
+	ChangeCause string      `json:"change_cause"`
+	Created     metav1.Time `json:"created"`
+	Current     bool        `json:"current"`
+	// Diff is the pod template diff from the previous revision.
+	Diff string `json:"diff"`
+}
+
+type revision struct {
+	BuildRevision
+	template *v1.PodTemplateSpec
+	// patch restores the statefulset and daemonset template, deployments are restored from the template
+	patch []byte
+}
+
+func parseRolloutParams(requestParams interface{}) (*RolloutParams, *utils.Response) {
+	params := &RolloutParams{}
+	json.Unmarshal(requestParams.([]byte), params)
+	if params.Name == "" {
+		return nil, &utils.Response{Code: code.ParamsError, Msg: "Name is blank"}
+	}
+	if params.Namespace == "" {
+		return nil, &utils.Response{Code: code.ParamsError, Msg: "Namespace is blank"}
+	}
+	switch params.Kind {
+	case DeploymentKind, StatefulSetKind, DaemonSetKind:
+	default:
+		return nil, &utils.Response{Code: code.ParamsError, Msg: "Unknown workload kind " + params.Kind}
+	}
+	return params, nil
+}
+
+func (r *Rollout) patch(params *RolloutParams, pt types.PatchType, data []byte) error {
+	var err error
+	switch params.Kind {
+	case DeploymentKind:
+		_, err = r.ClientSet.AppsV1().Deployments(params.Namespace).Patch(params.Name, pt, data)
+	case StatefulSetKind:
+		_, err = r.ClientSet.AppsV1().StatefulSets(params.Namespace).Patch(params.Name, pt, data)
+	case DaemonSetKind:
+		_, err = r.ClientSet.AppsV1().DaemonSets(params.Namespace).Patch(params.Name, pt, data)
+	}
+	return err
+}
+
+// Restart restarts the pods of the workload like kubectl rollout restart, by the restartedAt annotation
+// of the pod template.
+func (r *Rollout) Restart(requestParams interface{}) *utils.Response {
+	params, resp := parseRolloutParams(requestParams)
+	if resp != nil {
+		return resp
+	}
+	if params.Kind == DeploymentKind {
+		dp, err := r.DeploymentInformer().Lister().Deployments(params.Namespace).Get(params.Name)
+		if err != nil {
+			return &utils.Response{Code: code.GetError, Msg: err.Error()}
+		}
+		if dp.Spec.Paused {
+			return &utils.Response{Code: code.ParamsError, Msg: "Cannot restart paused deployment, resume it first"}
+		}
+	}
+	patch := fmt.Sprintf(`{"spec":{"template":{"metadata":{"annotations":{%q:%q}}}}}`,
+		restartedAtAnnotation, time.Now().Format(time.RFC3339))
+	if err := r.patch(params, types.StrategicMergePatchType, []byte(patch)); err != nil {
+		return &utils.Response{Code: code.UpdateError, Msg: err.Error()}
+	}
+	return &utils.Response{Code: code.Success, Msg: "Success"}
+}
+
+func (r *Rollout) setPaused(requestParams interface{}, paused bool) *utils.Response {
+	params, resp := parseRolloutParams(requestParams)
+	if resp != nil {
+		return resp
+	}
+	if params.Kind != DeploymentKind {
+		return &utils.Response{Code: code.ParamsError, Msg: "Only deployment can be paused and resumed"}
+	}
+	patch := fmt.Sprintf(`{"spec":{"paused":%t}}`, paused)
+	if err := r.patch(params, types.StrategicMergePatchType, []byte(patch)); err != nil {
+		return &utils.Response{Code: code.UpdateError, Msg: err.Error()}
+	}
+	return &utils.Response{Code: code.Success, Msg: "Success"}
+}
+
+func (r *Rollout) Pause(requestParams interface{}) *utils.Response {
+	return r.setPaused(requestParams, true)
+}
+
+func (r *Rollout) Resume(requestParams interface{}) *utils.Response {
+	return r.setPaused(requestParams, false)
+}
+
+func (r *Rollout) History(requestParams interface{}) *utils.Response {
+	params, resp := parseRolloutParams(requestParams)
+	if resp != nil {
+		return resp
+	}
+	revisions, err := r.revisions(params)
+	if err != nil {
+		return &utils.Response{Code: code.ListError, Msg: err.Error()}
+	}
+	var res []*BuildRevision
+	for _, rev := range revisions {
+		res = append(res, &rev.BuildRevision)
+	}
+	return &utils.Response{Code: code.Success, Msg: "Success", Data: res}
+}
+
+// Undo rolls the workload back to the pod template of the revision, which becomes a new revision.
+func (r *Rollout) Undo(requestParams interface{}) *utils.Response {
+	params, resp := parseRolloutParams(requestParams)
+	if resp != nil {
+		return resp
+	}
+	revisions, err := r.revisions(params)
+	if err != nil {
+		return &utils.Response{Code: code.ListError, Msg: err.Error()}
+	}
+	var target *revision
+	if params.Revision == 0 {
+		// the previous revision is the latest one before the current
+		for i := len(revisions) - 1; i >= 0; i-- {
+			if !revisions[i].Current {
+				target = revisions[i]
+				break
+			}
+		}
+		if target == nil {
+			return &utils.Response{Code: code.ParamsError, Msg: "No previous revision to undo to"}
+		}
+	} else {
+		for _, rev := range revisions {
+			if rev.Revision == params.Revision {
+				target = rev
+			}
+		}
+		if target == nil {
+			return &utils.Response{Code: code.ParamsError, Msg: fmt.Sprintf("Revision %d not found", params.Revision)}
+		}
+	}
+	if target.Current {
+		return &utils.Response{Code: code.Success, Msg: "Skipped, the revision is the current template"}
+	}
+	if params.Kind == DeploymentKind {
+		err = r.undoDeployment(params, target)
+	} else {
+		err = r.patch(params, types.StrategicMergePatchType, target.patch)
+	}
+	if err != nil {
+		return &utils.Response{Code: code.UpdateError, Msg: err.Error()}
+	}
+	return &utils.Response{Code: code.Success, Msg: fmt.Sprintf("Rolled back to revision %d", target.Revision)}
+}
+
+func (r *Rollout) undoDeployment(params *RolloutParams, target *revision) error {
+	dp, err := r.ClientSet.AppsV1().Deployments(params.Namespace).Get(params.Name, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	if dp.Spec.Paused {
+		return fmt.Errorf("cannot undo paused deployment, resume it first")
+	}
+	template := target.template.DeepCopy()
+	delete(template.Labels, appsv1.DefaultDeploymentUniqueLabelKey)
+	// the resource version fails the patch when the deployment is changed meanwhile
+	patch, err := json.Marshal([]map[string]interface{}{
+		{"op": "test", "path": "/metadata/resourceVersion", "value": dp.ResourceVersion},
+		{"op": "replace", "path": "/spec/template", "value": template},
+	})
+	if err != nil {
+		return err
+	}
+	return r.patch(params, types.JSONPatchType, patch)
+}
+
+// revisions lists the revisions of the workload by revision, with the diffs from their previous revisions.
+func (r *Rollout) revisions(params *RolloutParams) ([]*revision, error) {
+	var revisions []*revision
+	var err error
+	if params.Kind == DeploymentKind {
+		revisions, err = r.deploymentRevisions(params)
+	} else {
+		revisions, err = r.controllerRevisions(params)
+	}
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(revisions, func(i, j int) bool {
+		return revisions[i].Revision < revisions[j].Revision
+	})
+	var previous *v1.PodTemplateSpec
+	for _, rev := range revisions {
+		rev.Diff = templateDiff(previous, rev.template)
+		previous = rev.template
+	}
+	return revisions, nil
+}
+
+func (r *Rollout) deploymentRevisions(params *RolloutParams) ([]*revision, error) {
+	dp, err := r.ClientSet.AppsV1().Deployments(params.Namespace).Get(params.Name, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	selector, err := metav1.LabelSelectorAsSelector(dp.Spec.Selector)
+	if err != nil {
+		return nil, err
+	}
+	rsList, err := r.ReplicaSetInformer().Lister().ReplicaSets(params.Namespace).List(selector)
+	if err != nil {
+		return nil, err
+	}
+	currentRevision := dp.Annotations[revisionAnnotation]
+	var revisions []*revision
+	for _, rs := range rsList {
+		if !metav1.IsControlledBy(rs, dp) {
+			continue
+		}
+		number, err := strconv.ParseInt(rs.Annotations[revisionAnnotation], 10, 64)
+		if err != nil {
+			klog.Warningf("replicaset %s/%s revision error: %v", rs.Namespace, rs.Name, err)
+			continue
+		}
+		template := rs.Spec.Template.DeepCopy()
+		delete(template.Labels, appsv1.DefaultDeploymentUniqueLabelKey)
+		revisions = append(revisions, &revision{
+			BuildRevision: BuildRevision{
+				Revision:    number,
+				Name:        rs.Name,
+				ChangeCause: rs.Annotations[changeCauseAnnotation],
+				Created:     rs.CreationTimestamp,
+				Current:     rs.Annotations[revisionAnnotation] == currentRevision,
+			},
+			template: template,
+		})
+	}
+	return revisions, nil
+}
+
+// controllerRevisions lists the revisions of statefulsets and daemonsets, the data of a controller revision
+// is a patch replacing the pod template.
+func (r *Rollout) controllerRevisions(params *RolloutParams) ([]*revision, error) {
+	var owner metav1.Object
+	var labelSelector *metav1.LabelSelector
+	var currentRevision string
+	if params.Kind == StatefulSetKind {
+		ss, err := r.ClientSet.AppsV1().StatefulSets(params.Namespace).Get(params.Name, metav1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+		owner, labelSelector, currentRevision = ss, ss.Spec.Selector, ss.Status.UpdateRevision
+	} else {
+		ds, err := r.ClientSet.AppsV1().DaemonSets(params.Namespace).Get(params.Name, metav1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+		owner, labelSelector = ds, ds.Spec.Selector
+	}
+	selector, err := metav1.LabelSelectorAsSelector(labelSelector)
+	if err != nil {
+		return nil, err
+	}
+	crList, err := r.ControllerRevisionInformer().Lister().ControllerRevisions(params.Namespace).List(selector)
+	if err != nil {
+		return nil, err
+	}
+	var revisions []*revision
+	var latest *revision
+	for _, cr := range crList {
+		if !metav1.IsControlledBy(cr, owner) {
+			continue
+		}
+		data := &struct {
+			Spec struct {
+				Template v1.PodTemplateSpec `json:"template"`
+			} `json:"spec"`
+		}{}
+		if err := json.Unmarshal(cr.Data.Raw, data); err != nil {
+			klog.Warningf("controller revision %s/%s data error: %v", cr.Namespace, cr.Name, err)
+			continue
+		}
+		rev := &revision{
+			BuildRevision: BuildRevision{
+				Revision:    cr.Revision,
+				Name:        cr.Name,
+				ChangeCause: cr.Annotations[changeCauseAnnotation],
+				Created:     cr.CreationTimestamp,
+				Current:     cr.Name == currentRevision,
+			},
+			template: &data.Spec.Template,
+			patch:    cr.Data.Raw,
+		}
+		if latest == nil || rev.Revision > latest.Revision {
+			latest = rev
+		}
+		revisions = append(revisions, rev)
+	}
+	// daemonsets have no update revision in the status, the latest revision is the current template
+	if currentRevision == "" && latest != nil {
+		latest.Current = true
+	}
+	return revisions, nil
+}
+
+// templateDiff is a line diff of the yaml of the templates, the removed lines are prefixed with "-" and
+// the added lines with "+".
+func templateDiff(old, new *v1.PodTemplateSpec) string {
+	var oldLines, newLines []string
+	if old != nil {
+		data, _ := yaml.Marshal(old)
+		oldLines = strings.Split(strings.TrimSpace(string(data)), "\n")
+	}
+	if new != nil {
+		data, _ := yaml.Marshal(new)
+		newLines = strings.Split(strings.TrimSpace(string(data)), "\n")
+	}
+	// longest common subsequence of the lines
+	lcs := make([][]int, len(oldLines)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(newLines)+1)
+	}
+	for i := len(oldLines) - 1; i >= 0; i-- {
+		for j := len(newLines) - 1; j >= 0; j-- {
+			if oldLines[i] == newLines[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+	var diff []string
+	i, j := 0, 0
+	for i < len(oldLines) || j < len(newLines) {
+		switch {
+		case i < len(oldLines) && j < len(newLines) && oldLines[i] == newLines[j]:
+			i, j = i+1, j+1
+		case j < len(newLines) && (i == len(oldLines) || lcs[i][j+1] > lcs[i+1][j]):
+			diff = append(diff, "+"+newLines[j])
+			j += 1
+		default:
+			diff = append(diff, "-"+oldLines[i])
+			i += 1
+		}
+	}
+	return strings.Join(diff, "\n")
+}
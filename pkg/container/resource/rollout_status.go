@@ -0,0 +1,299 @@
+package resource
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/openspacee/ospagent/pkg/utils"
+	"github.com/openspacee/ospagent/pkg/utils/code"
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog"
+	"reflect"
+	"sort"
+	"time"
+)
+
+const (
+	defaultRolloutTimeout = 600
+	rolloutPollPeriod     = 2 * time.Second
+	// a pod not ready longer than this in a rollout is reported stuck
+	podStuckAfter   = 30 * time.Second
+	stuckPodEvents  = 3
+	maxStuckPods    = 5
+	progressTimeout = "ProgressDeadlineExceeded"
+)
+
+type RolloutStatusParams struct {
+	Kind      string `json:"kind"`
+	Name      string `json:"name"`
+	Namespace string `json:"namespace"`
+	Timeout   int    `json:"timeout"`
+}
+
+type StuckPod struct {
+	Name    string   `json:"name"`
+	Reason  string   `json:"reason"`
+	Message string   `json:"message"`
+	Events  []string `json:"events"`
+}
+
+type RolloutProgress struct {
+	Msg       string      `json:"msg"`
+	Replicas  int32       `json:"replicas"`
+	Updated   int32       `json:"updated"`
+	Ready     int32       `json:"ready"`
+	Available int32       `json:"available"`
+	Done      bool        `json:"done"`
+	StuckPods []*StuckPod `json:"stuck_pods"`
+}
+
+// rolloutState is the progress of the workload in the informer cache, selector selects its pods.
+type rolloutState struct {
+	RolloutProgress
+	selector *metav1.LabelSelector
+	failed   error
+}
+
+// Status follows the rollout of the workload in the informer cache until it completes, fails or times out,
+// the progress is sent with the request id when it changes.
+func (r *Rollout) Status(requestId string, requestParams interface{}) *utils.Response {
+	params := &RolloutStatusParams{}
+	json.Unmarshal(requestParams.([]byte), params)
+	if _, resp := parseRolloutParams(requestParams); resp != nil {
+		return resp
+	}
+	if params.Timeout <= 0 {
+		params.Timeout = defaultRolloutTimeout
+	}
+	var last *RolloutProgress
+	var state *rolloutState
+	err := wait.PollImmediate(rolloutPollPeriod, time.Duration(params.Timeout)*time.Second, func() (bool, error) {
+		var err error
+		state, err = r.rolloutState(params)
+		if err != nil {
+			return false, err
+		}
+		if state.failed != nil {
+			return false, state.failed
+		}
+		if !state.Done && state.selector != nil {
+			state.StuckPods = r.stuckPods(params.Namespace, state.selector)
+		}
+		if last == nil || !reflect.DeepEqual(*last, state.RolloutProgress) {
+			progress := state.RolloutProgress
+			last = &progress
+			r.sendResponse(&progress, requestId, utils.RolloutProgressType)
+		}
+		return state.Done, nil
+	})
+	if err == wait.ErrWaitTimeout {
+		msg := "Timeout waiting for rollout"
+		if state != nil {
+			msg += ": " + state.Msg
+		}
+		return &utils.Response{Code: code.RolloutError, Msg: msg, Data: last}
+	}
+	if err != nil {
+		return &utils.Response{Code: code.RolloutError, Msg: err.Error(), Data: last}
+	}
+	return &utils.Response{Code: code.Success, Msg: state.Msg, Data: last}
+}
+
+func (r *Rollout) rolloutState(params *RolloutStatusParams) (*rolloutState, error) {
+	switch params.Kind {
+	case DeploymentKind:
+		dp, err := r.DeploymentInformer().Lister().Deployments(params.Namespace).Get(params.Name)
+		if err != nil {
+			return nil, err
+		}
+		return deploymentRolloutState(dp), nil
+	case StatefulSetKind:
+		ss, err := r.StatefulSetInformer().Lister().StatefulSets(params.Namespace).Get(params.Name)
+		if err != nil {
+			return nil, err
+		}
+		return statefulSetRolloutState(ss), nil
+	default:
+		ds, err := r.DaemonSetInformer().Lister().DaemonSets(params.Namespace).Get(params.Name)
+		if err != nil {
+			return nil, err
+		}
+		return daemonSetRolloutState(ds), nil
+	}
+}
+
+// deploymentRolloutState follows kubectl rollout status of deployments.
+func deploymentRolloutState(dp *appsv1.Deployment) *rolloutState {
+	replicas := int32(1)
+	if dp.Spec.Replicas != nil {
+		replicas = *dp.Spec.Replicas
+	}
+	s := &rolloutState{selector: dp.Spec.Selector}
+	s.Replicas = replicas
+	s.Updated = dp.Status.UpdatedReplicas
+	s.Ready = dp.Status.ReadyReplicas
+	s.Available = dp.Status.AvailableReplicas
+	if dp.Generation > dp.Status.ObservedGeneration {
+		s.Msg = "Waiting for deployment spec update to be observed"
+		return s
+	}
+	for _, c := range dp.Status.Conditions {
+		if c.Type == appsv1.DeploymentProgressing && c.Reason == progressTimeout {
+			s.failed = fmt.Errorf("deployment %s exceeded its progress deadline: %s", dp.Name, c.Message)
+			return s
+		}
+	}
+	switch {
+	case s.Updated < replicas:
+		s.Msg = fmt.Sprintf("Waiting for rollout to finish: %d out of %d new replicas have been updated", s.Updated, replicas)
+	case dp.Status.Replicas > s.Updated:
+		s.Msg = fmt.Sprintf("Waiting for rollout to finish: %d old replicas are pending termination", dp.Status.Replicas-s.Updated)
+	case s.Available < s.Updated:
+		s.Msg = fmt.Sprintf("Waiting for rollout to finish: %d of %d updated replicas are available", s.Available, s.Updated)
+	default:
+		s.Msg = fmt.Sprintf("Deployment %s successfully rolled out", dp.Name)
+		s.Done = true
+	}
+	return s
+}
+
+func statefulSetRolloutState(ss *appsv1.StatefulSet) *rolloutState {
+	replicas := int32(1)
+	if ss.Spec.Replicas != nil {
+		replicas = *ss.Spec.Replicas
+	}
+	s := &rolloutState{selector: ss.Spec.Selector}
+	s.Replicas = replicas
+	s.Updated = ss.Status.UpdatedReplicas
+	s.Ready = ss.Status.ReadyReplicas
+	s.Available = ss.Status.ReadyReplicas
+	if ss.Spec.UpdateStrategy.Type != appsv1.RollingUpdateStatefulSetStrategyType {
+		s.failed = fmt.Errorf("rollout status is only available for %s strategy", appsv1.RollingUpdateStatefulSetStrategyType)
+		return s
+	}
+	if ss.Status.ObservedGeneration == 0 || ss.Generation > ss.Status.ObservedGeneration {
+		s.Msg = "Waiting for statefulset spec update to be observed"
+		return s
+	}
+	if s.Ready < replicas {
+		s.Msg = fmt.Sprintf("Waiting for %d pods to be ready", replicas-s.Ready)
+		return s
+	}
+	if rollingUpdate := ss.Spec.UpdateStrategy.RollingUpdate; rollingUpdate != nil && rollingUpdate.Partition != nil {
+		partitioned := replicas - *rollingUpdate.Partition
+		if s.Updated < partitioned {
+			s.Msg = fmt.Sprintf("Waiting for partitioned roll out to finish: %d out of %d new pods have been updated", s.Updated, partitioned)
+			return s
+		}
+		s.Msg = fmt.Sprintf("Partitioned roll out complete: %d new pods have been updated", s.Updated)
+		s.Done = true
+		return s
+	}
+	if ss.Status.UpdateRevision != ss.Status.CurrentRevision {
+		s.Msg = fmt.Sprintf("Waiting for statefulset rolling update to complete %d pods at revision %s", s.Updated, ss.Status.UpdateRevision)
+		return s
+	}
+	s.Msg = fmt.Sprintf("Statefulset rolling update complete %d pods at revision %s", ss.Status.CurrentReplicas, ss.Status.CurrentRevision)
+	s.Done = true
+	return s
+}
+
+func daemonSetRolloutState(ds *appsv1.DaemonSet) *rolloutState {
+	s := &rolloutState{selector: ds.Spec.Selector}
+	s.Replicas = ds.Status.DesiredNumberScheduled
+	s.Updated = ds.Status.UpdatedNumberScheduled
+	s.Ready = ds.Status.NumberReady
+	s.Available = ds.Status.NumberAvailable
+	if ds.Spec.UpdateStrategy.Type != appsv1.RollingUpdateDaemonSetStrategyType {
+		s.failed = fmt.Errorf("rollout status is only available for %s strategy", appsv1.RollingUpdateDaemonSetStrategyType)
+		return s
+	}
+	switch {
+	case ds.Generation > ds.Status.ObservedGeneration:
+		s.Msg = "Waiting for daemon set spec update to be observed"
+	case s.Updated < s.Replicas:
+		s.Msg = fmt.Sprintf("Waiting for daemon set %q rollout to finish: %d out of %d new pods have been updated", ds.Name, s.Updated, s.Replicas)
+	case s.Available < s.Replicas:
+		s.Msg = fmt.Sprintf("Waiting for daemon set %q rollout to finish: %d of %d updated pods are available", ds.Name, s.Available, s.Replicas)
+	default:
+		s.Msg = fmt.Sprintf("Daemon set %q successfully rolled out", ds.Name)
+		s.Done = true
+	}
+	return s
+}
+
+// stuckPods lists the pods of the workload not ready for a while, with the reason and their latest events.
+func (r *Rollout) stuckPods(namespace string, labelSelector *metav1.LabelSelector) []*StuckPod {
+	selector, err := metav1.LabelSelectorAsSelector(labelSelector)
+	if err != nil {
+		return nil
+	}
+	pods, err := r.PodInformer().Lister().Pods(namespace).List(selector)
+	if err != nil {
+		klog.Errorf("list pods error: %v", err)
+		return nil
+	}
+	sort.Slice(pods, func(i, j int) bool {
+		return pods[i].CreationTimestamp.Before(&pods[j].CreationTimestamp)
+	})
+	var stuck []*StuckPod
+	for _, pod := range pods {
+		if pod.DeletionTimestamp != nil || podReady(pod) || time.Since(pod.CreationTimestamp.Time) < podStuckAfter {
+			continue
+		}
+		sp := &StuckPod{Name: pod.Name, Reason: string(pod.Status.Phase), Message: pod.Status.Message}
+		for _, c := range pod.Status.Conditions {
+			if c.Type == v1.PodScheduled && c.Status != v1.ConditionTrue {
+				sp.Reason, sp.Message = c.Reason, c.Message
+			}
+		}
+		for _, s := range pod.Status.ContainerStatuses {
+			if s.State.Waiting != nil && s.State.Waiting.Reason != "" {
+				sp.Reason, sp.Message = s.State.Waiting.Reason, s.State.Waiting.Message
+				break
+			}
+		}
+		sp.Events = r.latestEvents(pod)
+		stuck = append(stuck, sp)
+		if len(stuck) >= maxStuckPods {
+			break
+		}
+	}
+	return stuck
+}
+
+func podReady(pod *v1.Pod) bool {
+	for _, c := range pod.Status.Conditions {
+		if c.Type == v1.PodReady {
+			return c.Status == v1.ConditionTrue
+		}
+	}
+	return false
+}
+
+func (r *Rollout) latestEvents(pod *v1.Pod) []string {
+	events, err := r.EventInformer().Lister().Events(pod.Namespace).List(labels.Everything())
+	if err != nil {
+		return nil
+	}
+	var podEvents []*v1.Event
+	for _, e := range events {
+		if e.InvolvedObject.Kind == "Pod" && e.InvolvedObject.Name == pod.Name && e.InvolvedObject.UID == pod.UID {
+			podEvents = append(podEvents, e)
+		}
+	}
+	sort.Slice(podEvents, func(i, j int) bool {
+		return podEvents[i].LastTimestamp.Before(&podEvents[j].LastTimestamp)
+	})
+	if len(podEvents) > stuckPodEvents {
+		podEvents = podEvents[len(podEvents)-stuckPodEvents:]
+	}
+	var res []string
+	for _, e := range podEvents {
+		res = append(res, fmt.Sprintf("%s %s: %s", e.Type, e.Reason, e.Message))
+	}
+	return res
+}
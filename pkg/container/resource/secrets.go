@@ -1,26 +1,29 @@
 package resource
 
 import (
+	"crypto/tls"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"github.com/openspacee/ospagent/pkg/kubernetes"
 	"github.com/openspacee/ospagent/pkg/utils"
 	"github.com/openspacee/ospagent/pkg/utils/code"
 	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/runtime/serializer"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/retry"
 	"k8s.io/klog"
+	"sort"
+	"strings"
+	"unicode/utf8"
 )
 
-//type Secret struct {
-//	*kubernetes.KubeClient
-//	websocket.SendResponse
-//	*DynamicResource
-//}
-
+// Secret never returns the secret values except from Reveal, the values are redacted from list, get and watch.
 type Secret struct {
 	watch *WatchResource
 	*DynamicResource
@@ -30,16 +33,76 @@ type BuildSecret struct {
 	Name       string            `json:"name"`
 	NameSpace  string            `json:"namespace"`
 	Keys       []string          `json:"keys"`
+	Sizes      map[string]int    `json:"sizes"`
 	Labels     map[string]string `json:"labels"`
 	CreateTime string            `json:"create_time"`
 	Type       v1.SecretType     `json:"type"`
-	Data       map[string][]byte `json:"data"`
 }
 
 type SecretQueryParams struct {
-	Name      string `json:"name"`
-	Namespace string `json:"namespace"`
-	Output    string `json:"output"`
+	Name      string        `json:"name"`
+	Namespace string        `json:"namespace"`
+	Output    string        `json:"output"`
+	Type      v1.SecretType `json:"type"`
+}
+
+type DockerRegistryParams struct {
+	Server   string `json:"server"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+	Email    string `json:"email"`
+}
+
+type TLSParams struct {
+	Cert string `json:"cert"`
+	Key  string `json:"key"`
+}
+
+type BasicAuthParams struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// SecretUpdateParams creates or updates the secret, the data is built from the typed params when given.
+type SecretUpdateParams struct {
+	Name           string                `json:"name"`
+	Namespace      string                `json:"namespace"`
+	Type           v1.SecretType         `json:"type"`
+	Labels         map[string]string     `json:"labels"`
+	Data           map[string][]byte     `json:"data"`
+	StringData     map[string]string     `json:"string_data"`
+	DockerRegistry *DockerRegistryParams `json:"docker_registry"`
+	TLS            *TLSParams            `json:"tls"`
+	BasicAuth      *BasicAuthParams      `json:"basic_auth"`
+}
+
+// SecretKeysParams sets the keys of data, or removes the keys.
+type SecretKeysParams struct {
+	Name       string            `json:"name"`
+	Namespace  string            `json:"namespace"`
+	Data       map[string][]byte `json:"data"`
+	StringData map[string]string `json:"string_data"`
+	Keys       []string          `json:"keys"`
+}
+
+// SecretRevealParams reveals the secret values, the user requesting them and the reason are required for audit.
+type SecretRevealParams struct {
+	Name      string   `json:"name"`
+	Namespace string   `json:"namespace"`
+	Keys      []string `json:"keys"`
+	Reason    string   `json:"reason"`
+	User      string   `json:"user"`
+}
+
+const (
+	secretRevealedEventReason = "SecretRevealed"
+	auditEventComponent       = "ospagent"
+)
+
+// BuildSecretValues holds the revealed values, the values not valid utf8 are in binary.
+type BuildSecretValues struct {
+	Values map[string]string `json:"values"`
+	Binary map[string][]byte `json:"binary"`
 }
 
 func (s *Secret) ToBuildSecret(se *v1.Secret) *BuildSecret {
@@ -53,11 +116,27 @@ func (s *Secret) ToBuildSecret(se *v1.Secret) *BuildSecret {
 		Labels:     se.Labels,
 		Type:       se.Type,
 		CreateTime: fmt.Sprint(se.CreationTimestamp),
-		Data:       se.Data,
+		Sizes:      make(map[string]int, len(se.Data)),
+	}
+	keys := make([]string, 0, len(se.Data))
+	for k, v := range se.Data {
+		keys = append(keys, k)
+		sData.Sizes[k] = len(v)
 	}
+	sort.Strings(keys)
+	sData.Keys = keys
 	return sData
 }
 
+// redactSecret returns a copy of the secret without the values, the last applied configuration holds them too.
+func redactSecret(se *v1.Secret) *v1.Secret {
+	redacted := se.DeepCopy()
+	redacted.Data = nil
+	redacted.StringData = nil
+	delete(redacted.Annotations, v1.LastAppliedConfigAnnotation)
+	return redacted
+}
+
 func NewSecret(kubeClient *kubernetes.KubeClient, watch *WatchResource) *Secret {
 	s := &Secret{
 		watch: watch,
@@ -71,17 +150,26 @@ func NewSecret(kubeClient *kubernetes.KubeClient, watch *WatchResource) *Secret
 	return s
 }
 
+func (s *Secret) watchTransform(obj interface{}) interface{} {
+	if se, ok := obj.(*v1.Secret); ok {
+		return s.ToBuildSecret(se)
+	}
+	return nil
+}
+
 func (s *Secret) DoWatch() {
 	informer := s.KubeClient.SecretInformer().Informer()
 	informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
-		AddFunc:    s.watch.WatchAdd(utils.WatchService),
-		UpdateFunc: s.watch.WatchUpdate(utils.WatchService),
-		DeleteFunc: s.watch.WatchDelete(utils.WatchService),
+		AddFunc:    s.watch.WatchAddTransform(utils.WatchSecret, s.watchTransform),
+		UpdateFunc: s.watch.WatchUpdateTransform(utils.WatchSecret, s.watchTransform),
+		DeleteFunc: s.watch.WatchDeleteTransform(utils.WatchSecret, s.watchTransform),
 	})
 }
 
 func (s *Secret) List(requestParams interface{}) *utils.Response {
-	secretList, err := s.KubeClient.InformerRegistry.SecretInformer().Lister().List(labels.Everything())
+	queryParams := &SecretQueryParams{}
+	json.Unmarshal(requestParams.([]byte), queryParams)
+	secretList, err := s.KubeClient.SecretInformer().Lister().Secrets(queryParams.Namespace).List(labels.Everything())
 	if err != nil {
 		return &utils.Response{
 			Code: code.ListError,
@@ -89,8 +177,14 @@ func (s *Secret) List(requestParams interface{}) *utils.Response {
 		}
 	}
 	var secretResource []*BuildSecret
-	for _, cm := range secretList {
-		secretResource = append(secretResource, s.ToBuildSecret(cm))
+	for _, se := range secretList {
+		if queryParams.Name != "" && !strings.Contains(se.Name, queryParams.Name) {
+			continue
+		}
+		if queryParams.Type != "" && se.Type != queryParams.Type {
+			continue
+		}
+		secretResource = append(secretResource, s.ToBuildSecret(se))
 	}
 	return &utils.Response{Code: code.Success, Msg: "Success", Data: secretResource}
 }
@@ -104,10 +198,11 @@ func (s *Secret) Get(requestParams interface{}) *utils.Response {
 	if queryParams.Namespace == "" {
 		return &utils.Response{Code: code.ParamsError, Msg: "Namespace is blank"}
 	}
-	secret, err := s.KubeClient.InformerRegistry.SecretInformer().Lister().Secrets(queryParams.Namespace).Get(queryParams.Name)
+	secret, err := s.KubeClient.SecretInformer().Lister().Secrets(queryParams.Namespace).Get(queryParams.Name)
 	if err != nil {
 		return &utils.Response{Code: code.GetError, Msg: err.Error()}
 	}
+	secret = redactSecret(secret)
 	if queryParams.Output == "yaml" {
 		const mediaType = runtime.ContentTypeYAML
 		rscheme := runtime.NewScheme()
@@ -124,9 +219,296 @@ func (s *Secret) Get(requestParams interface{}) *utils.Response {
 			klog.Error(e)
 			return &utils.Response{Code: code.EncodeError, Msg: e.Error()}
 		}
-		klog.Info(d)
 		return &utils.Response{Code: code.Success, Msg: "Success", Data: string(d)}
 	}
-	fmt.Println(secret)
 	return &utils.Response{Code: code.Success, Msg: "Success", Data: secret}
 }
+
+// Reveal returns the values of the secret keys, all keys when blank. Every reveal is recorded as an event of
+// the secret, the values are not returned when the event can not be created.
+func (s *Secret) Reveal(requestParams interface{}) *utils.Response {
+	params := &SecretRevealParams{}
+	json.Unmarshal(requestParams.([]byte), params)
+	if params.Name == "" {
+		return &utils.Response{Code: code.ParamsError, Msg: "Name is blank"}
+	}
+	if params.Namespace == "" {
+		return &utils.Response{Code: code.ParamsError, Msg: "Namespace is blank"}
+	}
+	if strings.TrimSpace(params.User) == "" {
+		return &utils.Response{Code: code.ParamsError, Msg: "User is blank"}
+	}
+	if strings.TrimSpace(params.Reason) == "" {
+		return &utils.Response{Code: code.ParamsError, Msg: "Reason is blank"}
+	}
+	secret, err := s.KubeClient.SecretInformer().Lister().Secrets(params.Namespace).Get(params.Name)
+	if err != nil {
+		return &utils.Response{Code: code.GetError, Msg: err.Error()}
+	}
+	keys := params.Keys
+	if len(keys) == 0 {
+		for k := range secret.Data {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+	}
+	values := &BuildSecretValues{Values: map[string]string{}, Binary: map[string][]byte{}}
+	for _, k := range keys {
+		v, ok := secret.Data[k]
+		if !ok {
+			return &utils.Response{Code: code.ParamsError, Msg: fmt.Sprintf("key %s not found", k)}
+		}
+		if utf8.Valid(v) {
+			values.Values[k] = string(v)
+		} else {
+			values.Binary[k] = v
+		}
+	}
+	if err := s.auditReveal(secret, keys, params); err != nil {
+		klog.Errorf("record reveal of secret %s/%s error: %v", params.Namespace, params.Name, err)
+		return &utils.Response{Code: code.CreateError, Msg: "Record reveal audit event error: " + err.Error()}
+	}
+	return &utils.Response{Code: code.Success, Msg: "Success", Data: values}
+}
+
+// auditReveal records who revealed which keys of the secret and why as an event of the secret.
+func (s *Secret) auditReveal(secret *v1.Secret, keys []string, params *SecretRevealParams) error {
+	now := metav1.Now()
+	_, err := s.ClientSet.CoreV1().Events(secret.Namespace).Create(&v1.Event{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      fmt.Sprintf("%s.%x", secret.Name, now.UnixNano()),
+			Namespace: secret.Namespace,
+		},
+		InvolvedObject: v1.ObjectReference{
+			Kind:            "Secret",
+			APIVersion:      "v1",
+			Namespace:       secret.Namespace,
+			Name:            secret.Name,
+			UID:             secret.UID,
+			ResourceVersion: secret.ResourceVersion,
+		},
+		Reason:         secretRevealedEventReason,
+		Message:        fmt.Sprintf("User %s revealed keys %v: %s", params.User, keys, params.Reason),
+		Source:         v1.EventSource{Component: auditEventComponent},
+		FirstTimestamp: now,
+		LastTimestamp:  now,
+		Count:          1,
+		Type:           v1.EventTypeNormal,
+	})
+	return err
+}
+
+func dockerConfigJson(params *DockerRegistryParams) ([]byte, error) {
+	if params.Server == "" {
+		return nil, fmt.Errorf("docker registry server is blank")
+	}
+	if params.Username == "" || params.Password == "" {
+		return nil, fmt.Errorf("docker registry username or password is blank")
+	}
+	auth := base64.StdEncoding.EncodeToString([]byte(params.Username + ":" + params.Password))
+	return json.Marshal(map[string]interface{}{
+		"auths": map[string]interface{}{
+			params.Server: map[string]string{
+				"username": params.Username,
+				"password": params.Password,
+				"email":    params.Email,
+				"auth":     auth,
+			},
+		},
+	})
+}
+
+// buildSecretData builds the type and data of the secret from the typed params, or from data and string data.
+func buildSecretData(params *SecretUpdateParams) (v1.SecretType, map[string][]byte, error) {
+	data := map[string][]byte{}
+	for k, v := range params.Data {
+		data[k] = v
+	}
+	for k, v := range params.StringData {
+		data[k] = []byte(v)
+	}
+	secretType := params.Type
+	switch {
+	case params.DockerRegistry != nil:
+		config, err := dockerConfigJson(params.DockerRegistry)
+		if err != nil {
+			return "", nil, err
+		}
+		secretType = v1.SecretTypeDockerConfigJson
+		data[v1.DockerConfigJsonKey] = config
+	case params.TLS != nil:
+		if _, err := tls.X509KeyPair([]byte(params.TLS.Cert), []byte(params.TLS.Key)); err != nil {
+			return "", nil, fmt.Errorf("invalid tls certificate or key: %v", err)
+		}
+		secretType = v1.SecretTypeTLS
+		data[v1.TLSCertKey] = []byte(params.TLS.Cert)
+		data[v1.TLSPrivateKeyKey] = []byte(params.TLS.Key)
+	case params.BasicAuth != nil:
+		if params.BasicAuth.Username == "" && params.BasicAuth.Password == "" {
+			return "", nil, fmt.Errorf("basic auth username and password are blank")
+		}
+		secretType = v1.SecretTypeBasicAuth
+		data[v1.BasicAuthUsernameKey] = []byte(params.BasicAuth.Username)
+		data[v1.BasicAuthPasswordKey] = []byte(params.BasicAuth.Password)
+	}
+	if secretType == "" {
+		secretType = v1.SecretTypeOpaque
+	}
+	return secretType, data, nil
+}
+
+func (s *Secret) Create(createParams interface{}) *utils.Response {
+	params := &SecretUpdateParams{}
+	json.Unmarshal(createParams.([]byte), params)
+	if params.Name == "" {
+		return &utils.Response{Code: code.ParamsError, Msg: "Secret name is blank"}
+	}
+	if params.Namespace == "" {
+		return &utils.Response{Code: code.ParamsError, Msg: "Namespace is blank"}
+	}
+	secretType, data, err := buildSecretData(params)
+	if err != nil {
+		return &utils.Response{Code: code.ParamsError, Msg: err.Error()}
+	}
+
+	secret := v1.Secret{}
+	secret.APIVersion = "v1"
+	secret.Kind = "Secret"
+	secret.Name = params.Name
+	secret.Namespace = params.Namespace
+	secret.Labels = params.Labels
+	secret.Type = secretType
+	secret.Data = data
+
+	se, err := s.ClientSet.CoreV1().Secrets(params.Namespace).Create(&secret)
+	if err != nil {
+		klog.Errorf("Create Secret failed: %v", err)
+		return &utils.Response{Code: code.CreateError, Msg: err.Error()}
+	}
+	return &utils.Response{Code: code.Success, Msg: "Success", Data: s.ToBuildSecret(se)}
+}
+
+// UpdateObj replaces the data of the secret, and the labels when given. The type of a secret can not be changed.
+func (s *Secret) UpdateObj(updateParams interface{}) *utils.Response {
+	params := &SecretUpdateParams{}
+	json.Unmarshal(updateParams.([]byte), params)
+	if params.Name == "" {
+		return &utils.Response{Code: code.ParamsError, Msg: "Secret name is blank"}
+	}
+	if params.Namespace == "" {
+		return &utils.Response{Code: code.ParamsError, Msg: "Namespace is blank"}
+	}
+	secretType, data, err := buildSecretData(params)
+	if err != nil {
+		return &utils.Response{Code: code.ParamsError, Msg: err.Error()}
+	}
+	if len(data) == 0 && params.Labels == nil {
+		return &utils.Response{Code: code.ParamsError, Msg: "Data and labels are blank"}
+	}
+	retryErr := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		result, getErr := s.ClientSet.CoreV1().Secrets(params.Namespace).Get(params.Name, metav1.GetOptions{})
+		if getErr != nil {
+			return getErr
+		}
+		if params.Type != "" || params.DockerRegistry != nil || params.TLS != nil || params.BasicAuth != nil {
+			if result.Type != secretType {
+				return fmt.Errorf("secret type %s can not be changed to %s", result.Type, secretType)
+			}
+		}
+		if len(data) > 0 {
+			result.Data = data
+		}
+		if params.Labels != nil {
+			result.Labels = params.Labels
+		}
+		_, updateErr := s.ClientSet.CoreV1().Secrets(params.Namespace).Update(result)
+		return updateErr
+	})
+	if retryErr != nil {
+		klog.Errorf("Update failed: %v", retryErr)
+		return &utils.Response{Code: code.UpdateError, Msg: retryErr.Error()}
+	}
+	return &utils.Response{Code: code.Success, Msg: "Success"}
+}
+
+func (s *Secret) patchData(namespace, name string, data map[string]interface{}) error {
+	patch, err := json.Marshal(map[string]interface{}{"data": data})
+	if err != nil {
+		return err
+	}
+	_, err = s.ClientSet.CoreV1().Secrets(namespace).Patch(name, types.MergePatchType, patch)
+	return err
+}
+
+func (s *Secret) SetKeys(requestParams interface{}) *utils.Response {
+	params := &SecretKeysParams{}
+	json.Unmarshal(requestParams.([]byte), params)
+	if params.Name == "" {
+		return &utils.Response{Code: code.ParamsError, Msg: "Secret name is blank"}
+	}
+	if params.Namespace == "" {
+		return &utils.Response{Code: code.ParamsError, Msg: "Namespace is blank"}
+	}
+	if len(params.Data) == 0 && len(params.StringData) == 0 {
+		return &utils.Response{Code: code.ParamsError, Msg: "Data is blank"}
+	}
+	data := map[string]interface{}{}
+	for k, v := range params.Data {
+		data[k] = v
+	}
+	for k, v := range params.StringData {
+		data[k] = []byte(v)
+	}
+	if err := s.patchData(params.Namespace, params.Name, data); err != nil {
+		klog.Errorf("Set Secret keys failed: %v", err)
+		return &utils.Response{Code: code.UpdateError, Msg: err.Error()}
+	}
+	return &utils.Response{Code: code.Success, Msg: "Success"}
+}
+
+func (s *Secret) RemoveKeys(requestParams interface{}) *utils.Response {
+	params := &SecretKeysParams{}
+	json.Unmarshal(requestParams.([]byte), params)
+	if params.Name == "" {
+		return &utils.Response{Code: code.ParamsError, Msg: "Secret name is blank"}
+	}
+	if params.Namespace == "" {
+		return &utils.Response{Code: code.ParamsError, Msg: "Namespace is blank"}
+	}
+	if len(params.Keys) == 0 {
+		return &utils.Response{Code: code.ParamsError, Msg: "Keys are blank"}
+	}
+	secret, err := s.KubeClient.SecretInformer().Lister().Secrets(params.Namespace).Get(params.Name)
+	if err != nil {
+		return &utils.Response{Code: code.GetError, Msg: err.Error()}
+	}
+	data := map[string]interface{}{}
+	for _, k := range params.Keys {
+		if _, ok := secret.Data[k]; !ok {
+			return &utils.Response{Code: code.ParamsError, Msg: fmt.Sprintf("key %s not found", k)}
+		}
+		data[k] = nil
+	}
+	if err := s.patchData(params.Namespace, params.Name, data); err != nil {
+		klog.Errorf("Remove Secret keys failed: %v", err)
+		return &utils.Response{Code: code.UpdateError, Msg: err.Error()}
+	}
+	return &utils.Response{Code: code.Success, Msg: "Success"}
+}
+
+// References lists the pods mounting or referencing the secret, image pull secrets included.
+func (s *Secret) References(requestParams interface{}) *utils.Response {
+	queryParams := &SecretQueryParams{}
+	json.Unmarshal(requestParams.([]byte), queryParams)
+	if queryParams.Name == "" {
+		return &utils.Response{Code: code.ParamsError, Msg: "Name is blank"}
+	}
+	if queryParams.Namespace == "" {
+		return &utils.Response{Code: code.ParamsError, Msg: "Namespace is blank"}
+	}
+	refs, err := namespaceReferences(s.KubeClient, queryParams.Namespace, "Secret", queryParams.Name)
+	if err != nil {
+		return &utils.Response{Code: code.ListError, Msg: err.Error()}
+	}
+	return &utils.Response{Code: code.Success, Msg: "Success", Data: refs}
+}
@@ -0,0 +1,364 @@
+package resource
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/openspacee/ospagent/pkg/utils"
+	"github.com/openspacee/ospagent/pkg/utils/code"
+	"k8s.io/klog"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	ExecSession        = "exec"
+	LogSession         = "log"
+	PortForwardSession = "portforward"
+	CopySession        = "copy"
+
+	// frameQueueSize is the number of frames queued for a stream session, the session is closed
+	// when it does not keep up with its frames.
+	frameQueueSize = 256
+)
+
+// Session is a long running stream between the server and the cluster, like exec or log.
+type Session interface {
+	Close() error
+}
+
+// StreamSession is a session accepting the binary stream frames from the server.
+type StreamSession interface {
+	Session
+	HandleFrame(frame *utils.Frame) error
+}
+
+// pendingSession holds the id of a session while the session starts, closing it cancels the start.
+type pendingSession struct {
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func newPendingSession() *pendingSession {
+	return &pendingSession{done: make(chan struct{})}
+}
+
+func (s *pendingSession) Close() error {
+	s.closeOnce.Do(func() {
+		close(s.done)
+	})
+	return nil
+}
+
+type sessionEntry struct {
+	id         string
+	kind       string
+	namespace  string
+	pod        string
+	created    time.Time
+	lastActive time.Time
+	session    Session
+	// frames queues the frames of a stream session, they are handled in order by the session goroutine.
+	frames chan *utils.Frame
+}
+
+// handleFrames handles the queued frames until the queue is closed, it gets the queue itself as stop clears
+// the field of the entry.
+func handleFrames(frames <-chan *utils.Frame, session StreamSession) {
+	for frame := range frames {
+		if err := session.HandleFrame(frame); err != nil {
+			klog.Errorf("handle %s frame of session %s error: %v", utils.StreamName(frame.Stream), frame.SessionId, err)
+		}
+	}
+}
+
+// stop ends the session goroutine, it is called with the manager mutex held.
+func (e *sessionEntry) stop() {
+	if e.frames != nil {
+		close(e.frames)
+		e.frames = nil
+	}
+}
+
+// sessionExistsError is the error adding a session with the id of a running session.
+type sessionExistsError struct {
+	id string
+}
+
+func (e *sessionExistsError) Error() string {
+	return fmt.Sprintf("session %s already exists", e.id)
+}
+
+type BuildSession struct {
+	SessionId  string    `json:"session_id"`
+	Kind       string    `json:"kind"`
+	Namespace  string    `json:"namespace"`
+	Pod        string    `json:"pod"`
+	Created    time.Time `json:"created"`
+	LastActive time.Time `json:"last_active"`
+}
+
+// SessionManager owns all streaming sessions of the agent, it limits the number of
+// sessions and closes the sessions idle longer than idleTimeout.
+type SessionManager struct {
+	mutex          sync.Mutex
+	sessions       map[string]*sessionEntry
+	idleTimeout    time.Duration
+	maxSessions    int
+	maxPodSessions int
+}
+
+func NewSessionManager(idleTimeout time.Duration, maxSessions, maxPodSessions int) *SessionManager {
+	m := &SessionManager{
+		sessions:       make(map[string]*sessionEntry),
+		idleTimeout:    idleTimeout,
+		maxSessions:    maxSessions,
+		maxPodSessions: maxPodSessions,
+	}
+	if idleTimeout > 0 {
+		go m.cleanIdle()
+	}
+	return m
+}
+
+// checkId checks the id of a new session, it is called with the mutex held.
+func (m *SessionManager) checkId(id string) error {
+	if id == "" {
+		return fmt.Errorf("session id is blank")
+	}
+	if err := utils.CheckFrameSessionId(id); err != nil {
+		return err
+	}
+	if _, ok := m.sessions[id]; ok {
+		return &sessionExistsError{id: id}
+	}
+	return nil
+}
+
+// CheckId checks the id of a new session, it is for the requests starting their session in the background.
+func (m *SessionManager) CheckId(id string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.checkId(id)
+}
+
+func (m *SessionManager) Add(id, kind, namespace, pod string, session Session) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if err := m.checkId(id); err != nil {
+		return err
+	}
+	if m.maxSessions > 0 && len(m.sessions) >= m.maxSessions {
+		return fmt.Errorf("too many sessions, max %d sessions per agent", m.maxSessions)
+	}
+	if m.maxPodSessions > 0 && pod != "" {
+		podSessions := 0
+		for _, e := range m.sessions {
+			if e.namespace == namespace && e.pod == pod {
+				podSessions += 1
+			}
+		}
+		if podSessions >= m.maxPodSessions {
+			return fmt.Errorf("too many sessions, max %d sessions per pod", m.maxPodSessions)
+		}
+	}
+	now := time.Now()
+	e := &sessionEntry{
+		id:         id,
+		kind:       kind,
+		namespace:  namespace,
+		pod:        pod,
+		created:    now,
+		lastActive: now,
+		session:    session,
+	}
+	if streamSession, ok := session.(StreamSession); ok {
+		e.frames = make(chan *utils.Frame, frameQueueSize)
+		go handleFrames(e.frames, streamSession)
+	}
+	m.sessions[id] = e
+	klog.Infof("add %s session %s, total %d sessions", kind, id, len(m.sessions))
+	return nil
+}
+
+func (m *SessionManager) Get(id string) Session {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	e, ok := m.sessions[id]
+	if !ok {
+		return nil
+	}
+	e.lastActive = time.Now()
+	return e.session
+}
+
+// Touch marks the session active, so it is not closed by the idle timeout.
+func (m *SessionManager) Touch(id string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if e, ok := m.sessions[id]; ok {
+		e.lastActive = time.Now()
+	}
+}
+
+// Remove forgets the session without closing it, it is called when the session ends by itself.
+func (m *SessionManager) Remove(id string, session Session) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if e, ok := m.sessions[id]; ok && e.session == session {
+		e.stop()
+		delete(m.sessions, id)
+	}
+}
+
+func (m *SessionManager) Close(id string) error {
+	m.mutex.Lock()
+	e, ok := m.sessions[id]
+	if ok {
+		e.stop()
+		delete(m.sessions, id)
+	}
+	m.mutex.Unlock()
+	if !ok {
+		return fmt.Errorf("session %s not found", id)
+	}
+	klog.Infof("close %s session %s", e.kind, id)
+	return e.session.Close()
+}
+
+// CloseAll closes all sessions, the server side of the sessions is gone when the websocket disconnects.
+func (m *SessionManager) CloseAll() {
+	m.mutex.Lock()
+	sessions := m.sessions
+	m.sessions = make(map[string]*sessionEntry)
+	for _, e := range sessions {
+		e.stop()
+	}
+	m.mutex.Unlock()
+	for id, e := range sessions {
+		klog.Infof("close %s session %s", e.kind, id)
+		if err := e.session.Close(); err != nil {
+			klog.Errorf("close session %s error: %v", id, err)
+		}
+	}
+}
+
+func (m *SessionManager) cleanIdle() {
+	ticker := time.NewTicker(m.idleTimeout / 2)
+	defer ticker.Stop()
+	for range ticker.C {
+		var idle []string
+		m.mutex.Lock()
+		for id, e := range m.sessions {
+			if time.Since(e.lastActive) > m.idleTimeout {
+				idle = append(idle, id)
+			}
+		}
+		m.mutex.Unlock()
+		for _, id := range idle {
+			klog.Infof("session %s idle more than %s", id, m.idleTimeout)
+			m.Close(id)
+		}
+	}
+}
+
+// Dispatch queues a stream frame from the server to its session without blocking, the frame id of a sub stream
+// like a port forward connection is "<session id>/<sub stream id>".
+func (m *SessionManager) Dispatch(frame *utils.Frame) {
+	if err := m.Enqueue(frame); err != nil {
+		klog.Errorf("dispatch %s frame error: %v", utils.StreamName(frame.Stream), err)
+	}
+}
+
+// Enqueue queues the frame to its session without blocking. A session with a full queue is closed, so a
+// stalled session does not hold up the other sessions.
+func (m *SessionManager) Enqueue(frame *utils.Frame) error {
+	m.mutex.Lock()
+	e, ok := m.sessions[frame.SessionId]
+	if !ok {
+		if i := strings.Index(frame.SessionId, "/"); i > 0 {
+			e, ok = m.sessions[frame.SessionId[:i]]
+		}
+	}
+	if !ok || e.frames == nil {
+		m.mutex.Unlock()
+		return fmt.Errorf("not found stream session %s", frame.SessionId)
+	}
+	e.lastActive = time.Now()
+	select {
+	case e.frames <- frame:
+		m.mutex.Unlock()
+		return nil
+	default:
+	}
+	m.mutex.Unlock()
+	go m.closeEntry(e)
+	return fmt.Errorf("frame queue of session %s is full, close the session", e.id)
+}
+
+// closeEntry closes the session of the entry unless it is already removed.
+func (m *SessionManager) closeEntry(e *sessionEntry) {
+	m.mutex.Lock()
+	cur, ok := m.sessions[e.id]
+	if ok && cur == e {
+		e.stop()
+		delete(m.sessions, e.id)
+	}
+	m.mutex.Unlock()
+	if ok && cur == e {
+		if err := e.session.Close(); err != nil {
+			klog.Errorf("close session %s error: %v", e.id, err)
+		}
+	}
+}
+
+type SessionQueryParams struct {
+	SessionId string `json:"session_id"`
+	Kind      string `json:"kind"`
+	Namespace string `json:"namespace"`
+	Pod       string `json:"pod"`
+}
+
+func (m *SessionManager) List(requestParams interface{}) *utils.Response {
+	queryParams := &SessionQueryParams{}
+	json.Unmarshal(requestParams.([]byte), queryParams)
+	m.mutex.Lock()
+	var sessions []*BuildSession
+	for _, e := range m.sessions {
+		if queryParams.Kind != "" && e.kind != queryParams.Kind {
+			continue
+		}
+		if queryParams.Namespace != "" && e.namespace != queryParams.Namespace {
+			continue
+		}
+		if queryParams.Pod != "" && e.pod != queryParams.Pod {
+			continue
+		}
+		sessions = append(sessions, &BuildSession{
+			SessionId:  e.id,
+			Kind:       e.kind,
+			Namespace:  e.namespace,
+			Pod:        e.pod,
+			Created:    e.created,
+			LastActive: e.lastActive,
+		})
+	}
+	m.mutex.Unlock()
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].Created.Before(sessions[j].Created)
+	})
+	return &utils.Response{Code: code.Success, Msg: "Success", Data: sessions}
+}
+
+func (m *SessionManager) Delete(requestParams interface{}) *utils.Response {
+	params := &SessionQueryParams{}
+	json.Unmarshal(requestParams.([]byte), params)
+	if params.SessionId == "" {
+		return &utils.Response{Code: code.ParamsError, Msg: "Session id is blank"}
+	}
+	if err := m.Close(params.SessionId); err != nil {
+		return &utils.Response{Code: code.ParamsError, Msg: err.Error()}
+	}
+	return &utils.Response{Code: code.Success, Msg: "Success"}
+}
@@ -5,6 +5,7 @@ import (
 	"github.com/openspacee/ospagent/pkg/utils"
 	"github.com/openspacee/ospagent/pkg/utils/code"
 	"github.com/openspacee/ospagent/pkg/websocket"
+	"k8s.io/client-go/tools/cache"
 	"k8s.io/klog"
 	"sync"
 )
@@ -51,29 +52,52 @@ func (w *WatchResource) WatchAction(requestParams interface{}) *utils.Response {
 	return &utils.Response{Code: code.Success, Msg: "Action watch resource success"}
 }
 
+// WatchTransform converts the watched object before it is sent.
+type WatchTransform func(interface{}) interface{}
+
 func (w *WatchResource) WatchAdd(watchRes string) func(interface{}) {
+	return w.WatchAddTransform(watchRes, nil)
+}
+
+func (w *WatchResource) WatchUpdate(watchRes string) func(interface{}, interface{}) {
+	return w.WatchUpdateTransform(watchRes, nil)
+}
+
+func (w *WatchResource) WatchDelete(watchRes string) func(interface{}) {
+	return w.WatchDeleteTransform(watchRes, nil)
+}
+
+func (w *WatchResource) send(event, watchRes string, obj interface{}, transform WatchTransform) {
+	if transform != nil {
+		obj = transform(obj)
+	}
+	resp := &utils.WatchResponse{Event: event, Resource: obj, Obj: watchRes}
+	w.SendResponse(resp, "", utils.WatchType)
+}
+
+func (w *WatchResource) WatchAddTransform(watchRes string, transform WatchTransform) func(interface{}) {
 	return func(obj interface{}) {
 		if w.watch {
-			resp := &utils.WatchResponse{Event: utils.AddEvent, Resource: obj, Obj: watchRes}
-			w.SendResponse(resp, "", utils.WatchType)
+			w.send(utils.AddEvent, watchRes, obj, transform)
 		}
 	}
 }
 
-func (w *WatchResource) WatchUpdate(watchRes string) func(interface{}, interface{}) {
+func (w *WatchResource) WatchUpdateTransform(watchRes string, transform WatchTransform) func(interface{}, interface{}) {
 	return func(oldObj, newObj interface{}) {
 		if w.watch {
-			resp := &utils.WatchResponse{Event: utils.UpdateEvent, Resource: newObj, Obj: watchRes}
-			w.SendResponse(resp, "", utils.WatchType)
+			w.send(utils.UpdateEvent, watchRes, newObj, transform)
 		}
 	}
 }
 
-func (w *WatchResource) WatchDelete(watchRes string) func(interface{}) {
+func (w *WatchResource) WatchDeleteTransform(watchRes string, transform WatchTransform) func(interface{}) {
 	return func(obj interface{}) {
 		if w.watch {
-			resp := &utils.WatchResponse{Event: utils.DeleteEvent, Resource: obj, Obj: watchRes}
-			w.SendResponse(resp, "", utils.WatchType)
+			if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok && transform != nil {
+				obj = tombstone.Obj
+			}
+			w.send(utils.DeleteEvent, watchRes, obj, transform)
 		}
 	}
 }
@@ -16,22 +16,80 @@ const (
 	UPDATEOBJ  = "update_obj"
 	EXEC       = "exec"
 	STDIN      = "stdin"
+	CLOSEEXEC  = "closeExec"
 	OPENLOG    = "openLog"
 	CLOSELOG   = "closeLog"
 	APPLY      = "apply"
+	EXPORTLOGS = "exportLogs"
+
+	OPENPORTFORWARD  = "openPortForward"
+	PORTFORWARDDATA  = "portForwardData"
+	CLOSEPORTFORWARD = "closePortForward"
+
+	COPYFROM = "copyFrom"
+	COPYTO   = "copyTo"
+	DEBUG    = "debug"
+	SHELL    = "shell"
+
+	CORDON   = "cordon"
+	UNCORDON = "uncordon"
+	DRAIN    = "drain"
+
+	TAINT        = "taint"
+	LABEL        = "label"
+	TAINTPREVIEW = "taintPreview"
+
+	RANGE        = "range"
+	RELATIONS    = "relations"
+	DIAGNOSE     = "diagnose"
+	CERTIFICATES = "certificates"
+
+	RESTART = "restart"
+	PAUSE   = "pause"
+	RESUME  = "resume"
+	HISTORY = "history"
+	UNDO    = "undo"
+	STATUS  = "status"
+
+	SETKEYS    = "setKeys"
+	REMOVEKEYS = "removeKeys"
+	REFERENCES = "references"
+	REVEAL     = "reveal"
+
+	GRANT   = "grant"
+	REVOKE  = "revoke"
+	RESOLVE = "resolve"
 )
 
 type Handler func(interface{}) *utils.Response
 
 type ActionHandler map[string]Handler
 
+// StreamHandler handles the request sending data before the response, the data is tied to the request id.
+type StreamHandler func(string, interface{}) *utils.Response
+
+type StreamActionHandler map[string]StreamHandler
+
 type ResourceActions struct {
-	KubeClient            *kubernetes.KubeClient
-	ResourceActionHandler map[string]ActionHandler
+	KubeClient                  *kubernetes.KubeClient
+	ResourceActionHandler       map[string]ActionHandler
+	ResourceStreamActionHandler map[string]StreamActionHandler
+	// ResourceOrderedActionHandler handles the requests in the order they are received, the handlers only
+	// queue the input of a session and must not block.
+	ResourceOrderedActionHandler map[string]ActionHandler
 }
 
-func NewResourceActions(kubeClient *kubernetes.KubeClient, sendResponse websocket.SendResponse) *ResourceActions {
+func NewResourceActions(
+	kubeClient *kubernetes.KubeClient,
+	sessions *resource.SessionManager,
+	metricsHistory *resource.MetricsHistory,
+	nodeShell *resource.NodeShellOptions,
+	sendResponse websocket.SendResponse,
+	sendStream websocket.SendStream) *ResourceActions {
+
 	actionHandlers := make(map[string]ActionHandler)
+	streamActionHandlers := make(map[string]StreamActionHandler)
+	orderedActionHandlers := make(map[string]ActionHandler)
 
 	watch := resource.NewWatchResource(sendResponse)
 	watchActions := ActionHandler{
@@ -40,24 +98,51 @@ func NewResourceActions(kubeClient *kubernetes.KubeClient, sendResponse websocke
 	actionHandlers["watch"] = watchActions
 
 	cluster := resource.NewCluster(kubeClient, watch)
+	relations := resource.NewRelations(kubeClient)
+	diagnosis := resource.NewDiagnosis(kubeClient)
+	certificates := resource.NewCertificates(kubeClient)
 	clusterActions := ActionHandler{
-		GET:   cluster.Get,
-		APPLY: cluster.ApplyYaml,
+		GET:          cluster.Get,
+		APPLY:        cluster.ApplyYaml,
+		RELATIONS:    relations.Get,
+		DIAGNOSE:     diagnosis.Diagnose,
+		CERTIFICATES: certificates.List,
 	}
 	actionHandlers["cluster"] = clusterActions
 
-	pod := resource.NewPod(kubeClient, sendResponse, watch)
+	sessionActions := ActionHandler{
+		LIST:   sessions.List,
+		DELETE: sessions.Delete,
+	}
+	actionHandlers["session"] = sessionActions
+
+	pod := resource.NewPod(kubeClient, sendResponse, sendStream, watch, sessions)
 	podActions := ActionHandler{
 		LIST:       pod.List,
 		GET:        pod.Get,
 		EXEC:       pod.Exec,
-		STDIN:      pod.ExecStdIn,
+		CLOSEEXEC:  pod.CloseExec,
 		OPENLOG:    pod.OpenLog,
 		CLOSELOG:   pod.CloseLog,
 		DELETE:     pod.Delete,
 		UPDATEYAML: pod.UpdateYaml,
+
+		OPENPORTFORWARD: pod.OpenPortForward,
+
+		COPYFROM: pod.CopyFrom,
+		COPYTO:   pod.CopyTo,
+		DEBUG:    pod.Debug,
 	}
 	actionHandlers["pod"] = podActions
+	orderedActionHandlers["pod"] = ActionHandler{
+		STDIN:            pod.ExecStdIn,
+		PORTFORWARDDATA:  pod.PortForwardData,
+		CLOSEPORTFORWARD: pod.ClosePortForward,
+	}
+	podStreamActions := StreamActionHandler{
+		EXPORTLOGS: pod.ExportLogs,
+	}
+	streamActionHandlers["pod"] = podStreamActions
 
 	ns := resource.NewNamespace(kubeClient, sendResponse, watch)
 	nsActions := ActionHandler{
@@ -66,13 +151,24 @@ func NewResourceActions(kubeClient *kubernetes.KubeClient, sendResponse websocke
 	}
 	actionHandlers["namespace"] = nsActions
 
-	node := resource.NewNode(kubeClient, watch)
+	node := resource.NewNode(kubeClient, sendResponse, sendStream, watch, sessions, nodeShell)
 	nodeActions := ActionHandler{
 		LIST:       node.List,
 		GET:        node.Get,
 		UPDATEYAML: node.UpdateYaml,
+		SHELL:      node.Shell,
+		CORDON:     node.Cordon,
+		UNCORDON:   node.Uncordon,
+
+		TAINT:        node.Taint,
+		LABEL:        node.Label,
+		TAINTPREVIEW: node.TaintPreview,
 	}
 	actionHandlers["node"] = nodeActions
+	nodeStreamActions := StreamActionHandler{
+		DRAIN: node.Drain,
+	}
+	streamActionHandlers["node"] = nodeStreamActions
 
 	event := resource.NewEvent(kubeClient, watch)
 	eventActions := ActionHandler{
@@ -120,6 +216,36 @@ func NewResourceActions(kubeClient *kubernetes.KubeClient, sendResponse websocke
 	}
 	actionHandlers["job"] = jobActions
 
+	rollout := resource.NewRollout(kubeClient, sendResponse)
+	rolloutActions := ActionHandler{
+		RESTART: rollout.Restart,
+		PAUSE:   rollout.Pause,
+		RESUME:  rollout.Resume,
+		HISTORY: rollout.History,
+		UNDO:    rollout.Undo,
+	}
+	actionHandlers["rollout"] = rolloutActions
+	rolloutStreamActions := StreamActionHandler{
+		STATUS: rollout.Status,
+	}
+	streamActionHandlers["rollout"] = rolloutStreamActions
+
+	replicaset := resource.NewReplicaSet(kubeClient, watch)
+	replicasetActions := ActionHandler{
+		LIST:       replicaset.List,
+		GET:        replicaset.Get,
+		DELETE:     replicaset.Delete,
+		UPDATEYAML: replicaset.UpdateYaml,
+	}
+	actionHandlers["replicaset"] = replicasetActions
+
+	controllerRevision := resource.NewControllerRevision(kubeClient)
+	controllerRevisionActions := ActionHandler{
+		LIST: controllerRevision.List,
+		GET:  controllerRevision.Get,
+	}
+	actionHandlers["controllerRevision"] = controllerRevisionActions
+
 	cronjob := resource.NewCronJob(kubeClient, watch)
 	cronjobActions := ActionHandler{
 		LIST:       cronjob.List,
@@ -130,12 +256,17 @@ func NewResourceActions(kubeClient *kubernetes.KubeClient, sendResponse websocke
 	}
 	actionHandlers["cronjob"] = cronjobActions
 
-	configMap := resource.NewConfigMap(kubeClient, sendResponse)
+	configMap := resource.NewConfigMap(kubeClient, sendResponse, watch)
 	configMapActions := ActionHandler{
 		LIST:       configMap.List,
 		GET:        configMap.Get,
+		CREATE:     configMap.Create,
+		DELETE:     configMap.Delete,
 		UPDATEYAML: configMap.UpdateYaml,
-		//CREATE: 	configMap.Create,
+		UPDATEOBJ:  configMap.UpdateObj,
+		SETKEYS:    configMap.SetKeys,
+		REMOVEKEYS: configMap.RemoveKeys,
+		REFERENCES: configMap.References,
 	}
 	actionHandlers["configMap"] = configMapActions
 
@@ -224,33 +355,68 @@ func NewResourceActions(kubeClient *kubernetes.KubeClient, sendResponse websocke
 	rolebindingActions := ActionHandler{
 		LIST:       rolebinding.List,
 		GET:        rolebinding.Get,
+		CREATE:     rolebinding.Create,
+		DELETE:     rolebinding.Delete,
 		UPDATEYAML: rolebinding.UpdateYaml,
-		//DELETE:     rolebinding.Delete,
+		UPDATEOBJ:  rolebinding.UpdateObj,
+		GRANT:      rolebinding.Grant,
+		REVOKE:     rolebinding.Revoke,
 	}
 	actionHandlers["rolebinding"] = rolebindingActions
 
 	role := resource.NewRole(kubeClient, watch)
 	roleActions := ActionHandler{
-		LIST: role.List,
-		GET:  role.Get,
-		//UPDATEYAML: rolebinding.UpdateYaml,
-		//DELETE:     rolebinding.Delete,
+		LIST:       role.List,
+		GET:        role.Get,
+		CREATE:     role.Create,
+		DELETE:     role.Delete,
+		UPDATEYAML: role.UpdateYaml,
+		UPDATEOBJ:  role.UpdateObj,
+		RESOLVE:    role.Resolve,
 	}
 	actionHandlers["role"] = roleActions
 
+	metrics := resource.NewMetrics(kubeClient)
+	metricsActions := ActionHandler{
+		LIST:  metrics.List,
+		GET:   metrics.Get,
+		RANGE: metricsHistory.Range,
+	}
+	actionHandlers["metrics"] = metricsActions
+
 	secret := resource.NewSecret(kubeClient, watch)
 	secretActions := ActionHandler{
-		LIST: secret.List,
-		GET:  secret.Get,
+		LIST:       secret.List,
+		GET:        secret.Get,
+		CREATE:     secret.Create,
+		DELETE:     secret.Delete,
+		UPDATEOBJ:  secret.UpdateObj,
+		SETKEYS:    secret.SetKeys,
+		REMOVEKEYS: secret.RemoveKeys,
+		REFERENCES: secret.References,
+		REVEAL:     secret.Reveal,
 	}
 	actionHandlers["secret"] = secretActions
 
 	return &ResourceActions{
-		KubeClient:            kubeClient,
-		ResourceActionHandler: actionHandlers,
+		KubeClient:                   kubeClient,
+		ResourceActionHandler:        actionHandlers,
+		ResourceStreamActionHandler:  streamActionHandlers,
+		ResourceOrderedActionHandler: orderedActionHandlers,
 	}
 }
 
 func (r *ResourceActions) GetRequestHandler(resource string, action string) Handler {
+	if handler := r.GetOrderedHandler(resource, action); handler != nil {
+		return handler
+	}
 	return r.ResourceActionHandler[resource][action]
 }
+
+func (r *ResourceActions) GetOrderedHandler(resource string, action string) Handler {
+	return r.ResourceOrderedActionHandler[resource][action]
+}
+
+func (r *ResourceActions) GetStreamHandler(resource string, action string) StreamHandler {
+	return r.ResourceStreamActionHandler[resource][action]
+}
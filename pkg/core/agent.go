@@ -3,6 +3,7 @@ package core
 import (
 	"github.com/openspacee/ospagent/pkg/config"
 	"github.com/openspacee/ospagent/pkg/container"
+	"github.com/openspacee/ospagent/pkg/container/resource"
 	"github.com/openspacee/ospagent/pkg/kubernetes"
 	"github.com/openspacee/ospagent/pkg/utils"
 	"github.com/openspacee/ospagent/pkg/websocket"
@@ -15,6 +16,7 @@ type AgentConfig struct {
 	WebSocket    *websocket.WebSocket
 	RequestChan  chan *utils.Request
 	ResponseChan chan *utils.TResponse
+	StreamChan   chan *utils.Frame
 }
 
 func NewAgentConfig(opt *config.AgentOptions) (*AgentConfig, error) {
@@ -22,6 +24,7 @@ func NewAgentConfig(opt *config.AgentOptions) (*AgentConfig, error) {
 		AgentOptions: opt,
 		RequestChan:  make(chan *utils.Request),
 		ResponseChan: make(chan *utils.TResponse),
+		StreamChan:   make(chan *utils.Frame),
 	}
 
 	serverUrl := &url.URL{Scheme: "wss", Host: opt.ServerUrl, Path: "/api/v1/kube/connect"}
@@ -29,15 +32,34 @@ func NewAgentConfig(opt *config.AgentOptions) (*AgentConfig, error) {
 		serverUrl,
 		opt.AgentToken,
 		agentConfig.RequestChan,
-		agentConfig.ResponseChan)
+		agentConfig.ResponseChan,
+		agentConfig.StreamChan)
 
 	kubeClient := kubernetes.NewKubeClient(opt.KubeConfigFile)
+	sessions := resource.NewSessionManager(opt.SessionIdleTimeout, opt.MaxSessions, opt.MaxPodSessions)
+	// the server side of the streaming sessions is gone after the websocket disconnects
+	agentConfig.WebSocket.AddDisconnectHandler(sessions.CloseAll)
+	metricsHistory := resource.NewMetricsHistory(kubeClient, &resource.MetricsHistoryOptions{
+		Interval:        opt.MetricsInterval,
+		Retention:       opt.MetricsRetention,
+		RollupInterval:  opt.MetricsRollupInterval,
+		RollupRetention: opt.MetricsRollupRetention,
+	})
+	nodeShell := &resource.NodeShellOptions{
+		Namespace: opt.NodeShellNamespace,
+		Image:     opt.NodeShellImage,
+	}
 	agentConfig.Container = container.NewContainer(
 		//nil,
 		kubeClient,
+		sessions,
+		metricsHistory,
+		nodeShell,
 		agentConfig.RequestChan,
 		agentConfig.ResponseChan,
-		agentConfig.WebSocket.SendResponse)
+		agentConfig.StreamChan,
+		agentConfig.WebSocket.SendResponse,
+		agentConfig.WebSocket.SendStream)
 
 	return agentConfig, nil
 }
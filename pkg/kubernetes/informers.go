@@ -42,6 +42,8 @@ type InformerRegistry interface {
 	RoleBindingInformer() rbacv1.RoleBindingInformer
 	RoleInformer() rbacv1.RoleInformer
 	SecretInformer() v1.SecretInformer
+	ReplicaSetInformer() appsv1.ReplicaSetInformer
+	ControllerRevisionInformer() appsv1.ControllerRevisionInformer
 }
 
 type InformerRegistryImpl struct {
@@ -69,6 +71,8 @@ type InformerRegistryImpl struct {
 	roleBindingInformer             rbacv1.RoleBindingInformer
 	roleInformer                    rbacv1.RoleInformer
 	secretInformer                  v1.SecretInformer
+	replicaSetInformer              appsv1.ReplicaSetInformer
+	controllerRevisionInformer      appsv1.ControllerRevisionInformer
 }
 
 func NewInformerRegistry(kubeClient kubernetes.Interface, stopCh <-chan struct{}) (InformerRegistry, error) {
@@ -185,6 +189,14 @@ func NewInformerRegistry(kubeClient kubernetes.Interface, stopCh <-chan struct{}
 	if err != nil {
 		return nil, err
 	}
+	replicaSetInformer, err := NewReplicaSetInformer(factory, stopCh)
+	if err != nil {
+		return nil, err
+	}
+	controllerRevisionInformer, err := NewControllerRevisionInformer(factory, stopCh)
+	if err != nil {
+		return nil, err
+	}
 
 	return &InformerRegistryImpl{
 		podInformer:                     podInformer,
@@ -211,6 +223,8 @@ func NewInformerRegistry(kubeClient kubernetes.Interface, stopCh <-chan struct{}
 		roleBindingInformer:             roleBindingInformer,
 		roleInformer:                    roleInformer,
 		secretInformer:                  secretInformer,
+		replicaSetInformer:              replicaSetInformer,
+		controllerRevisionInformer:      controllerRevisionInformer,
 	}, nil
 }
 
@@ -335,6 +349,32 @@ func NewStatefulSetInformer(factory informers.SharedInformerFactory, stopCh <-ch
 	return statefulSetInformer, nil
 }
 
+func NewReplicaSetInformer(factory informers.SharedInformerFactory, stopCh <-chan struct{}) (appsv1.ReplicaSetInformer, error) {
+	replicaSetInformer := factory.Apps().V1().ReplicaSets()
+	informer := replicaSetInformer.Informer()
+	defer runtime.HandleCrash()
+
+	factory.Start(stopCh)
+	if !cache.WaitForCacheSync(stopCh, informer.HasSynced) {
+		runtime.HandleError(fmt.Errorf("time out waiting for caches to sync"))
+		return nil, fmt.Errorf("time out waiting for caches to sync")
+	}
+	return replicaSetInformer, nil
+}
+
+func NewControllerRevisionInformer(factory informers.SharedInformerFactory, stopCh <-chan struct{}) (appsv1.ControllerRevisionInformer, error) {
+	controllerRevisionInformer := factory.Apps().V1().ControllerRevisions()
+	informer := controllerRevisionInformer.Informer()
+	defer runtime.HandleCrash()
+
+	factory.Start(stopCh)
+	if !cache.WaitForCacheSync(stopCh, informer.HasSynced) {
+		runtime.HandleError(fmt.Errorf("time out waiting for caches to sync"))
+		return nil, fmt.Errorf("time out waiting for caches to sync")
+	}
+	return controllerRevisionInformer, nil
+}
+
 func NewDaemonSetInformer(factory informers.SharedInformerFactory, stopCh <-chan struct{}) (appsv1.DaemonSetInformer, error) {
 	daemonSetInformer := factory.Apps().V1().DaemonSets()
 	informer := daemonSetInformer.Informer()
@@ -625,3 +665,11 @@ func (r *InformerRegistryImpl) RoleInformer() rbacv1.RoleInformer {
 func (r *InformerRegistryImpl) SecretInformer() v1.SecretInformer {
 	return r.secretInformer
 }
+
+func (r *InformerRegistryImpl) ReplicaSetInformer() appsv1.ReplicaSetInformer {
+	return r.replicaSetInformer
+}
+
+func (r *InformerRegistryImpl) ControllerRevisionInformer() appsv1.ControllerRevisionInformer {
+	return r.controllerRevisionInformer
+}
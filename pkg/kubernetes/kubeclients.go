@@ -13,6 +13,7 @@ type KubeClient struct {
 	ClientSet     kube_client.Interface
 	DynamicClient dynamic.Interface
 	Config        *rest.Config
+	Metrics       *MetricsClient
 	//ListRegistry
 	InformerRegistry
 	*discovery.DiscoveryClient
@@ -50,10 +51,18 @@ func NewKubeClient(kubeConfigFile string) *KubeClient {
 		panic(err.Error())
 	}
 	dc, err := discovery.NewDiscoveryClientForConfig(config)
+	if err != nil {
+		panic(err.Error())
+	}
+	metrics, err := NewMetricsClient(config)
+	if err != nil {
+		panic(err.Error())
+	}
 	return &KubeClient{
 		ClientSet:     kubeClient,
 		DynamicClient: dynamicClient,
 		Config:        config,
+		Metrics:       metrics,
 		//ListRegistry:     listRegistry,
 		InformerRegistry: informerRegistry,
 		DiscoveryClient:  dc,
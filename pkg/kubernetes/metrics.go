@@ -0,0 +1,116 @@
+package kubernetes
+
+import (
+	"encoding/json"
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+)
+
+// The metrics.k8s.io types, the metrics client is not vendored.
+var MetricsGroupVersion = schema.GroupVersion{Group: "metrics.k8s.io", Version: "v1beta1"}
+
+type ContainerMetrics struct {
+	Name  string          `json:"name"`
+	Usage v1.ResourceList `json:"usage"`
+}
+
+type NodeMetrics struct {
+	metav1.ObjectMeta `json:"metadata"`
+	Timestamp         metav1.Time     `json:"timestamp"`
+	Window            metav1.Duration `json:"window"`
+	Usage             v1.ResourceList `json:"usage"`
+}
+
+type NodeMetricsList struct {
+	Items []NodeMetrics `json:"items"`
+}
+
+type PodMetrics struct {
+	metav1.ObjectMeta `json:"metadata"`
+	Timestamp         metav1.Time        `json:"timestamp"`
+	Window            metav1.Duration    `json:"window"`
+	Containers        []ContainerMetrics `json:"containers"`
+}
+
+type PodMetricsList struct {
+	Items []PodMetrics `json:"items"`
+}
+
+type MetricsClient struct {
+	client rest.Interface
+}
+
+func NewMetricsClient(config *rest.Config) (*MetricsClient, error) {
+	c := rest.CopyConfig(config)
+	c.GroupVersion = &MetricsGroupVersion
+	c.APIPath = "/apis"
+	c.NegotiatedSerializer = scheme.Codecs.WithoutConversion()
+	if c.UserAgent == "" {
+		c.UserAgent = rest.DefaultKubernetesUserAgent()
+	}
+	client, err := rest.RESTClientFor(c)
+	if err != nil {
+		return nil, err
+	}
+	return &MetricsClient{client: client}, nil
+}
+
+// IsMetricsUnavailable is true when the metrics api is not registered or the metrics server is down.
+func IsMetricsUnavailable(err error) bool {
+	return errors.IsNotFound(err) || errors.IsServiceUnavailable(err)
+}
+
+func (m *MetricsClient) get(into interface{}, namespace, resourceName, name, labelSelector string) error {
+	request := m.client.Get().Resource(resourceName)
+	if namespace != "" {
+		request = request.Namespace(namespace)
+	}
+	if name != "" {
+		request = request.Name(name)
+	}
+	if labelSelector != "" {
+		request = request.Param("labelSelector", labelSelector)
+	}
+	data, err := request.DoRaw()
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, into)
+}
+
+func (m *MetricsClient) ListNodeMetrics(labelSelector string) ([]NodeMetrics, error) {
+	list := &NodeMetricsList{}
+	if err := m.get(list, "", "nodes", "", labelSelector); err != nil {
+		return nil, err
+	}
+	return list.Items, nil
+}
+
+func (m *MetricsClient) GetNodeMetrics(name string) (*NodeMetrics, error) {
+	metrics := &NodeMetrics{}
+	if err := m.get(metrics, "", "nodes", name, ""); err != nil {
+		return nil, err
+	}
+	return metrics, nil
+}
+
+// ListPodMetrics lists the pod metrics of the namespace, all namespaces when blank.
+func (m *MetricsClient) ListPodMetrics(namespace, labelSelector string) ([]PodMetrics, error) {
+	list := &PodMetricsList{}
+	if err := m.get(list, namespace, "pods", "", labelSelector); err != nil {
+		return nil, err
+	}
+	return list.Items, nil
+}
+
+func (m *MetricsClient) GetPodMetrics(namespace, name string) (*PodMetrics, error) {
+	metrics := &PodMetrics{}
+	if err := m.get(metrics, namespace, "pods", name, ""); err != nil {
+		return nil, err
+	}
+	return metrics, nil
+}
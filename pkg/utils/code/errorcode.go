@@ -10,4 +10,10 @@ const (
 	UpdateError  = "UpdateError"
 	EncodeError  = "EncodeError"
 	ApplyError   = "ApplyError"
+	ExportError  = "ExportError"
+	CreateError  = "CreateError"
+	DrainError   = "DrainError"
+	RolloutError = "RolloutError"
+
+	MetricsUnavailable = "MetricsUnavailable"
 )
@@ -0,0 +1,108 @@
+package utils
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
+// BinaryStreamProtocol is the websocket sub protocol for binary stream frames, when the server
+// does not accept it stream data is sent as json responses.
+const BinaryStreamProtocol = "ospagent.stream.v1"
+
+const (
+	FrameVersion byte = 1
+
+	StdinFrame  byte = 0
+	StdoutFrame byte = 1
+	StderrFrame byte = 2
+	ResizeFrame byte = 3
+	CloseFrame  byte = 4
+
+	frameHeaderSize = 4
+
+	// MaxFrameSessionIdLength is the longest session id of a frame, its length is encoded in 2 bytes.
+	MaxFrameSessionIdLength = math.MaxUint16
+)
+
+// Frame is a binary websocket message of a streaming session, it is encoded as
+//
+//	| version (1 byte) | stream (1 byte) | session id length (2 bytes) | session id | payload |
+type Frame struct {
+	SessionId string
+	Stream    byte
+	Payload   []byte
+}
+
+// CheckFrameSessionId checks the session id fits in a frame.
+func CheckFrameSessionId(id string) error {
+	if id == "" {
+		return fmt.Errorf("frame session id is blank")
+	}
+	if len(id) > MaxFrameSessionIdLength {
+		return fmt.Errorf("frame session id length %d is over %d", len(id), MaxFrameSessionIdLength)
+	}
+	return nil
+}
+
+func (f *Frame) Encode() ([]byte, error) {
+	if err := CheckFrameSessionId(f.SessionId); err != nil {
+		return nil, err
+	}
+	data := make([]byte, frameHeaderSize+len(f.SessionId)+len(f.Payload))
+	data[0] = FrameVersion
+	data[1] = f.Stream
+	binary.BigEndian.PutUint16(data[2:4], uint16(len(f.SessionId)))
+	copy(data[frameHeaderSize:], f.SessionId)
+	copy(data[frameHeaderSize+len(f.SessionId):], f.Payload)
+	return data, nil
+}
+
+func DecodeFrame(data []byte) (*Frame, error) {
+	if len(data) < frameHeaderSize {
+		return nil, fmt.Errorf("frame too short: %d bytes", len(data))
+	}
+	if data[0] != FrameVersion {
+		return nil, fmt.Errorf("unsupported frame version %d", data[0])
+	}
+	idLen := int(binary.BigEndian.Uint16(data[2:4]))
+	if idLen == 0 || len(data) < frameHeaderSize+idLen {
+		return nil, fmt.Errorf("frame session id length %d out of range", idLen)
+	}
+	return &Frame{
+		Stream:    data[1],
+		SessionId: string(data[frameHeaderSize : frameHeaderSize+idLen]),
+		Payload:   data[frameHeaderSize+idLen:],
+	}, nil
+}
+
+// ResizePayload encodes the terminal size of a resize frame as width and height uint16.
+func ResizePayload(width, height uint16) []byte {
+	payload := make([]byte, 4)
+	binary.BigEndian.PutUint16(payload[0:2], width)
+	binary.BigEndian.PutUint16(payload[2:4], height)
+	return payload
+}
+
+func ParseResizePayload(payload []byte) (width, height uint16, err error) {
+	if len(payload) != 4 {
+		return 0, 0, fmt.Errorf("resize payload length %d is not 4", len(payload))
+	}
+	return binary.BigEndian.Uint16(payload[0:2]), binary.BigEndian.Uint16(payload[2:4]), nil
+}
+
+func StreamName(stream byte) string {
+	switch stream {
+	case StdinFrame:
+		return "stdin"
+	case StdoutFrame:
+		return StdoutStream
+	case StderrFrame:
+		return StderrStream
+	case ResizeFrame:
+		return "resize"
+	case CloseFrame:
+		return CloseStream
+	}
+	return fmt.Sprintf("stream%d", stream)
+}
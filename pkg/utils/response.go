@@ -6,10 +6,21 @@ import (
 )
 
 const (
-	RequestType = "request"
-	WatchType   = "watch"
-	ExecType    = "exec"
-	LogType     = "log"
+	RequestType         = "request"
+	WatchType           = "watch"
+	ExecType            = "exec"
+	ExecResultType      = "exec_result"
+	LogType             = "log"
+	ExportType          = "export"
+	PortForwardType     = "portforward"
+	CopyType            = "copy"
+	CopyProgressType    = "copy_progress"
+	DrainProgressType   = "drain_progress"
+	RolloutProgressType = "rollout_progress"
+
+	StdoutStream = "stdout"
+	StderrStream = "stderr"
+	CloseStream  = "close"
 
 	AddEvent    = "add"
 	UpdateEvent = "update"
@@ -34,6 +45,9 @@ const (
 	WatchPvc            = "pvc"
 	WatchPv             = "pv"
 	WatchSc             = "sc"
+	WatchReplicaset     = "replicaset"
+	WatchConfigMap      = "configmap"
+	WatchSecret         = "secret"
 )
 
 type Response struct {
@@ -54,6 +68,16 @@ type TResponse struct {
 	Data      interface{} `json:"data"`
 }
 
+type ExecOutput struct {
+	Stream string `json:"stream"`
+	Data   []byte `json:"data"`
+}
+
+type ExecResult struct {
+	ExitCode int    `json:"exit_code"`
+	Error    string `json:"error"`
+}
+
 func (resp *TResponse) Serializer() ([]byte, error) {
 	return json.Marshal(resp)
 }
@@ -1,5 +1,11 @@
 package utils
 
+import (
+	"math/rand"
+	"sync"
+	"time"
+)
+
 type Void struct{}
 
 var Ok Void
@@ -14,3 +20,22 @@ func Contains(strList []string, str string) bool {
 }
 
 func int32Ptr(i int32) *int32 { return &i }
+
+// alphanums has no vowels to avoid bad words in generated names, like the kubernetes name generator.
+const alphanums = "bcdfghjklmnpqrstvwxz2456789"
+
+var (
+	randomMutex sync.Mutex
+	random      = rand.New(rand.NewSource(time.Now().UnixNano()))
+)
+
+// RandString generates a random string of n characters for resource names.
+func RandString(n int) string {
+	randomMutex.Lock()
+	defer randomMutex.Unlock()
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = alphanums[random.Intn(len(alphanums))]
+	}
+	return string(b)
+}
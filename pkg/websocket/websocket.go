@@ -8,29 +8,41 @@ import (
 	"k8s.io/klog"
 	"net/http"
 	"net/url"
+	"sync/atomic"
 	"time"
 )
 
 type SendResponse func(interface{}, string, string)
 
+// SendStream sends a frame of a streaming session, resType is the response type used
+// when the server does not speak the binary stream protocol.
+type SendStream func(string, *utils.Frame)
+
 type WebSocket struct {
-	Url          *url.URL
-	Token        string
-	RequestChan  chan *utils.Request
-	ResponseChan chan *utils.TResponse
-	Conn         *websocket.Conn
+	Url                *url.URL
+	Token              string
+	RequestChan        chan *utils.Request
+	ResponseChan       chan *utils.TResponse
+	StreamChan         chan *utils.Frame
+	Conn               *websocket.Conn
+	frameChan          chan *utils.Frame
+	binaryStream       int32
+	disconnectHandlers []func()
 }
 
 func NewWebSocket(
 	url *url.URL,
 	token string,
 	requestChan chan *utils.Request,
-	responseChan chan *utils.TResponse) *WebSocket {
+	responseChan chan *utils.TResponse,
+	streamChan chan *utils.Frame) *WebSocket {
 	return &WebSocket{
 		Url:          url,
 		Token:        token,
 		RequestChan:  requestChan,
 		ResponseChan: responseChan,
+		StreamChan:   streamChan,
+		frameChan:    make(chan *utils.Frame),
 	}
 }
 
@@ -39,13 +51,23 @@ func (ws *WebSocket) ReadRequest() {
 
 	ws.reconnectServer()
 	for {
-		_, data, err := ws.Conn.ReadMessage()
+		messageType, data, err := ws.Conn.ReadMessage()
 		if err != nil {
 			klog.Error("read err:", err)
 			ws.Conn.Close()
+			ws.onDisconnect()
 			ws.reconnectServer()
 			continue
 		}
+		if messageType == websocket.BinaryMessage {
+			frame, err := utils.DecodeFrame(data)
+			if err != nil {
+				klog.Errorf("decode stream frame error: %s", err)
+			} else {
+				ws.StreamChan <- frame
+			}
+			continue
+		}
 		klog.V(1).Infof("request data: %s", string(data))
 		request := &utils.Request{}
 		err = json.Unmarshal(data, request)
@@ -57,6 +79,17 @@ func (ws *WebSocket) ReadRequest() {
 	}
 }
 
+// AddDisconnectHandler registers a handler called every time the connection to server is lost.
+func (ws *WebSocket) AddDisconnectHandler(handler func()) {
+	ws.disconnectHandlers = append(ws.disconnectHandlers, handler)
+}
+
+func (ws *WebSocket) onDisconnect() {
+	for _, handler := range ws.disconnectHandlers {
+		handler()
+	}
+}
+
 func (ws *WebSocket) reconnectServer() {
 	err := ws.connectServer()
 	if err == nil {
@@ -80,13 +113,21 @@ func (ws *WebSocket) connectServer() error {
 	klog.Info("start connect to server ", ws.Url.String())
 	wsHeader := http.Header{}
 	wsHeader.Add("token", ws.Token)
-	d := &websocket.Dialer{TLSClientConfig: &tls.Config{RootCAs: nil, InsecureSkipVerify: true}}
+	d := &websocket.Dialer{
+		TLSClientConfig: &tls.Config{RootCAs: nil, InsecureSkipVerify: true},
+		Subprotocols:    []string{utils.BinaryStreamProtocol},
+	}
 	conn, _, err := d.Dial(ws.Url.String(), wsHeader)
 	if err != nil {
 		klog.Infof("connect to server %s error: %v, retry after 5 seconds\n", ws.Url.String(), err)
 		return err
 	} else {
-		klog.Infof("connect to server %s success\n", ws.Url.String())
+		klog.Infof("connect to server %s success, sub protocol %q\n", ws.Url.String(), conn.Subprotocol())
+		if conn.Subprotocol() == utils.BinaryStreamProtocol {
+			atomic.StoreInt32(&ws.binaryStream, 1)
+		} else {
+			atomic.StoreInt32(&ws.binaryStream, 0)
+		}
 		ws.Conn = conn
 		return nil
 	}
@@ -109,6 +150,16 @@ func (ws *WebSocket) WriteResponse() {
 				}
 				klog.V(1).Infof("write response %s success", string(respMsg))
 			}
+		case frame := <-ws.frameChan:
+			data, err := frame.Encode()
+			if err != nil {
+				klog.Errorf("encode %s frame of session %s err: %s", utils.StreamName(frame.Stream), frame.SessionId, err)
+				continue
+			}
+			err = ws.Conn.WriteMessage(websocket.BinaryMessage, data)
+			if err != nil {
+				klog.Errorf("write %s frame of session %s err: %s", utils.StreamName(frame.Stream), frame.SessionId, err)
+			}
 		}
 	}
 }
@@ -119,3 +170,24 @@ func (ws *WebSocket) SendResponse(resp interface{}, requestId, resType string) {
 		ws.ResponseChan <- tResp
 	}
 }
+
+func (ws *WebSocket) SendStream(resType string, frame *utils.Frame) {
+	if ws.Conn == nil {
+		return
+	}
+	if atomic.LoadInt32(&ws.binaryStream) == 1 {
+		ws.frameChan <- frame
+		return
+	}
+	switch frame.Stream {
+	case utils.StdoutFrame:
+		ws.SendResponse(frame.Payload, frame.SessionId, resType)
+	case utils.StderrFrame:
+		ws.SendResponse(&utils.ExecOutput{Stream: utils.StderrStream, Data: frame.Payload}, frame.SessionId, resType)
+	case utils.CloseFrame:
+		// exec and log sessions report their end by their own responses
+		if resType == utils.PortForwardType {
+			ws.SendResponse(&utils.ExecOutput{Stream: utils.CloseStream}, frame.SessionId, resType)
+		}
+	}
+}
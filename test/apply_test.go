@@ -0,0 +1,142 @@
+package test
+
+import (
+	"encoding/json"
+	"github.com/openspacee/ospagent/pkg/container/resource"
+	"github.com/openspacee/ospagent/pkg/kubernetes"
+	"github.com/openspacee/ospagent/pkg/utils/code"
+	"io/ioutil"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/rest"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+const configMapResources = `{
+  "kind": "APIResourceList",
+  "groupVersion": "v1",
+  "resources": [{"name": "configmaps", "singularName": "", "namespaced": true, "kind": "ConfigMap",
+    "verbs": ["create", "delete", "get", "list", "patch", "update", "watch"]}]
+}`
+
+const invalidStatus = `{"kind": "Status", "apiVersion": "v1", "status": "Failure", "message": "invalid data",
+  "reason": "Invalid", "code": 422}`
+
+func liveConfigMaps(names ...string) string {
+	var items []string
+	for _, name := range names {
+		items = append(items, `{"metadata": {"name": "`+name+`", "namespace": "default",
+		  "labels": {"`+resource.ApplySetLabel+`": "app"}}}`)
+	}
+	return `{"kind": "ConfigMapList", "apiVersion": "v1", "metadata": {}, "items": [` + strings.Join(items, ",") + `]}`
+}
+
+func configMapYaml(names ...string) string {
+	var docs []string
+	for _, name := range names {
+		docs = append(docs, "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: "+name+"\n  namespace: default\n")
+	}
+	return strings.Join(docs, "---\n")
+}
+
+// newFakeApplyResource serves the config maps of the default namespace, the apply of "bad" is invalid.
+func newFakeApplyResource(t *testing.T, live []string) (*resource.DynamicResource, func() []string, func()) {
+	var mutex sync.Mutex
+	var deleted []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		prefix := "/api/v1/namespaces/default/configmaps"
+		switch {
+		case r.URL.Path == "/api":
+			w.Write([]byte(`{"kind": "APIVersions", "versions": ["v1"]}`))
+		case r.URL.Path == "/apis":
+			w.Write([]byte(`{"kind": "APIGroupList", "groups": []}`))
+		case r.URL.Path == "/api/v1":
+			w.Write([]byte(configMapResources))
+		case r.URL.Path == prefix && r.Method == http.MethodGet:
+			w.Write([]byte(liveConfigMaps(live...)))
+		case strings.HasPrefix(r.URL.Path, prefix+"/") && r.Method == http.MethodPatch:
+			if strings.TrimPrefix(r.URL.Path, prefix+"/") == "bad" {
+				w.WriteHeader(http.StatusUnprocessableEntity)
+				w.Write([]byte(invalidStatus))
+				return
+			}
+			body, _ := ioutil.ReadAll(r.Body)
+			w.Write(body)
+		case strings.HasPrefix(r.URL.Path, prefix+"/") && r.Method == http.MethodDelete:
+			mutex.Lock()
+			deleted = append(deleted, strings.TrimPrefix(r.URL.Path, prefix+"/"))
+			mutex.Unlock()
+			w.Write([]byte(`{"kind": "Status", "apiVersion": "v1", "status": "Success"}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	config := &rest.Config{Host: server.URL}
+	dynamicClient, err := dynamic.NewForConfig(config)
+	if err != nil {
+		server.Close()
+		t.Fatal(err)
+	}
+	dc, err := discovery.NewDiscoveryClientForConfig(config)
+	if err != nil {
+		server.Close()
+		t.Fatal(err)
+	}
+	kubeClient := &kubernetes.KubeClient{DynamicClient: dynamicClient, Config: config, DiscoveryClient: dc}
+	d := resource.NewDynamicResource(kubeClient, &schema.GroupVersionResource{Version: "v1", Resource: "configmaps"})
+	getDeleted := func() []string {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return deleted
+	}
+	return d, getDeleted, server.Close
+}
+
+func TestApplyPrune(t *testing.T) {
+	tests := []struct {
+		name    string
+		yaml    string
+		live    []string
+		code    string
+		deleted []string
+	}{
+		{
+			name:    "prune objects not applied",
+			yaml:    configMapYaml("good"),
+			live:    []string{"good", "stale"},
+			code:    code.Success,
+			deleted: []string{"stale"},
+		},
+		{
+			name: "failed object survives",
+			yaml: configMapYaml("good", "bad"),
+			live: []string{"good", "bad", "stale"},
+			code: code.ApplyError,
+		},
+		{
+			name: "undecodable document skips prune",
+			yaml: configMapYaml("good") + "---\nkind: [\n",
+			live: []string{"good", "stale"},
+			code: code.ApplyError,
+		},
+	}
+	for _, test := range tests {
+		d, deleted, closeServer := newFakeApplyResource(t, test.live)
+		params, _ := json.Marshal(&resource.ApplyParams{YamlStr: test.yaml, Prune: true, ApplySet: "app"})
+		res := d.ApplyYaml(params)
+		if res.Code != test.code {
+			t.Errorf("%s: got code %s (%s), expected %s", test.name, res.Code, res.Msg, test.code)
+		}
+		if !reflect.DeepEqual(deleted(), test.deleted) {
+			t.Errorf("%s: got deleted %v, expected %v", test.name, deleted(), test.deleted)
+		}
+		closeServer()
+	}
+}
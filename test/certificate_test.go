@@ -0,0 +1,78 @@
+package test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"github.com/openspacee/ospagent/pkg/container/resource"
+	"k8s.io/api/core/v1"
+	extv1beta1 "k8s.io/api/extensions/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"math/big"
+	"testing"
+	"time"
+)
+
+func tlsSecret(t *testing.T, name string, notAfter time.Time, hosts ...string) *v1.Secret {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: hosts[0]},
+		DNSNames:     hosts,
+		NotBefore:    notAfter.Add(-365 * 24 * time.Hour),
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+		Type:       v1.SecretTypeTLS,
+		Data:       map[string][]byte{v1.TLSCertKey: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})},
+	}
+}
+
+func TestInspectCertificates(t *testing.T) {
+	now := time.Now()
+	ingress := &extv1beta1.Ingress{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default"},
+		Spec: extv1beta1.IngressSpec{TLS: []extv1beta1.IngressTLS{
+			{Hosts: []string{"a.example.com", "b.example.com"}, SecretName: "valid"},
+			{Hosts: []string{"old.example.com"}, SecretName: "expired"},
+			{Hosts: []string{"soon.example.com"}, SecretName: "expiring"},
+			{Hosts: []string{"none.example.com"}, SecretName: "absent"},
+		}},
+	}
+	res := resource.InspectCertificates(&resource.CertificatesInput{
+		Secrets: []*v1.Secret{
+			tlsSecret(t, "valid", now.Add(90*24*time.Hour), "a.example.com"),
+			tlsSecret(t, "expired", now.Add(-time.Hour), "old.example.com"),
+			tlsSecret(t, "expiring", now.Add(5*24*time.Hour), "*.example.com"),
+		},
+		Ingresses:    []*extv1beta1.Ingress{ingress},
+		Now:          now,
+		ExpiringDays: 30,
+	})
+	if len(res.Certificates) != 3 || res.Certificates[0].Secret != "expired" {
+		t.Fatalf("certificates not sorted by expiry: %+v", res.Certificates)
+	}
+	expected := map[string]string{
+		"a.example.com":    resource.CertificateValid,
+		"b.example.com":    resource.CertificateMismatch,
+		"old.example.com":  resource.CertificateExpired,
+		"soon.example.com": resource.CertificateExpiring,
+		"none.example.com": resource.CertificateMissing,
+	}
+	for _, h := range res.Hosts {
+		if h.Status != expected[h.Host] {
+			t.Errorf("host %s status %s, expected %s", h.Host, h.Status, expected[h.Host])
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package test
+
+import (
+	"encoding/json"
+	"github.com/openspacee/ospagent/pkg/container/resource"
+	"github.com/openspacee/ospagent/pkg/kubernetes"
+	"github.com/openspacee/ospagent/pkg/utils/code"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kube_client "k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+const conflictStatus = `{"kind": "Status", "apiVersion": "v1", "status": "Failure", "message": "the object has been modified",
+  "reason": "Conflict", "code": 409}`
+
+// newFakeConfigMapServer serves the config map "app" of the default namespace. The first update conflicts,
+// and the config map is edited concurrently by edit before it is read again.
+func newFakeConfigMapServer(t *testing.T, cm *v1.ConfigMap, edit func(*v1.ConfigMap)) (*resource.ConfigMap, func() *v1.ConfigMap, func()) {
+	updates := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Path != "/api/v1/namespaces/default/configmaps/app" {
+			http.NotFound(w, r)
+			return
+		}
+		switch r.Method {
+		case http.MethodGet:
+			json.NewEncoder(w).Encode(cm)
+		case http.MethodPut:
+			updates += 1
+			if updates == 1 {
+				edit(cm)
+				w.WriteHeader(http.StatusConflict)
+				w.Write([]byte(conflictStatus))
+				return
+			}
+			updated := &v1.ConfigMap{}
+			json.NewDecoder(r.Body).Decode(updated)
+			cm = updated
+			json.NewEncoder(w).Encode(cm)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	clientSet, err := kube_client.NewForConfig(&rest.Config{Host: server.URL})
+	if err != nil {
+		server.Close()
+		t.Fatal(err)
+	}
+	configMap := &resource.ConfigMap{KubeClient: &kubernetes.KubeClient{ClientSet: clientSet}}
+	return configMap, func() *v1.ConfigMap { return cm }, server.Close
+}
+
+func TestConfigMapKeys(t *testing.T) {
+	tests := []struct {
+		name       string
+		params     resource.ConfigMapKeysParams
+		remove     bool
+		edit       func(*v1.ConfigMap)
+		code       string
+		data       map[string]string
+		binaryData map[string][]byte
+	}{
+		{
+			name:   "set key added to binary data concurrently",
+			params: resource.ConfigMapKeysParams{Data: map[string]string{"c": "3"}},
+			edit: func(cm *v1.ConfigMap) {
+				cm.BinaryData["c"] = []byte{0xff}
+			},
+			code:       code.Success,
+			data:       map[string]string{"a": "1", "c": "3"},
+			binaryData: map[string][]byte{"b": {0xfe}},
+		},
+		{
+			name:   "set binary key moves it from data",
+			params: resource.ConfigMapKeysParams{BinaryData: map[string][]byte{"a": {0x01}}},
+			edit:   func(cm *v1.ConfigMap) {},
+			code:   code.Success,
+			data:   map[string]string{},
+			binaryData: map[string][]byte{
+				"a": {0x01},
+				"b": {0xfe},
+			},
+		},
+		{
+			name:   "remove key moved concurrently",
+			params: resource.ConfigMapKeysParams{Keys: []string{"a"}},
+			remove: true,
+			edit: func(cm *v1.ConfigMap) {
+				delete(cm.Data, "a")
+				cm.BinaryData["a"] = []byte{0x01}
+			},
+			code:       code.Success,
+			data:       map[string]string{},
+			binaryData: map[string][]byte{"b": {0xfe}},
+		},
+		{
+			name:       "remove key removed concurrently",
+			params:     resource.ConfigMapKeysParams{Keys: []string{"a"}},
+			remove:     true,
+			edit:       func(cm *v1.ConfigMap) { delete(cm.Data, "a") },
+			code:       code.ParamsError,
+			data:       map[string]string{},
+			binaryData: map[string][]byte{"b": {0xfe}},
+		},
+	}
+	for _, test := range tests {
+		cm := &v1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "default"},
+			Data:       map[string]string{"a": "1"},
+			BinaryData: map[string][]byte{"b": {0xfe}},
+		}
+		configMap, current, closeServer := newFakeConfigMapServer(t, cm, test.edit)
+		test.params.Name = "app"
+		test.params.Namespace = "default"
+		params, _ := json.Marshal(&test.params)
+		action := configMap.SetKeys
+		if test.remove {
+			action = configMap.RemoveKeys
+		}
+		resp := action(params)
+		if resp.Code != test.code {
+			t.Errorf("%s: got code %s (%s), expected %s", test.name, resp.Code, resp.Msg, test.code)
+		}
+		got := current()
+		if len(got.Data) == 0 && len(test.data) == 0 {
+			got.Data = test.data
+		}
+		if !reflect.DeepEqual(got.Data, test.data) || !reflect.DeepEqual(got.BinaryData, test.binaryData) {
+			t.Errorf("%s: got data %v binary data %v, expected %v %v", test.name, got.Data, got.BinaryData, test.data, test.binaryData)
+		}
+		closeServer()
+	}
+}
@@ -0,0 +1,132 @@
+package test
+
+import (
+	"github.com/openspacee/ospagent/pkg/container/resource"
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"testing"
+	"time"
+)
+
+func findRule(findings []*resource.Finding, rule, name string) *resource.Finding {
+	for _, f := range findings {
+		if f.Rule == rule && f.Name == name {
+			return f
+		}
+	}
+	return nil
+}
+
+func TestDiagnose(t *testing.T) {
+	meta := func(name string) metav1.ObjectMeta {
+		return metav1.ObjectMeta{Name: name, Namespace: "default"}
+	}
+	crashing := &v1.Pod{
+		ObjectMeta: meta("crashing"),
+		Status: v1.PodStatus{
+			Phase: v1.PodRunning,
+			ContainerStatuses: []v1.ContainerStatus{{
+				Name:                 "app",
+				RestartCount:         5,
+				State:                v1.ContainerState{Waiting: &v1.ContainerStateWaiting{Reason: "CrashLoopBackOff"}},
+				LastTerminationState: v1.ContainerState{Terminated: &v1.ContainerStateTerminated{Reason: "OOMKilled", ExitCode: 137}},
+			}},
+		},
+	}
+	pulling := &v1.Pod{
+		ObjectMeta: meta("pulling"),
+		Status: v1.PodStatus{
+			Phase: v1.PodPending,
+			ContainerStatuses: []v1.ContainerStatus{{
+				Name:  "app",
+				Image: "nginx:none",
+				State: v1.ContainerState{Waiting: &v1.ContainerStateWaiting{Reason: "ImagePullBackOff"}},
+			}},
+		},
+	}
+	unschedulable := &v1.Pod{
+		ObjectMeta: meta("unschedulable"),
+		Status: v1.PodStatus{
+			Phase: v1.PodPending,
+			Conditions: []v1.PodCondition{{
+				Type:    v1.PodScheduled,
+				Status:  v1.ConditionFalse,
+				Reason:  v1.PodReasonUnschedulable,
+				Message: "0/3 nodes are available: 3 Insufficient cpu.",
+			}},
+		},
+	}
+	healthy := &v1.Pod{ObjectMeta: meta("healthy"), Status: v1.PodStatus{Phase: v1.PodRunning}}
+	service := &v1.Service{ObjectMeta: meta("web"), Spec: v1.ServiceSpec{Selector: map[string]string{"app": "web"}}}
+	endpoints := &v1.Endpoints{
+		ObjectMeta: meta("web"),
+		Subsets:    []v1.EndpointSubset{{NotReadyAddresses: []v1.EndpointAddress{{IP: "10.0.0.1"}}}},
+	}
+	pvc := &v1.PersistentVolumeClaim{ObjectMeta: meta("data"), Status: v1.PersistentVolumeClaimStatus{Phase: v1.ClaimPending}}
+	deployment := &appsv1.Deployment{
+		ObjectMeta: meta("web"),
+		Status: appsv1.DeploymentStatus{Conditions: []appsv1.DeploymentCondition{{
+			Type:   appsv1.DeploymentProgressing,
+			Status: v1.ConditionFalse,
+			Reason: "ProgressDeadlineExceeded",
+		}}},
+	}
+	now := metav1.NewTime(time.Now())
+	event := func(kind, name, reason, message string) *v1.Event {
+		return &v1.Event{
+			ObjectMeta:     meta(name + "." + reason),
+			InvolvedObject: v1.ObjectReference{Kind: kind, Namespace: "default", Name: name},
+			Type:           v1.EventTypeWarning,
+			Reason:         reason,
+			Message:        message,
+			LastTimestamp:  now,
+		}
+	}
+
+	findings := resource.Diagnose(&resource.DiagnoseInput{
+		Pods:                   []*v1.Pod{healthy, crashing, pulling, unschedulable},
+		Services:               []*v1.Service{service},
+		Endpoints:              []*v1.Endpoints{endpoints},
+		PersistentVolumeClaims: []*v1.PersistentVolumeClaim{pvc},
+		Deployments:            []*appsv1.Deployment{deployment},
+		Events: []*v1.Event{
+			event("Pod", "healthy", "Unhealthy", "Readiness probe failed"),
+			event("Pod", "crashing", "BackOff", "Back-off restarting failed container"),
+			event("PersistentVolumeClaim", "data", "ProvisioningFailed", "storageclass not found"),
+		},
+	})
+
+	cases := []struct {
+		rule string
+		name string
+	}{
+		{resource.CrashLoopRule, "crashing"},
+		{resource.OOMKilledRule, "crashing"},
+		{resource.ImagePullRule, "pulling"},
+		{resource.UnschedulableRule, "unschedulable"},
+		{resource.ProbeFailureRule, "healthy"},
+		{resource.NoReadyEndpointsRule, "web"},
+		{resource.PendingClaimRule, "data"},
+		{resource.ProgressDeadlineRule, "web"},
+	}
+	for _, c := range cases {
+		if findRule(findings, c.rule, c.name) == nil {
+			t.Errorf("expected finding %s of %s", c.rule, c.name)
+		}
+	}
+	if len(findings) != len(cases) {
+		t.Errorf("expected %d findings, got %d", len(cases), len(findings))
+	}
+	if f := findRule(findings, resource.CrashLoopRule, "crashing"); f != nil && len(f.Events) != 1 {
+		t.Errorf("expected the back-off event of the crashing pod, got %v", f.Events)
+	}
+	if f := findRule(findings, resource.PendingClaimRule, "data"); f != nil && len(f.Events) != 1 {
+		t.Errorf("expected the provisioning event of the claim, got %v", f.Events)
+	}
+	for i := 1; i < len(findings); i++ {
+		if findings[i-1].Severity == resource.WarningSeverity && findings[i].Severity == resource.CriticalSeverity {
+			t.Errorf("findings are not ranked by severity")
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package test
+
+import (
+	"bytes"
+	"github.com/openspacee/ospagent/pkg/container/resource"
+	"github.com/openspacee/ospagent/pkg/utils"
+	"strings"
+	"testing"
+)
+
+func TestFrame(t *testing.T) {
+	frame := &utils.Frame{SessionId: "s1/conn", Stream: utils.StdoutFrame, Payload: []byte("data")}
+	data, err := frame.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := utils.DecodeFrame(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded.SessionId != frame.SessionId || decoded.Stream != frame.Stream || !bytes.Equal(decoded.Payload, frame.Payload) {
+		t.Errorf("got frame %+v, expected %+v", decoded, frame)
+	}
+
+	longId := strings.Repeat("a", utils.MaxFrameSessionIdLength+1)
+	if _, err := (&utils.Frame{SessionId: longId}).Encode(); err == nil {
+		t.Errorf("encode frame with session id of %d bytes succeeded", len(longId))
+	}
+	if _, err := utils.DecodeFrame([]byte{utils.FrameVersion, utils.StdinFrame, 0, 0, 'x'}); err == nil {
+		t.Errorf("decode frame with blank session id succeeded")
+	}
+	if _, err := utils.DecodeFrame([]byte{utils.FrameVersion, utils.StdinFrame, 0, 8, 'x'}); err == nil {
+		t.Errorf("decode frame with short session id succeeded")
+	}
+
+	sessions := resource.NewSessionManager(0, 0, 0)
+	if err := sessions.Add(longId, resource.ExecSession, "default", "nginx", newRecordSession()); err == nil {
+		t.Errorf("add session with id of %d bytes succeeded", len(longId))
+	}
+	if err := sessions.Add("s1", resource.ExecSession, "default", "nginx", newRecordSession()); err != nil {
+		t.Fatal(err)
+	}
+	if err := sessions.CheckId("s1"); err == nil {
+		t.Errorf("check id of a running session succeeded")
+	}
+}
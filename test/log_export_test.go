@@ -0,0 +1,147 @@
+package test
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"github.com/openspacee/ospagent/pkg/container/resource"
+	"github.com/openspacee/ospagent/pkg/kubernetes"
+	"github.com/openspacee/ospagent/pkg/utils"
+	"github.com/openspacee/ospagent/pkg/utils/code"
+	"io/ioutil"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	informersv1 "k8s.io/client-go/informers/core/v1"
+	kube_client "k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+const exportLog = "2020-01-01T00:00:01Z line one\n" +
+	"2020-01-01T00:00:02Z line two\n" +
+	"2020-01-01T00:00:03Z line three\n"
+
+// podInformerRegistry serves the pod informer only.
+type podInformerRegistry struct {
+	kubernetes.InformerRegistry
+	pods informersv1.PodInformer
+}
+
+func (r *podInformerRegistry) PodInformer() informersv1.PodInformer {
+	return r.pods
+}
+
+// newFakeExportPod serves the log of the container app of the pod web, the streamed archive is
+// collected into the returned buffer.
+func newFakeExportPod(t *testing.T) (*resource.Pod, *bytes.Buffer, func()) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/namespaces/default/pods/web/log" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(exportLog))
+	}))
+	clientSet, err := kube_client.NewForConfig(&rest.Config{Host: server.URL})
+	if err != nil {
+		server.Close()
+		t.Fatal(err)
+	}
+	pods := informers.NewSharedInformerFactory(clientSet, 0).Core().V1().Pods()
+	pods.Informer().GetIndexer().Add(&v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default"},
+		Spec:       v1.PodSpec{Containers: []v1.Container{{Name: "app"}}},
+	})
+	kubeClient := &kubernetes.KubeClient{ClientSet: clientSet, InformerRegistry: &podInformerRegistry{pods: pods}}
+	archive := &bytes.Buffer{}
+	sendStream := func(resType string, frame *utils.Frame) {
+		if frame.Stream == utils.StdoutFrame {
+			archive.Write(frame.Payload)
+		}
+	}
+	pod := resource.NewPod(kubeClient, nil, sendStream, resource.NewWatchResource(nil), nil)
+	return pod, archive, server.Close
+}
+
+func readExportArchive(t *testing.T, archive *bytes.Buffer) map[string]string {
+	gzipReader, err := gzip.NewReader(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{}
+	tarReader := tar.NewReader(gzipReader)
+	for {
+		header, err := tarReader.Next()
+		if err != nil {
+			break
+		}
+		data, err := ioutil.ReadAll(tarReader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[header.Name] = string(data)
+	}
+	return files
+}
+
+func TestLogExport(t *testing.T) {
+	lines := strings.SplitAfter(exportLog, "\n")
+	until := metav1.NewTime(time.Date(2020, 1, 1, 0, 0, 2, 0, time.UTC))
+	tests := []struct {
+		name      string
+		params    resource.ExportLogsParams
+		log       string
+		truncated bool
+	}{
+		{
+			name: "whole log",
+			log:  exportLog,
+		},
+		{
+			name:      "log truncated at the container cap",
+			params:    resource.ExportLogsParams{MaxContainerBytes: int64(len(lines[0]) + len(lines[1]) + 1)},
+			log:       lines[0] + lines[1],
+			truncated: true,
+		},
+		{
+			name:      "log truncated at the total cap",
+			params:    resource.ExportLogsParams{MaxTotalBytes: int64(len(lines[0]))},
+			log:       lines[0],
+			truncated: true,
+		},
+		{
+			name:   "lines after the until time dropped",
+			params: resource.ExportLogsParams{UntilTime: &until},
+			log:    lines[0] + lines[1],
+		},
+	}
+	for _, test := range tests {
+		pod, archive, closeServer := newFakeExportPod(t)
+		test.params.Namespace = "default"
+		test.params.Pods = []string{"web"}
+		params, _ := json.Marshal(&test.params)
+		res := pod.ExportLogs("r1", params)
+		closeServer()
+		if res.Code != code.Success {
+			t.Errorf("%s: got code %s (%s), expected %s", test.name, res.Code, res.Msg, code.Success)
+			continue
+		}
+		manifest := res.Data.(*resource.ExportLogsManifest)
+		if manifest.Truncated != test.truncated || manifest.TotalBytes != int64(len(test.log)) {
+			t.Errorf("%s: got truncated %v total %d, expected %v %d", test.name,
+				manifest.Truncated, manifest.TotalBytes, test.truncated, len(test.log))
+		}
+		files := readExportArchive(t, archive)
+		if files["web/app.log"] != test.log {
+			t.Errorf("%s: got log %q, expected %q", test.name, files["web/app.log"], test.log)
+		}
+		if _, ok := files["manifest.json"]; !ok {
+			t.Errorf("%s: manifest is not in the archive", test.name)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package test
+
+import (
+	"github.com/openspacee/ospagent/pkg/container/resource"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestLogFilterWriter(t *testing.T) {
+	start := time.Now()
+	tests := []struct {
+		name   string
+		params resource.LogFilterParams
+		// chunks are written at the seconds after start
+		chunks  []string
+		seconds []int
+		lines   []string
+	}{
+		{
+			name:   "lines split across writes",
+			chunks: []string{"fir", "st\nsec", "ond\nthi", "rd\n"},
+			lines:  []string{"first\n", "second\n", "third\n"},
+		},
+		{
+			name:   "utf8 split mid rune",
+			chunks: []string{"h\xc3", "\xa9llo\n", "\xe4\xb8", "\xad\n"},
+			lines:  []string{"héllo\n", "中\n"},
+		},
+		{
+			name:   "last line without newline",
+			chunks: []string{"one\ntw", "o"},
+			lines:  []string{"one\n", "two"},
+		},
+		{
+			name:   "include and exclude",
+			params: resource.LogFilterParams{Include: "ERROR|WARN", Exclude: "healthz"},
+			chunks: []string{"INFO start\nERROR db down\nWARN GET /healthz\nWARN slow\n"},
+			lines:  []string{"ERROR db down\n", "WARN slow\n"},
+		},
+		{
+			name:   "json fields",
+			params: resource.LogFilterParams{JsonFields: map[string]string{"level": "error", "req.method": "POST"}},
+			chunks: []string{
+				`{"level":"error","req":{"method":"POST"}}` + "\n",
+				`{"level":"error","req":{"method":"GET"}}` + "\n",
+				`{"level":"info","req":{"method":"POST"}}` + "\n",
+				"not json\n",
+			},
+			lines: []string{`{"level":"error","req":{"method":"POST"}}` + "\n"},
+		},
+		{
+			name:    "burst over rate limit",
+			params:  resource.LogFilterParams{LinesPerSecond: 2},
+			chunks:  []string{"1\n2\n3\n4\n5\n", "6\n", "7\n8\n9\n"},
+			seconds: []int{0, 1, 1},
+			lines: []string{
+				"1\n", "2\n",
+				"... 3 lines suppressed ...\n", "6\n", "7\n",
+				"... 2 lines suppressed ...\n",
+			},
+		},
+	}
+	for _, test := range tests {
+		now := start
+		var lines []string
+		writer, err := resource.NewLogFilterWriter(&test.params, false, func() time.Time { return now }, func(line []byte) {
+			lines = append(lines, string(line))
+		})
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		for i, chunk := range test.chunks {
+			if test.seconds != nil {
+				now = start.Add(time.Duration(test.seconds[i]) * time.Second)
+			}
+			writer.Write([]byte(chunk))
+		}
+		writer.Close()
+		if !reflect.DeepEqual(lines, test.lines) {
+			t.Errorf("%s: got lines %q, expected %q", test.name, lines, test.lines)
+		}
+	}
+}
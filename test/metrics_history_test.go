@@ -0,0 +1,119 @@
+package test
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/openspacee/ospagent/pkg/container/resource"
+	"github.com/openspacee/ospagent/pkg/kubernetes"
+	"github.com/openspacee/ospagent/pkg/utils/code"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestMetricsHistory(t *testing.T) {
+	// node-1 uses 10m cpu more every sample, node-2 uses 1m
+	cpu := int64(0)
+	client, closeServer := newFakeMetricsClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/apis/metrics.k8s.io/v1beta1/nodes":
+			fmt.Fprintf(w, `{"kind": "NodeMetricsList", "apiVersion": "metrics.k8s.io/v1beta1", "items": [
+			  {"metadata": {"name": "node-2"}, "usage": {"cpu": "1m", "memory": "1Mi"}},
+			  {"metadata": {"name": "node-1"}, "usage": {"cpu": "%dm", "memory": "1Mi"}}]}`, cpu)
+		case "/apis/metrics.k8s.io/v1beta1/pods":
+			w.Write([]byte(`{"kind": "PodMetricsList", "apiVersion": "metrics.k8s.io/v1beta1", "items": []}`))
+		default:
+			http.NotFound(w, r)
+		}
+	})
+	defer closeServer()
+
+	// the history keeps 3 raw points and 3 rollups of 2 raw points
+	history := resource.NewMetricsHistory(&kubernetes.KubeClient{Metrics: client}, &resource.MetricsHistoryOptions{
+		Interval:        time.Hour,
+		Retention:       3 * time.Hour,
+		RollupInterval:  2 * time.Hour,
+		RollupRetention: 6 * time.Hour,
+	})
+	hour := int64(time.Hour / time.Second)
+	for i := int64(0); i < 10; i++ {
+		cpu = i * 10
+		history.Sample(time.Unix(i*hour, 0))
+	}
+
+	tests := []struct {
+		name       string
+		start      int64
+		end        int64
+		resolution string
+		// timestamps and cpu of the node-1 points
+		points [][2]int64
+	}{
+		{
+			name:       "raw points after wraparound",
+			start:      1,
+			end:        9 * hour,
+			resolution: resource.RawResolution,
+			points:     [][2]int64{{7 * hour, 70}, {8 * hour, 80}, {9 * hour, 90}},
+		},
+		{
+			name:       "raw range boundaries are inclusive",
+			start:      8 * hour,
+			end:        8 * hour,
+			resolution: resource.RawResolution,
+			points:     [][2]int64{{8 * hour, 80}},
+		},
+		{
+			name:       "rollups after wraparound with the partial bucket",
+			start:      1,
+			end:        9 * hour,
+			resolution: resource.RollupResolution,
+			points:     [][2]int64{{2 * hour, 25}, {4 * hour, 45}, {6 * hour, 65}, {8 * hour, 85}},
+		},
+		{
+			name:       "rollup range boundaries are inclusive",
+			start:      4 * hour,
+			end:        6 * hour,
+			resolution: resource.RollupResolution,
+			points:     [][2]int64{{4 * hour, 45}, {6 * hour, 65}},
+		},
+		{
+			name:       "rollup bucket starting before the range",
+			start:      5 * hour,
+			end:        9 * hour,
+			resolution: resource.RollupResolution,
+			points:     [][2]int64{{6 * hour, 65}, {8 * hour, 85}},
+		},
+	}
+	for _, test := range tests {
+		params, _ := json.Marshal(&resource.MetricsRangeParams{
+			Kind:       resource.NodeMetricsKind,
+			Names:      []string{"node-1", "node-2"},
+			Start:      test.start,
+			End:        test.end,
+			Resolution: test.resolution,
+		})
+		res := history.Range(params)
+		if res.Code != code.Success {
+			t.Fatalf("%s: %s", test.name, res.Msg)
+		}
+		series := res.Data.([]*resource.BuildMetricsSeries)
+		if len(series) != 2 || series[0].Name != "node-1" || series[1].Name != "node-2" {
+			t.Fatalf("%s: got series %+v, expected node-1 and node-2", test.name, series)
+		}
+		var points [][2]int64
+		for _, p := range series[0].Points {
+			points = append(points, [2]int64{p.Timestamp, p.Cpu})
+		}
+		if !reflect.DeepEqual(points, test.points) {
+			t.Errorf("%s: got points %v, expected %v", test.name, points, test.points)
+		}
+		for _, p := range series[1].Points {
+			if p.Cpu != 1 {
+				t.Errorf("%s: got node-2 cpu %d, expected 1", test.name, p.Cpu)
+			}
+		}
+	}
+}
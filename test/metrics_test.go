@@ -0,0 +1,81 @@
+package test
+
+import (
+	"github.com/openspacee/ospagent/pkg/kubernetes"
+	"k8s.io/client-go/rest"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+const nodeMetricsList = `{
+  "kind": "NodeMetricsList",
+  "apiVersion": "metrics.k8s.io/v1beta1",
+  "items": [{
+    "metadata": {"name": "node-1"},
+    "timestamp": "2020-01-01T00:00:00Z",
+    "window": "30s",
+    "usage": {"cpu": "250m", "memory": "1Gi"}
+  }]
+}`
+
+const podMetrics = `{
+  "kind": "PodMetrics",
+  "apiVersion": "metrics.k8s.io/v1beta1",
+  "metadata": {"name": "nginx", "namespace": "default"},
+  "timestamp": "2020-01-01T00:00:00Z",
+  "window": "30s",
+  "containers": [{"name": "nginx", "usage": {"cpu": "10m", "memory": "20Mi"}}]
+}`
+
+func newFakeMetricsClient(t *testing.T, handler http.HandlerFunc) (*kubernetes.MetricsClient, func()) {
+	server := httptest.NewServer(handler)
+	client, err := kubernetes.NewMetricsClient(&rest.Config{Host: server.URL})
+	if err != nil {
+		server.Close()
+		t.Fatal(err)
+	}
+	return client, server.Close
+}
+
+func TestMetrics(t *testing.T) {
+	client, closeServer := newFakeMetricsClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/apis/metrics.k8s.io/v1beta1/nodes":
+			w.Write([]byte(nodeMetricsList))
+		case "/apis/metrics.k8s.io/v1beta1/namespaces/default/pods/nginx":
+			w.Write([]byte(podMetrics))
+		default:
+			http.NotFound(w, r)
+		}
+	})
+	defer closeServer()
+
+	nodes, err := client.ListNodeMetrics("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(nodes) != 1 || nodes[0].Name != "node-1" || nodes[0].Usage.Cpu().MilliValue() != 250 {
+		t.Errorf("unexpected node metrics %+v", nodes)
+	}
+	pod, err := client.GetPodMetrics("default", "nginx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pod.Containers) != 1 || pod.Containers[0].Usage.Memory().Value() != 20*1024*1024 {
+		t.Errorf("unexpected pod metrics %+v", pod)
+	}
+}
+
+func TestMetricsUnavailable(t *testing.T) {
+	client, closeServer := newFakeMetricsClient(t, func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "service unavailable", http.StatusServiceUnavailable)
+	})
+	defer closeServer()
+
+	_, err := client.ListPodMetrics("", "")
+	if err == nil || !kubernetes.IsMetricsUnavailable(err) {
+		t.Errorf("expected metrics unavailable error, got %v", err)
+	}
+}
@@ -11,10 +11,9 @@ func TestNode(t *testing.T) {
 	kubeClient := kubernetes.NewKubeClient("../kubeconfig")
 
 	node := resource.Node{
-		KubeClient:   kubeClient,
-		SendResponse: nil,
+		KubeClient: kubeClient,
 	}
 
-	res := node.List(nil)
+	res := node.List([]byte("{}"))
 	fmt.Println(res.Data)
 }
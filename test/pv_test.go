@@ -11,8 +11,7 @@ func TestPV(t *testing.T) {
 	kubeClient := kubernetes.NewKubeClient("../kubeconfig")
 
 	pv := resource.PersistentVolume{
-		KubeClient:   kubeClient,
-		SendResponse: nil,
+		KubeClient: kubeClient,
 	}
 
 	res := pv.List(nil)
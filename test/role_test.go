@@ -0,0 +1,41 @@
+package test
+
+import (
+	"github.com/openspacee/ospagent/pkg/container/resource"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"testing"
+)
+
+func TestResolveAggregation(t *testing.T) {
+	clusterRole := func(name string, labels map[string]string, verbs ...string) *rbacv1.ClusterRole {
+		return &rbacv1.ClusterRole{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Labels: labels},
+			Rules:      []rbacv1.PolicyRule{{APIGroups: []string{""}, Resources: []string{"pods"}, Verbs: verbs}},
+		}
+	}
+	aggregated := &rbacv1.ClusterRole{
+		ObjectMeta: metav1.ObjectMeta{Name: "monitoring"},
+		AggregationRule: &rbacv1.AggregationRule{ClusterRoleSelectors: []metav1.LabelSelector{
+			{MatchLabels: map[string]string{"aggregate-to-monitoring": "true"}},
+		}},
+	}
+	selected := map[string]string{"aggregate-to-monitoring": "true"}
+	roles := []*rbacv1.ClusterRole{
+		aggregated,
+		clusterRole("view-pods", selected, "get", "list"),
+		clusterRole("view-pods-copy", selected, "get", "list"),
+		clusterRole("watch-pods", selected, "watch"),
+		clusterRole("edit-pods", nil, "update"),
+	}
+	res, err := resource.ResolveAggregation(aggregated, roles)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Sources) != 3 {
+		t.Errorf("expected 3 sources, got %d", len(res.Sources))
+	}
+	if len(res.Rules) != 2 {
+		t.Errorf("expected the duplicated rules merged into 2 rules, got %v", res.Rules)
+	}
+}
@@ -0,0 +1,101 @@
+package test
+
+import (
+	"fmt"
+	"github.com/openspacee/ospagent/pkg/container/resource"
+	"github.com/openspacee/ospagent/pkg/utils"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordSession records the payloads of its frames, the frames wait for release when it is set.
+type recordSession struct {
+	mutex    sync.Mutex
+	payloads []string
+	release  chan struct{}
+	closed   chan struct{}
+}
+
+func newRecordSession() *recordSession {
+	return &recordSession{closed: make(chan struct{})}
+}
+
+func (s *recordSession) HandleFrame(frame *utils.Frame) error {
+	if s.release != nil {
+		<-s.release
+	}
+	s.mutex.Lock()
+	s.payloads = append(s.payloads, string(frame.Payload))
+	s.mutex.Unlock()
+	return nil
+}
+
+func (s *recordSession) Close() error {
+	close(s.closed)
+	return nil
+}
+
+func (s *recordSession) count() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return len(s.payloads)
+}
+
+func TestSessionEnqueue(t *testing.T) {
+	sessions := resource.NewSessionManager(0, 0, 0)
+	session := newRecordSession()
+	if err := sessions.Add("s1", resource.ExecSession, "default", "nginx", session); err != nil {
+		t.Fatal(err)
+	}
+	if err := sessions.Enqueue(&utils.Frame{SessionId: "s2", Stream: utils.StdinFrame}); err == nil {
+		t.Errorf("enqueue to unknown session succeeded")
+	}
+	for i := 0; i < 100; i++ {
+		frame := &utils.Frame{SessionId: "s1", Stream: utils.StdinFrame, Payload: []byte(fmt.Sprint(i))}
+		if i%2 == 1 {
+			// frames of the sub streams go to the session too
+			frame.SessionId = "s1/conn"
+		}
+		if err := sessions.Enqueue(frame); err != nil {
+			t.Fatal(err)
+		}
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for session.count() < 100 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	for i, payload := range session.payloads {
+		if payload != fmt.Sprint(i) {
+			t.Fatalf("frame %d has payload %s, frames are out of order", i, payload)
+		}
+	}
+	if len(session.payloads) != 100 {
+		t.Errorf("got %d frames, expected 100", len(session.payloads))
+	}
+}
+
+func TestSessionQueueFull(t *testing.T) {
+	sessions := resource.NewSessionManager(0, 0, 0)
+	session := newRecordSession()
+	session.release = make(chan struct{})
+	defer close(session.release)
+	if err := sessions.Add("s1", resource.ExecSession, "default", "nginx", session); err != nil {
+		t.Fatal(err)
+	}
+	var err error
+	for i := 0; i < 1000 && err == nil; i++ {
+		err = sessions.Enqueue(&utils.Frame{SessionId: "s1", Stream: utils.StdinFrame})
+	}
+	if err == nil {
+		t.Fatal("enqueue to a stalled session never failed")
+	}
+	select {
+	case <-session.closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("stalled session is not closed")
+	}
+	if sessions.Get("s1") != nil {
+		t.Errorf("stalled session is not removed")
+	}
+}